@@ -0,0 +1,68 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	lastSeenKey = "last_seen"
+	// lastSeenFlushInterval is how often a changed last-seen time is
+	// persisted, rather than writing on every message.
+	lastSeenFlushInterval = time.Minute
+)
+
+// activityTracker records when the account last sent or received a message
+// or connected, so that a connection that is up but dead can be spotted.
+type activityTracker struct {
+	mu       sync.Mutex
+	lastSeen time.Time
+	dirty    bool
+}
+
+func (t *activityTracker) touch(at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if at.After(t.lastSeen) {
+		t.lastSeen = at
+		t.dirty = true
+	}
+}
+
+func (t *activityTracker) get() time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.lastSeen
+}
+
+// saveLastSeen persists the last-seen time if it changed since the last save.
+func (api *WhatsAppAPI) saveLastSeen() {
+	api.activity.mu.Lock()
+	lastSeen, dirty := api.activity.lastSeen, api.activity.dirty
+	api.activity.dirty = false
+	api.activity.mu.Unlock()
+	if !dirty {
+		return
+	}
+
+	if err := api.settings.save(lastSeenKey, lastSeen); err != nil {
+		api.log.Errorf("Failed to save last seen time: %v", err)
+		api.activity.mu.Lock()
+		api.activity.dirty = true
+		api.activity.mu.Unlock()
+	}
+}
+
+// flushLastSeen saves the last-seen time periodically until stop is closed.
+func (api *WhatsAppAPI) flushLastSeen(stop <-chan struct{}) {
+	ticker := time.NewTicker(lastSeenFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			api.saveLastSeen()
+		}
+	}
+}
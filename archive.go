@@ -0,0 +1,429 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// archiveQueueSize bounds the voice notes waiting to be archived; more
+	// are dropped with a warning rather than holding up message handling.
+	archiveQueueSize = 100
+	archiveAttempts  = 3
+	archiveBackoff   = 5 * time.Second
+	archiveTimeout   = 2 * time.Minute
+	// maxPresignTTL is the longest a SigV4 presigned URL can be valid.
+	maxPresignTTL = 7 * 24 * time.Hour
+)
+
+// archivableID matches the message IDs voice notes are archived under. IDs
+// come from the sender, so anything else could point outside the archive.
+var archivableID = regexp.MustCompile(`^[A-Za-z0-9]+$`)
+
+// MediaStore keeps archived media. Put stores data under key and returns the
+// URL it is recorded with.
+type MediaStore interface {
+	Put(ctx context.Context, key, contentType string, data []byte) (string, error)
+}
+
+// PresignedStore is implemented by media stores that can hand out temporary
+// download URLs, so clients fetch archived media from the store rather than
+// through this service.
+type PresignedStore interface {
+	MediaStore
+	PresignGet(key string, expires time.Duration) (string, error)
+}
+
+// localStore keeps media in a directory on disk.
+type localStore struct {
+	dir string
+	// publicURL is the base URL the directory is served from, if any;
+	// otherwise file URLs are recorded.
+	publicURL string
+}
+
+func (s *localStore) Put(ctx context.Context, key, contentType string, data []byte) (string, error) {
+	path := filepath.Join(s.dir, filepath.FromSlash(key))
+	if rel, err := filepath.Rel(s.dir, path); err != nil || rel == "." || !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("key %q is outside the archive directory", key)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return "", err
+	}
+	if s.publicURL != "" {
+		return s.publicURL + "/" + key, nil
+	}
+	return (&url.URL{Scheme: "file", Path: path}).String(), nil
+}
+
+// s3Store keeps media in an S3-compatible bucket. Google Cloud Storage is
+// used through its S3-compatible XML API with HMAC keys.
+type s3Store struct {
+	endpoint        string
+	bucket          string
+	region          string
+	accessKeyID     string
+	secretAccessKey string
+	// publicURL is the base of the recorded URLs, if the files are served
+	// from somewhere other than the endpoint, e.g. a CDN.
+	publicURL string
+}
+
+// objectURL is where key is stored, using path-style addressing, which all
+// S3-compatible stores support.
+func (s *s3Store) objectURL(key string) string {
+	return s.endpoint + "/" + s.bucket + "/" + key
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// sign computes the Signature Version 4 signature of a canonical request
+// made at t, returning it with the credential scope.
+func (s *s3Store) sign(t time.Time, canonicalRequest string) (signature, scope string) {
+	amzDate := t.Format("20060102T150405Z")
+	date := t.Format("20060102")
+	scope = date + "/" + s.region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	signingKey := hmacSHA256([]byte("AWS4"+s.secretAccessKey), date)
+	signingKey = hmacSHA256(signingKey, s.region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(signingKey, stringToSign)), scope
+}
+
+// Put uploads data with a request signed with AWS Signature Version 4.
+// Keys are expected to need no escaping.
+func (s *s3Store) Put(ctx context.Context, key, contentType string, data []byte) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.objectURL(key), bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	payloadHash := sha256.Sum256(data)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	signedHeaders := "content-type;host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		http.MethodPut,
+		req.URL.EscapedPath(),
+		"",
+		"content-type:" + contentType,
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + hex.EncodeToString(payloadHash[:]),
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	signature, scope := s.sign(now, canonicalRequest)
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.accessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("unexpected status %d: %s", resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	if s.publicURL != "" {
+		return s.publicURL + "/" + key, nil
+	}
+	return s.objectURL(key), nil
+}
+
+// PresignGet returns a URL that downloads key without credentials until it
+// expires, signed in the query string.
+func (s *s3Store) PresignGet(key string, expires time.Duration) (string, error) {
+	target, err := url.Parse(s.objectURL(key))
+	if err != nil {
+		return "", err
+	}
+	now := time.Now().UTC()
+	query := url.Values{
+		"X-Amz-Algorithm":     {"AWS4-HMAC-SHA256"},
+		"X-Amz-Credential":    {s.accessKeyID + "/" + now.Format("20060102") + "/" + s.region + "/s3/aws4_request"},
+		"X-Amz-Date":          {now.Format("20060102T150405Z")},
+		"X-Amz-Expires":       {strconv.Itoa(int(expires.Seconds()))},
+		"X-Amz-SignedHeaders": {"host"},
+	}
+	// url.Values.Encode sorts by key and escapes the way SigV4 expects.
+	canonicalRequest := strings.Join([]string{
+		http.MethodGet,
+		target.EscapedPath(),
+		query.Encode(),
+		"host:" + target.Host,
+		"",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	signature, _ := s.sign(now, canonicalRequest)
+	query.Set("X-Amz-Signature", signature)
+	target.RawQuery = query.Encode()
+	return target.String(), nil
+}
+
+// loadMediaStore sets up the store selected by ARCHIVE_BACKEND (local, s3 or
+// gcs). It returns nil if archiving is off. For compatibility, setting
+// ARCHIVE_S3_BUCKET alone selects s3.
+func loadMediaStore() (MediaStore, error) {
+	backend := os.Getenv("ARCHIVE_BACKEND")
+	if backend == "" && os.Getenv("ARCHIVE_S3_BUCKET") != "" {
+		backend = "s3"
+	}
+	publicURL := strings.TrimRight(os.Getenv("ARCHIVE_PUBLIC_URL"), "/")
+
+	switch backend {
+	case "":
+		return nil, nil
+	case "local":
+		dir := os.Getenv("ARCHIVE_DIR")
+		if dir == "" {
+			return nil, errors.New("ARCHIVE_DIR is required for the local archive")
+		}
+		return &localStore{dir: dir, publicURL: publicURL}, nil
+	case "s3":
+		store := &s3Store{
+			endpoint:        strings.TrimRight(os.Getenv("ARCHIVE_S3_ENDPOINT"), "/"),
+			bucket:          os.Getenv("ARCHIVE_S3_BUCKET"),
+			region:          os.Getenv("ARCHIVE_S3_REGION"),
+			accessKeyID:     os.Getenv("ARCHIVE_S3_ACCESS_KEY_ID"),
+			secretAccessKey: os.Getenv("ARCHIVE_S3_SECRET_ACCESS_KEY"),
+			publicURL:       publicURL,
+		}
+		if store.region == "" {
+			store.region = "us-east-1"
+		}
+		if store.endpoint == "" {
+			store.endpoint = "https://s3." + store.region + ".amazonaws.com"
+		}
+		if store.bucket == "" || store.accessKeyID == "" || store.secretAccessKey == "" {
+			return nil, errors.New("ARCHIVE_S3_BUCKET, ARCHIVE_S3_ACCESS_KEY_ID and ARCHIVE_S3_SECRET_ACCESS_KEY are required for the s3 archive")
+		}
+		if _, err := url.Parse(store.endpoint); err != nil {
+			return nil, fmt.Errorf("invalid ARCHIVE_S3_ENDPOINT: %w", err)
+		}
+		return store, nil
+	case "gcs":
+		store := &s3Store{
+			endpoint:        "https://storage.googleapis.com",
+			bucket:          os.Getenv("ARCHIVE_GCS_BUCKET"),
+			region:          "auto",
+			accessKeyID:     os.Getenv("ARCHIVE_GCS_HMAC_ACCESS_ID"),
+			secretAccessKey: os.Getenv("ARCHIVE_GCS_HMAC_SECRET"),
+			publicURL:       publicURL,
+		}
+		if store.bucket == "" || store.accessKeyID == "" || store.secretAccessKey == "" {
+			return nil, errors.New("ARCHIVE_GCS_BUCKET, ARCHIVE_GCS_HMAC_ACCESS_ID and ARCHIVE_GCS_HMAC_SECRET are required for the gcs archive")
+		}
+		return store, nil
+	}
+	return nil, errors.New("ARCHIVE_BACKEND must be local, s3 or gcs")
+}
+
+// archiveFormat is the format voice notes are archived in, from
+// ARCHIVE_FORMAT: ogg to keep the original audio or mp3 to convert it.
+func archiveFormat() (string, error) {
+	format := os.Getenv("ARCHIVE_FORMAT")
+	if format == "" {
+		return "ogg", nil
+	}
+	if _, ok := audioFormats[format]; !ok {
+		return "", errors.New("ARCHIVE_FORMAT must be ogg or mp3")
+	}
+	return format, nil
+}
+
+// archiveJob is a voice note waiting to be archived. Audio holds the
+// original Ogg/Opus file if it is at hand, as for sent notes; otherwise it
+// is downloaded.
+type archiveJob struct {
+	msg   MessageInfo
+	audio []byte
+}
+
+// archiver copies voice notes to the media store in the background.
+type archiver struct {
+	store  MediaStore
+	format string
+	queue  chan archiveJob
+	// presignTTL is how long the presigned URLs handed out for archived
+	// media are valid, or 0 to hand out the stored URLs.
+	presignTTL time.Duration
+}
+
+// newArchiver sets up archiving from the environment. It returns nil if no
+// media store is configured.
+func newArchiver() (*archiver, error) {
+	store, err := loadMediaStore()
+	if err != nil || store == nil {
+		return nil, err
+	}
+	format, err := archiveFormat()
+	if err != nil {
+		return nil, err
+	}
+	a := &archiver{store: store, format: format, queue: make(chan archiveJob, archiveQueueSize)}
+	if raw := os.Getenv("ARCHIVE_PRESIGN_TTL"); raw != "" {
+		if a.presignTTL, err = time.ParseDuration(raw); err != nil || a.presignTTL < time.Second || a.presignTTL > maxPresignTTL {
+			return nil, errors.New("ARCHIVE_PRESIGN_TTL must be a duration between 1s and 168h")
+		}
+		if _, ok := store.(PresignedStore); !ok {
+			return nil, errors.New("ARCHIVE_PRESIGN_TTL requires the s3 or gcs archive")
+		}
+	}
+	return a, nil
+}
+
+// presignedArchiveURL returns a presigned URL for the archived copy of
+// media, if presigning is on and the copy is in format; an empty format
+// matches any.
+func (api *WhatsAppAPI) presignedArchiveURL(media *MediaInfo, format string) (string, bool) {
+	if api.archive == nil || api.archive.presignTTL == 0 || media == nil || media.archiveKey == "" {
+		return "", false
+	}
+	if format != "" && !strings.HasSuffix(media.archiveKey, "."+format) {
+		return "", false
+	}
+	presigned, err := api.archive.store.(PresignedStore).PresignGet(media.archiveKey, api.archive.presignTTL)
+	if err != nil {
+		api.log.Warnf("Failed to presign %s: %v", media.archiveKey, err)
+		return "", false
+	}
+	return presigned, true
+}
+
+// enqueueArchive schedules a voice note for archiving, if archiving is on.
+// audio may be nil, in which case it is downloaded.
+func (api *WhatsAppAPI) enqueueArchive(msg MessageInfo, audio []byte) {
+	if api.archive == nil || msg.raw == nil && audio == nil {
+		return
+	}
+	if !archivableID.MatchString(msg.ID) {
+		api.log.Warnf("Not archiving voice note with invalid ID %q", msg.ID)
+		return
+	}
+	select {
+	case api.archive.queue <- archiveJob{msg: msg, audio: audio}:
+	default:
+		api.log.Warnf("Archive queue is full, not archiving voice note %s", msg.ID)
+	}
+}
+
+// runArchiver archives queued voice notes one at a time until stop is
+// closed.
+func (api *WhatsAppAPI) runArchiver(stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-api.archive.queue:
+			for attempt := 1; ; attempt++ {
+				err := api.archiveVoiceNote(ctx, job)
+				if err == nil || ctx.Err() != nil {
+					break
+				}
+				api.log.Warnf("Failed to archive voice note %s (attempt %d): %v", job.msg.ID, attempt, err)
+				if attempt == archiveAttempts {
+					break
+				}
+				select {
+				case <-ctx.Done():
+				case <-time.After(archiveBackoff << (attempt - 1)):
+				}
+			}
+		}
+	}
+}
+
+// archiveVoiceNote converts a voice note to the archive format and stores
+// it, then records its URL with the message. Audio that isn't part of the
+// job goes through the media cache, so it is only downloaded once.
+func (api *WhatsAppAPI) archiveVoiceNote(ctx context.Context, job archiveJob) error {
+	ctx, cancel := context.WithTimeout(ctx, archiveTimeout)
+	defer cancel()
+
+	msg := job.msg
+	format := api.archive.format
+	data, cached, err := api.mediaCache.get(msg.ID, format)
+	if err != nil {
+		api.log.Warnf("Failed to read cached audio of %s: %v", msg.ID, err)
+	}
+	if !cached {
+		original := job.audio
+		if original == nil {
+			var cachedOriginal bool
+			original, cachedOriginal, _ = api.mediaCache.get(msg.ID, originalAudio)
+			if !cachedOriginal {
+				if original, err = api.downloadWithRetry(ctx, msg); err != nil {
+					return fmt.Errorf("download failed: %w", err)
+				}
+				if err := api.mediaCache.put(msg.ID, originalAudio, original); err != nil {
+					api.log.Warnf("Failed to cache audio of %s: %v", msg.ID, err)
+				}
+			}
+		}
+		data = original
+		if format == "mp3" {
+			if data, err = transcodeToMP3(ctx, original); err != nil {
+				return fmt.Errorf("conversion failed: %w", err)
+			}
+			if err := api.mediaCache.put(msg.ID, format, data); err != nil {
+				api.log.Warnf("Failed to cache audio of %s: %v", msg.ID, err)
+			}
+		}
+	}
+
+	key := "voice-notes/" + msg.Timestamp.UTC().Format("2006/01/02") + "/" + msg.ID + "." + format
+	archiveURL, err := api.archive.store.Put(ctx, key, audioFormats[format], data)
+	if err != nil {
+		return fmt.Errorf("upload failed: %w", err)
+	}
+
+	api.updateMessage(msg.ID, func(msg *MessageInfo) {
+		if msg.Content.Media != nil {
+			media := *msg.Content.Media
+			media.ArchiveURL = archiveURL
+			media.archiveKey = key
+			msg.Content.Media = &media
+		}
+	})
+	api.log.Infof("Archived voice note %s to %s", msg.ID, archiveURL)
+	return nil
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// maxCachedAudio is how many converted or downloaded audio files are
+	// kept; the least recently stored ones are dropped first.
+	maxCachedAudio = 200
+	// transcodeTimeout bounds a single ffmpeg run.
+	transcodeTimeout = time.Minute
+)
+
+// audioFormats maps the supported output formats to their content types.
+var audioFormats = map[string]string{
+	"ogg": "audio/ogg",
+	"mp3": "audio/mpeg",
+}
+
+// originalAudio is the media cache key of audio as it was downloaded,
+// whatever its format.
+const originalAudio = "original"
+
+var errNoFFmpeg = errors.New("ffmpeg is not installed")
+
+// mediaCache keeps audio in the database so repeated plays don't download
+// and convert it again, and it stays playable after WhatsApp purges it.
+type mediaCache struct {
+	db *sql.DB
+}
+
+func newMediaCache(db *sql.DB) (*mediaCache, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS media_cache (
+		message_id TEXT NOT NULL,
+		format     TEXT NOT NULL,
+		data       BLOB NOT NULL,
+		created_at INTEGER NOT NULL,
+		PRIMARY KEY (message_id, format)
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	return &mediaCache{db: db}, nil
+}
+
+func (c *mediaCache) get(messageID, format string) ([]byte, bool, error) {
+	var data []byte
+	err := c.db.QueryRow(`SELECT data FROM media_cache WHERE message_id = ? AND format = ?`, messageID, format).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	return data, err == nil, err
+}
+
+func (c *mediaCache) put(messageID, format string, data []byte) error {
+	_, err := c.db.Exec(`INSERT INTO media_cache (message_id, format, data, created_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (message_id, format) DO UPDATE SET data = excluded.data, created_at = excluded.created_at`,
+		messageID, format, data, time.Now().UnixNano())
+	if err != nil {
+		return err
+	}
+	_, err = c.db.Exec(`DELETE FROM media_cache WHERE rowid NOT IN (
+		SELECT rowid FROM media_cache ORDER BY created_at DESC LIMIT ?)`, maxCachedAudio)
+	return err
+}
+
+// VoiceTranscoding configures how uploaded audio in other formats is
+// converted to the Ogg/Opus that voice notes require.
+type VoiceTranscoding struct {
+	BitrateKbps int  `json:"bitrate_kbps"`
+	Mono        bool `json:"mono"`
+	// Normalize evens out loudness to -16 LUFS (EBU R128), and TrimSilence
+	// cuts silence from the start and end. Either also applies to uploads
+	// that already are Ogg/Opus.
+	Normalize   bool `json:"normalize"`
+	TrimSilence bool `json:"trim_silence"`
+}
+
+// filters returns the ffmpeg audio filters the options call for, if any.
+func (t VoiceTranscoding) filters() string {
+	var filters []string
+	if t.TrimSilence {
+		// silenceremove only trims the start, so the audio is reversed
+		// to trim the end the same way.
+		trim := "silenceremove=start_periods=1:start_threshold=-50dB:start_silence=0.1"
+		filters = append(filters, trim, "areverse", trim, "areverse")
+	}
+	if t.Normalize {
+		filters = append(filters, "loudnorm=I=-16:TP=-1.5:LRA=11")
+	}
+	return strings.Join(filters, ",")
+}
+
+var defaultVoiceTranscoding = VoiceTranscoding{BitrateKbps: 32, Mono: true}
+
+func (t VoiceTranscoding) validate() error {
+	// The range libopus supports.
+	if t.BitrateKbps < 6 || t.BitrateKbps > 510 {
+		return errors.New("voice_transcoding.bitrate_kbps must be between 6 and 510")
+	}
+	return nil
+}
+
+// runFFmpeg converts data with ffmpeg, writing the result to stdout. The
+// input goes through a temporary file because containers like M4A can't be
+// read from a pipe.
+func runFFmpeg(ctx context.Context, data []byte, args ...string) ([]byte, error) {
+	path, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return nil, errNoFFmpeg
+	}
+
+	input, err := os.CreateTemp("", "ffmpeg-input-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(input.Name())
+	_, err = input.Write(data)
+	if closeErr := input.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, transcodeTimeout)
+	defer cancel()
+
+	cmdArgs := []string{"-hide_banner", "-loglevel", "error", "-i", input.Name()}
+	cmdArgs = append(cmdArgs, args...)
+	cmdArgs = append(cmdArgs, "pipe:1")
+
+	var out, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, path, cmdArgs...)
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, errors.New(err.Error() + ": " + stderr.String())
+	}
+	return out.Bytes(), nil
+}
+
+func transcodeToMP3(ctx context.Context, data []byte) ([]byte, error) {
+	return runFFmpeg(ctx, data, "-vn", "-codec:a", "libmp3lame", "-q:a", "4", "-f", "mp3")
+}
+
+// transcodeToOgg converts audio that isn't Ogg/Opus, like most audio
+// messages other than voice notes.
+func transcodeToOgg(ctx context.Context, data []byte) ([]byte, error) {
+	return runFFmpeg(ctx, data, "-vn", "-codec:a", "libopus", "-f", "ogg")
+}
+
+// transcodeToOpus converts audio in any format ffmpeg reads, such as MP3,
+// WAV or M4A, into an Ogg/Opus voice note.
+func transcodeToOpus(ctx context.Context, data []byte, options VoiceTranscoding) ([]byte, error) {
+	args := []string{"-vn", "-codec:a", "libopus", "-b:a", strconv.Itoa(options.BitrateKbps) + "k",
+		"-application", "voip", "-ar", "48000"}
+	if options.Mono {
+		args = append(args, "-ac", "1")
+	}
+	if filters := options.filters(); filters != "" {
+		args = append(args, "-af", filters)
+	}
+	return runFFmpeg(ctx, data, append(args, "-f", "ogg")...)
+}
+
+func (api *WhatsAppAPI) getVoiceTranscoding() VoiceTranscoding {
+	api.voiceMu.Lock()
+	defer api.voiceMu.Unlock()
+	return api.voiceTranscoding
+}
+
+func (api *WhatsAppAPI) setVoiceTranscoding(t VoiceTranscoding) {
+	api.voiceMu.Lock()
+	defer api.voiceMu.Unlock()
+	api.voiceTranscoding = t
+}
+
+// getAudio serves the audio of a voice note or audio message as Ogg/Opus, or
+// converted to MP3 for players that lack Opus support. Voice notes already
+// are Ogg/Opus, other audio is converted for either format.
+// Results are cached, so cached audio is served even while disconnected.
+func (api *WhatsAppAPI) getAudio(w http.ResponseWriter, r *http.Request) {
+	if api.client.Store.ID == nil {
+		http.Error(w, "Not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "ogg"
+	}
+	contentType, ok := audioFormats[format]
+	if !ok {
+		http.Error(w, "Format must be ogg or mp3", http.StatusBadRequest)
+		return
+	}
+
+	msg, ok := api.findMessage(mux.Vars(r)["messageId"])
+	if !ok {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+	if msg.raw == nil || msg.Content.Type != "audio" {
+		http.Error(w, "Message has no audio", http.StatusBadRequest)
+		return
+	}
+	if presigned, ok := api.presignedArchiveURL(msg.Content.Media, format); ok {
+		http.Redirect(w, r, presigned, http.StatusTemporaryRedirect)
+		return
+	}
+
+	convert := format == "mp3" || msg.Content.Media == nil || !strings.HasPrefix(msg.Content.Media.Mimetype, "audio/ogg")
+	key := format
+	if !convert {
+		key = originalAudio
+	}
+	data, cached, err := api.mediaCache.get(msg.ID, key)
+	if err != nil {
+		api.requestLog(r).Warnf("Failed to read cached audio of %s: %v", msg.ID, err)
+	}
+	if !cached {
+		if convert {
+			if _, err := exec.LookPath("ffmpeg"); err != nil {
+				http.Error(w, "Audio conversion is unavailable because ffmpeg is not installed", http.StatusUnsupportedMediaType)
+				return
+			}
+		}
+
+		original, cachedOriginal, err := api.mediaCache.get(msg.ID, originalAudio)
+		if err != nil {
+			api.requestLog(r).Warnf("Failed to read cached audio of %s: %v", msg.ID, err)
+		}
+		if !cachedOriginal {
+			if !api.requireConnected(w) {
+				return
+			}
+			if original, ok = api.downloadMedia(w, r, msg); !ok {
+				return
+			}
+			if err := api.mediaCache.put(msg.ID, originalAudio, original); err != nil {
+				api.requestLog(r).Warnf("Failed to cache audio of %s: %v", msg.ID, err)
+			}
+		}
+
+		data = original
+		if convert {
+			transcode := transcodeToMP3
+			if format == "ogg" {
+				transcode = transcodeToOgg
+			}
+			data, err = transcode(r.Context(), original)
+			if errors.Is(err, errNoFFmpeg) {
+				http.Error(w, "Audio conversion is unavailable because ffmpeg is not installed", http.StatusUnsupportedMediaType)
+				return
+			} else if err != nil {
+				api.requestLog(r).Errorf("Failed to convert audio of %s to %s: %v", msg.ID, format, err)
+				http.Error(w, "Failed to convert audio", http.StatusInternalServerError)
+				return
+			}
+			if err := api.mediaCache.put(msg.ID, format, data); err != nil {
+				api.requestLog(r).Warnf("Failed to cache audio of %s: %v", msg.ID, err)
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Write(data)
+}
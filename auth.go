@@ -0,0 +1,48 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// apiKeyMiddleware requires an "Authorization: Bearer <key>" header matching
+// one of keys. Without any keys configured every request is let through so
+// that local setups keep working.
+func apiKeyMiddleware(keys []string) mux.MiddlewareFunc {
+	// Keys are compared by hash so the comparison takes the same time
+	// regardless of key length.
+	hashes := make([][32]byte, len(keys))
+	for i, key := range keys {
+		hashes[i] = sha256.Sum256([]byte(key))
+	}
+
+	return func(next http.Handler) http.Handler {
+		if len(hashes) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || !validAPIKey(hashes, key) {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "Invalid or missing API key", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func validAPIKey(hashes [][32]byte, key string) bool {
+	hash := sha256.Sum256([]byte(key))
+	valid := false
+	for _, h := range hashes {
+		if subtle.ConstantTimeCompare(h[:], hash[:]) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
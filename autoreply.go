@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+)
+
+const autoReplyVoiceKey = "auto_reply_voice"
+
+const (
+	AutoReplyMessages    = "messages"
+	AutoReplyMissedCalls = "missed_calls"
+)
+
+// autoReplyTimeout bounds uploading and sending one auto-reply.
+const autoReplyTimeout = time.Minute
+
+// AutoReply answers private chats with the recorded voice note, e.g. after
+// hours. Trigger is either every incoming message or only missed calls. A
+// chat gets at most one auto-reply per Cooldown, so a conversation isn't
+// answered message by message.
+type AutoReply struct {
+	Enabled  bool   `json:"enabled"`
+	Trigger  string `json:"trigger"`
+	Delay    string `json:"delay"`
+	Cooldown string `json:"cooldown"`
+}
+
+var defaultAutoReply = AutoReply{Trigger: AutoReplyMissedCalls, Delay: "30s", Cooldown: "12h"}
+
+func (a AutoReply) durations() (delay, cooldown time.Duration, err error) {
+	delay, err = time.ParseDuration(a.Delay)
+	if err != nil || delay < 0 {
+		return 0, 0, errors.New("auto_reply.delay must be a non-negative duration")
+	}
+	cooldown, err = time.ParseDuration(a.Cooldown)
+	if err != nil || cooldown < 0 {
+		return 0, 0, errors.New("auto_reply.cooldown must be a non-negative duration")
+	}
+	return delay, cooldown, nil
+}
+
+func (a AutoReply) validate() error {
+	if a.Trigger != AutoReplyMessages && a.Trigger != AutoReplyMissedCalls {
+		return errors.New("auto_reply.trigger must be messages or missed_calls")
+	}
+	_, _, err := a.durations()
+	return err
+}
+
+type AutoReplyVoiceResponse struct {
+	Seconds uint32 `json:"seconds"`
+}
+
+func (api *WhatsAppAPI) getAutoReply() AutoReply {
+	api.autoReplyMu.Lock()
+	defer api.autoReplyMu.Unlock()
+	return api.autoReply
+}
+
+func (api *WhatsAppAPI) setAutoReply(a AutoReply) {
+	api.autoReplyMu.Lock()
+	api.autoReply = a
+	api.autoReplyMu.Unlock()
+}
+
+// scheduleAutoReply sends the auto-reply to chat after the configured delay
+// if trigger is the configured one and the chat's cooldown has passed.
+func (api *WhatsAppAPI) scheduleAutoReply(chat types.JID, trigger string) {
+	if chat.Server != types.DefaultUserServer {
+		// Groups, broadcasts and status updates aren't answered.
+		return
+	}
+	chat = chat.ToNonAD()
+
+	api.autoReplyMu.Lock()
+	defer api.autoReplyMu.Unlock()
+	cfg := api.autoReply
+	if !cfg.Enabled || cfg.Trigger != trigger {
+		return
+	}
+	delay, cooldown, _ := cfg.durations()
+	if last, ok := api.autoReplied[chat.String()]; ok && time.Since(last) < cooldown {
+		return
+	}
+	// The cooldown starts now, so that messages arriving during the delay
+	// don't schedule further replies.
+	api.autoReplied[chat.String()] = time.Now()
+	time.AfterFunc(delay, func() {
+		api.sendAutoReply(chat)
+	})
+}
+
+func (api *WhatsAppAPI) sendAutoReply(chat types.JID) {
+	api.autoReplyMu.Lock()
+	note := api.autoReplyVoice
+	api.autoReplyMu.Unlock()
+	if note == nil {
+		api.log.Warnf("Auto-reply to %s skipped, no voice note is recorded", chat)
+		return
+	}
+	if api.checkConnected() != nil {
+		api.log.Warnf("Auto-reply to %s skipped while disconnected", chat)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), autoReplyTimeout)
+	defer cancel()
+	if err := api.sendLimit.wait(ctx); err != nil {
+		return
+	}
+	uploaded, err := api.client.Upload(ctx, note.Audio, whatsmeow.MediaAudio)
+	if err != nil {
+		api.log.Errorf("Failed to upload auto-reply to %s: %v", chat, err)
+		return
+	}
+	msg := note.message(uploaded, nil)
+	resp, err := api.sendOutbound(ctx, chat, msg)
+	if err != nil {
+		api.log.Errorf("Failed to send auto-reply to %s: %v", chat, err)
+		return
+	}
+
+	sent := MessageInfo{
+		ID:        resp.ID,
+		Timestamp: resp.Timestamp,
+		Source: MessageSource{
+			Chat:     chat.String(),
+			Sender:   api.client.Store.ID.ToNonAD().String(),
+			IsFromMe: true,
+		},
+		Content: extractMessageContent(msg),
+		IsRead:  true,
+		Status:  MessageStatusSent,
+		raw:     msg,
+	}
+	api.appendMessage(sent)
+	api.enqueueArchive(sent, note.Audio)
+	api.log.Infof("Sent auto-reply %s to %s", resp.ID, chat)
+}
+
+// setAutoReplyVoice records the voice note sent as auto-reply. It takes the
+// same audio as POST /messages/send-voice, including chunked uploads.
+func (api *WhatsAppAPI) setAutoReplyVoice(w http.ResponseWriter, r *http.Request) {
+	req, err := readVoiceRequest(r)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.UploadID != "" {
+		req.Audio, err = api.uploads.read(req.UploadID)
+		if errors.Is(err, errUploadNotFound) {
+			http.Error(w, "Upload not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, "Upload can't be used: "+err.Error(), http.StatusConflict)
+			return
+		}
+	}
+	note, err := api.prepareVoice(r.Context(), api.requestLog(r), req.Audio, req.Seconds)
+	if err != nil {
+		writeError(w, err, "Failed to process audio")
+		return
+	}
+
+	if err := api.settings.save(autoReplyVoiceKey, note); err != nil {
+		api.requestLog(r).Errorf("Failed to save auto-reply voice note: %v", err)
+		http.Error(w, "Failed to save voice note", http.StatusInternalServerError)
+		return
+	}
+	api.autoReplyMu.Lock()
+	api.autoReplyVoice = &note
+	api.autoReplyMu.Unlock()
+	if req.UploadID != "" {
+		api.uploads.remove(req.UploadID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AutoReplyVoiceResponse{Seconds: note.Seconds})
+}
+
+func (api *WhatsAppAPI) getAutoReplyVoice(w http.ResponseWriter, r *http.Request) {
+	api.autoReplyMu.Lock()
+	note := api.autoReplyVoice
+	api.autoReplyMu.Unlock()
+	if note == nil {
+		http.Error(w, "No auto-reply voice note is recorded", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", voiceMimeType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(note.Audio)))
+	w.Write(note.Audio)
+}
+
+func (api *WhatsAppAPI) deleteAutoReplyVoice(w http.ResponseWriter, r *http.Request) {
+	if err := api.settings.delete(autoReplyVoiceKey); err != nil {
+		api.requestLog(r).Errorf("Failed to delete auto-reply voice note: %v", err)
+		http.Error(w, "Failed to delete voice note", http.StatusInternalServerError)
+		return
+	}
+	api.autoReplyMu.Lock()
+	api.autoReplyVoice = nil
+	api.autoReplyMu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	waLog "go.mau.fi/whatsmeow/util/log"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// maxBroadcastRecipients bounds how long a single broadcast can take
+	// with the configured pacing.
+	maxBroadcastRecipients = 1000
+	// maxFinishedBroadcasts is how many completed broadcasts are kept for
+	// their results; the oldest are dropped first.
+	maxFinishedBroadcasts = 50
+)
+
+const (
+	BroadcastRunning   = "running"
+	BroadcastCompleted = "completed"
+	BroadcastCancelled = "cancelled"
+
+	BroadcastPending = "pending"
+	BroadcastSent    = "sent"
+	BroadcastFailed  = "failed"
+)
+
+// BroadcastPacing spaces out the sends of a broadcast. Each send waits
+// Interval plus a random part of Jitter after the previous one, so bulk
+// sends don't go out at the machine-like rate that gets accounts banned.
+// The send rate limit applies on top of it.
+type BroadcastPacing struct {
+	Interval string `json:"interval"`
+	Jitter   string `json:"jitter"`
+}
+
+var defaultBroadcastPacing = BroadcastPacing{Interval: "3s", Jitter: "2s"}
+
+func (p BroadcastPacing) durations() (interval, jitter time.Duration, err error) {
+	interval, err = time.ParseDuration(p.Interval)
+	if err != nil || interval < 0 {
+		return 0, 0, errors.New("broadcast_pacing.interval must be a non-negative duration")
+	}
+	jitter, err = time.ParseDuration(p.Jitter)
+	if err != nil || jitter < 0 {
+		return 0, 0, errors.New("broadcast_pacing.jitter must be a non-negative duration")
+	}
+	return interval, jitter, nil
+}
+
+func (p BroadcastPacing) validate() error {
+	_, _, err := p.durations()
+	return err
+}
+
+// delay returns how long to wait before the next send.
+func (p BroadcastPacing) delay() time.Duration {
+	interval, jitter, _ := p.durations()
+	if jitter > 0 {
+		interval += time.Duration(rand.Int63n(int64(jitter)))
+	}
+	return interval
+}
+
+func (api *WhatsAppAPI) getBroadcastPacing() BroadcastPacing {
+	api.broadcastMu.Lock()
+	defer api.broadcastMu.Unlock()
+	return api.broadcastPacing
+}
+
+func (api *WhatsAppAPI) setBroadcastPacing(p BroadcastPacing) {
+	api.broadcastMu.Lock()
+	api.broadcastPacing = p
+	api.broadcastMu.Unlock()
+}
+
+type BroadcastRequest struct {
+	ChatIDs []string `json:"chat_ids"`
+	Text    string   `json:"text"`
+}
+
+// BroadcastResult is the outcome for one recipient. MessageID is set once
+// it has been sent, Error if that failed.
+type BroadcastResult struct {
+	ChatID    string     `json:"chat_id"`
+	Status    string     `json:"status"`
+	MessageID string     `json:"message_id,omitempty"`
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+type Broadcast struct {
+	ID         string            `json:"id"`
+	Status     string            `json:"status"`
+	Text       string            `json:"text"`
+	CreatedAt  time.Time         `json:"created_at"`
+	FinishedAt *time.Time        `json:"finished_at,omitempty"`
+	Results    []BroadcastResult `json:"results"`
+
+	cancel context.CancelFunc
+}
+
+// broadcasts tracks queued broadcasts. They are only kept in memory, so a
+// restart drops the ones still running.
+type broadcasts struct {
+	mu    sync.Mutex
+	byID  map[string]*Broadcast
+	order []string
+}
+
+func newBroadcasts() *broadcasts {
+	return &broadcasts{byID: make(map[string]*Broadcast)}
+}
+
+func (b *broadcasts) add(job *Broadcast) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.byID[job.ID] = job
+	b.order = append(b.order, job.ID)
+
+	finished := 0
+	for i := len(b.order) - 1; i >= 0; i-- {
+		id := b.order[i]
+		if b.byID[id].Status == BroadcastRunning {
+			continue
+		}
+		if finished++; finished > maxFinishedBroadcasts {
+			delete(b.byID, id)
+			b.order = append(b.order[:i], b.order[i+1:]...)
+		}
+	}
+}
+
+// snapshot returns a copy of a broadcast that is safe to encode while it
+// keeps running.
+func (b *broadcasts) snapshot(id string) (Broadcast, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	job, ok := b.byID[id]
+	if !ok {
+		return Broadcast{}, false
+	}
+	copied := *job
+	copied.Results = append([]BroadcastResult(nil), job.Results...)
+	return copied, true
+}
+
+// broadcastMessage queues the same text for several chats and responds with
+// the broadcast's ID right away. Recipients are sent to one at a time with
+// the configured pacing; a failed recipient doesn't stop the others.
+func (api *WhatsAppAPI) broadcastMessage(w http.ResponseWriter, r *http.Request) {
+	if !api.requireConnected(w) {
+		return
+	}
+
+	var req BroadcastRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Text == "" {
+		http.Error(w, "Text is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.ChatIDs) == 0 || len(req.ChatIDs) > maxBroadcastRecipients {
+		http.Error(w, "chat_ids must list between 1 and "+strconv.Itoa(maxBroadcastRecipients)+" chats", http.StatusBadRequest)
+		return
+	}
+
+	// Each chat gets the message once, however often it is listed.
+	seen := make(map[string]bool, len(req.ChatIDs))
+	results := make([]BroadcastResult, 0, len(req.ChatIDs))
+	for _, chatID := range req.ChatIDs {
+		if seen[chatID] {
+			continue
+		}
+		seen[chatID] = true
+		result := BroadcastResult{ChatID: chatID, Status: BroadcastPending}
+		if _, err := types.ParseJID(chatID); err != nil {
+			result.Status, result.Error = BroadcastFailed, "Invalid chat JID"
+		}
+		results = append(results, result)
+	}
+
+	// The broadcast outlives the request, so it doesn't use its context.
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &Broadcast{
+		ID:        api.client.GenerateMessageID(),
+		Status:    BroadcastRunning,
+		Text:      req.Text,
+		CreatedAt: time.Now(),
+		Results:   results,
+		cancel:    cancel,
+	}
+	api.broadcasts.add(job)
+	go api.runBroadcast(ctx, job, api.requestLog(r))
+
+	response, _ := api.broadcasts.snapshot(job.ID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(response)
+}
+
+func (api *WhatsAppAPI) runBroadcast(ctx context.Context, job *Broadcast, log waLog.Logger) {
+	msg := &waE2E.Message{Conversation: proto.String(job.Text)}
+	setResult := func(i int, update func(*BroadcastResult)) {
+		api.broadcasts.mu.Lock()
+		update(&job.Results[i])
+		api.broadcasts.mu.Unlock()
+	}
+
+	first := true
+	for i := range job.Results {
+		// Only this goroutine changes results once the broadcast runs.
+		if job.Results[i].Status != BroadcastPending {
+			continue
+		}
+		if !first {
+			select {
+			case <-ctx.Done():
+			case <-time.After(api.getBroadcastPacing().delay()):
+			}
+		}
+		first = false
+		if ctx.Err() != nil {
+			break
+		}
+
+		chatJID, _ := types.ParseJID(job.Results[i].ChatID)
+		if err := api.sendLimit.wait(ctx); err != nil {
+			break
+		}
+		resp, err := api.sendOutbound(ctx, chatJID, msg)
+		if err != nil {
+			log.Errorf("Failed to broadcast message to %s: %v", chatJID, err)
+			setResult(i, func(res *BroadcastResult) {
+				res.Status, res.Error = BroadcastFailed, err.Error()
+			})
+			continue
+		}
+		setResult(i, func(res *BroadcastResult) {
+			res.Status, res.MessageID, res.Timestamp = BroadcastSent, resp.ID, &resp.Timestamp
+		})
+
+		api.appendMessage(MessageInfo{
+			ID:        resp.ID,
+			Timestamp: resp.Timestamp,
+			Source: MessageSource{
+				Chat:     chatJID.String(),
+				Sender:   api.client.Store.ID.ToNonAD().String(),
+				IsFromMe: true,
+				IsGroup:  chatJID.Server == types.GroupServer,
+			},
+			Content: MessageContent{Text: job.Text, Type: "text"},
+			IsRead:  true,
+			Status:  MessageStatusSent,
+		})
+	}
+
+	api.broadcasts.mu.Lock()
+	now := time.Now()
+	job.FinishedAt = &now
+	job.Status = BroadcastCompleted
+	for _, res := range job.Results {
+		if res.Status == BroadcastPending {
+			job.Status = BroadcastCancelled
+			break
+		}
+	}
+	job.cancel()
+	api.broadcasts.mu.Unlock()
+}
+
+func (api *WhatsAppAPI) getBroadcast(w http.ResponseWriter, r *http.Request) {
+	job, ok := api.broadcasts.snapshot(mux.Vars(r)["broadcastId"])
+	if !ok {
+		http.Error(w, "Broadcast not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// cancelBroadcast stops a running broadcast. Recipients that haven't been
+// sent to yet stay pending.
+func (api *WhatsAppAPI) cancelBroadcast(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["broadcastId"]
+	api.broadcasts.mu.Lock()
+	job, ok := api.broadcasts.byID[id]
+	running := ok && job.Status == BroadcastRunning
+	if running {
+		job.cancel()
+	}
+	api.broadcasts.mu.Unlock()
+
+	if !ok {
+		http.Error(w, "Broadcast not found", http.StatusNotFound)
+		return
+	} else if !running {
+		http.Error(w, "Broadcast has already finished", http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
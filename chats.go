@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// ChatState holds the per-chat flags that WhatsApp syncs through app state.
+type ChatState struct {
+	Archived bool
+	Pinned   bool
+}
+
+type ChatInfo struct {
+	ChatID      string      `json:"chat_id"`
+	Name        string      `json:"name,omitempty"`
+	IsGroup     bool        `json:"is_group"`
+	LastMessage MessageInfo `json:"last_message"`
+	UnreadCount int         `json:"unread_count"`
+	Archived    bool        `json:"archived"`
+	Pinned      bool        `json:"pinned"`
+}
+
+type ChatsResponse struct {
+	Chats []ChatInfo `json:"chats"`
+}
+
+type MarkChatReadResponse struct {
+	Marked int `json:"marked"`
+}
+
+func (api *WhatsAppAPI) chatState(chat string) ChatState {
+	api.chatStatesMu.Lock()
+	defer api.chatStatesMu.Unlock()
+	return api.chatStates[chat]
+}
+
+func (api *WhatsAppAPI) updateChatState(chat string, update func(*ChatState)) {
+	api.chatStatesMu.Lock()
+	defer api.chatStatesMu.Unlock()
+	state := api.chatStates[chat]
+	update(&state)
+	api.chatStates[chat] = state
+}
+
+func (api *WhatsAppAPI) handleArchive(evt *events.Archive) {
+	api.updateChatState(evt.JID.ToNonAD().String(), func(state *ChatState) {
+		state.Archived = evt.Action.GetArchived()
+	})
+}
+
+func (api *WhatsAppAPI) handlePin(evt *events.Pin) {
+	api.updateChatState(evt.JID.ToNonAD().String(), func(state *ChatState) {
+		state.Pinned = evt.Action.GetPinned()
+	})
+}
+
+// parseBoolFilter reads an optional true/false query parameter.
+func parseBoolFilter(r *http.Request, name string) (*bool, bool) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return nil, true
+	}
+	value, err := strconv.ParseBool(raw)
+	if err != nil {
+		return nil, false
+	}
+	return &value, true
+}
+
+// getChats lists every chat with stored messages, most recently active
+// first. Like unread counts elsewhere, the list is derived from the stored
+// messages so it stays consistent when messages are read or deleted.
+func (api *WhatsAppAPI) getChats(w http.ResponseWriter, r *http.Request) {
+	if api.client.Store.ID == nil {
+		http.Error(w, "Not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	archived, ok := parseBoolFilter(r, "archived")
+	if !ok {
+		http.Error(w, "Invalid archived filter, expected true or false", http.StatusBadRequest)
+		return
+	}
+	pinned, ok := parseBoolFilter(r, "pinned")
+	if !ok {
+		http.Error(w, "Invalid pinned filter, expected true or false", http.StatusBadRequest)
+		return
+	}
+
+	chats := make(map[string]*ChatInfo)
+	for _, msg := range api.snapshotMessages() {
+		chat, ok := chats[msg.Source.Chat]
+		if !ok {
+			state := api.chatState(msg.Source.Chat)
+			chat = &ChatInfo{
+				ChatID:      msg.Source.Chat,
+				IsGroup:     msg.Source.IsGroup,
+				LastMessage: msg,
+				Archived:    state.Archived,
+				Pinned:      state.Pinned,
+			}
+			chats[msg.Source.Chat] = chat
+		} else if !msg.Timestamp.Before(chat.LastMessage.Timestamp) {
+			chat.LastMessage = msg
+		}
+		if !msg.IsRead && !msg.Source.IsFromMe {
+			chat.UnreadCount++
+		}
+	}
+
+	response := ChatsResponse{Chats: make([]ChatInfo, 0, len(chats))}
+	for _, chat := range chats {
+		if archived != nil && chat.Archived != *archived {
+			continue
+		}
+		if pinned != nil && chat.Pinned != *pinned {
+			continue
+		}
+		chat.Name = api.chatName(chat.ChatID)
+		chat.LastMessage.Source.ChatName = chat.Name
+		response.Chats = append(response.Chats, *chat)
+	}
+	sort.Slice(response.Chats, func(i, j int) bool {
+		return response.Chats[i].LastMessage.Timestamp.After(response.Chats[j].LastMessage.Timestamp)
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// markChatRead marks every unread incoming message in a chat as read. Read
+// receipts go out in one batch per sender, and only messages whose receipt
+// was sent are marked locally, as with updateReadStatus.
+func (api *WhatsAppAPI) markChatRead(w http.ResponseWriter, r *http.Request) {
+	if !api.requireConnected(w) {
+		return
+	}
+
+	vars := mux.Vars(r)
+	chatJID, err := types.ParseJID(vars["chatId"])
+	if err != nil {
+		http.Error(w, "Invalid chat JID", http.StatusBadRequest)
+		return
+	}
+	chat := chatJID.String()
+
+	bySender := make(map[string][]string)
+	for _, msg := range api.snapshotMessages() {
+		if msg.Source.Chat == chat && !msg.IsRead && !msg.Source.IsFromMe {
+			bySender[msg.Source.Sender] = append(bySender[msg.Source.Sender], msg.ID)
+		}
+	}
+
+	read := make(map[string]bool)
+	failed := false
+	now := time.Now()
+	for sender, ids := range bySender {
+		senderJID, err := types.ParseJID(sender)
+		if err != nil {
+			api.requestLog(r).Warnf("Skipping read receipts for invalid sender %s", sender)
+			continue
+		}
+		if err := api.client.MarkRead(ids, now, chatJID, senderJID); err != nil {
+			api.requestLog(r).Errorf("Failed to send read receipts to %s in %s: %v", sender, chat, err)
+			failed = true
+			continue
+		}
+		for _, id := range ids {
+			read[id] = true
+		}
+	}
+
+	marked := api.updateMessages(func(msg *MessageInfo) bool {
+		if !read[msg.ID] || msg.IsRead {
+			return false
+		}
+		msg.IsRead = true
+		return true
+	})
+
+	if failed {
+		http.Error(w, "Failed to mark all messages as read", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(MarkChatReadResponse{Marked: marked})
+}
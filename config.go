@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const configKey = "config"
+
+// Config is the effective runtime configuration. It is stored as a single
+// JSON document so that related settings can be updated together.
+type Config struct {
+	RejectCalls bool `json:"reject_calls"`
+	// ConnectionDebounce is a Go duration string; see setConnectionStatus.
+	ConnectionDebounce string `json:"connection_debounce"`
+	// QRTimeout is how long QR requests wait for a code before giving up.
+	QRTimeout       string          `json:"qr_timeout"`
+	ReconnectPolicy ReconnectPolicy `json:"reconnect_policy"`
+	// SendRate is in messages per second, with up to SendBurst sent at once.
+	SendRate  float64 `json:"send_rate"`
+	SendBurst int     `json:"send_burst"`
+	// VoiceTranscoding applies to voice notes uploaded in formats other
+	// than Ogg/Opus.
+	VoiceTranscoding VoiceTranscoding `json:"voice_transcoding"`
+	BroadcastPacing  BroadcastPacing  `json:"broadcast_pacing"`
+	Transcription    Transcription    `json:"transcription"`
+	AutoReply        AutoReply        `json:"auto_reply"`
+	MediaLimits      MediaLimits      `json:"media_limits"`
+	// StripImageMetadata re-encodes sent images without EXIF and other
+	// metadata, unless a request says otherwise.
+	StripImageMetadata bool `json:"strip_image_metadata"`
+}
+
+// configMu serializes configuration updates so that a read-modify-write
+// through PATCH can't interleave with another update.
+var configMu sync.Mutex
+
+func (c Config) validate() error {
+	debounce, err := time.ParseDuration(c.ConnectionDebounce)
+	if err != nil || debounce < 0 {
+		return errors.New("connection_debounce must be a non-negative duration")
+	}
+	qrTimeout, err := time.ParseDuration(c.QRTimeout)
+	if err != nil || qrTimeout < 0 {
+		return errors.New("qr_timeout must be a non-negative duration")
+	}
+	if err := validateSendLimit(c.SendRate, c.SendBurst); err != nil {
+		return err
+	}
+	if err := c.VoiceTranscoding.validate(); err != nil {
+		return err
+	}
+	if err := c.BroadcastPacing.validate(); err != nil {
+		return err
+	}
+	if err := c.Transcription.validate(); err != nil {
+		return err
+	}
+	if err := c.AutoReply.validate(); err != nil {
+		return err
+	}
+	if err := c.MediaLimits.validate(); err != nil {
+		return err
+	}
+	return c.ReconnectPolicy.validate()
+}
+
+func (api *WhatsAppAPI) currentConfig() Config {
+	api.statusMu.Lock()
+	debounce := api.statusDebounce
+	api.statusMu.Unlock()
+
+	api.qrMu.Lock()
+	qrTimeout := api.qrTimeout
+	api.qrMu.Unlock()
+
+	api.reconnect.mu.Lock()
+	policy := api.reconnect.policy
+	api.reconnect.mu.Unlock()
+
+	sendRate, sendBurst := api.sendLimit.limit()
+
+	return Config{
+		RejectCalls:        api.rejectCalls.Load(),
+		ConnectionDebounce: debounce.String(),
+		QRTimeout:          qrTimeout.String(),
+		ReconnectPolicy:    policy,
+		SendRate:           sendRate,
+		SendBurst:          sendBurst,
+		VoiceTranscoding:   api.getVoiceTranscoding(),
+		BroadcastPacing:    api.getBroadcastPacing(),
+		Transcription:      api.getTranscription(),
+		AutoReply:          api.getAutoReply(),
+		MediaLimits:        api.getMediaLimits(),
+		StripImageMetadata: api.stripImageMetadata(nil),
+	}
+}
+
+// applyConfig validates and persists cfg before making it live. Callers must
+// hold configMu.
+func (api *WhatsAppAPI) applyConfig(cfg Config) error {
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+	if err := api.settings.save(configKey, cfg); err != nil {
+		api.log.Errorf("Failed to save config: %v", err)
+		return errConfigNotSaved
+	}
+
+	api.rejectCalls.Store(cfg.RejectCalls)
+
+	debounce, _ := time.ParseDuration(cfg.ConnectionDebounce)
+	api.statusMu.Lock()
+	api.statusDebounce = debounce
+	api.statusMu.Unlock()
+
+	qrTimeout, _ := time.ParseDuration(cfg.QRTimeout)
+	api.qrMu.Lock()
+	api.qrTimeout = qrTimeout
+	api.qrMu.Unlock()
+
+	api.setReconnectPolicy(cfg.ReconnectPolicy)
+	api.sendLimit.setLimit(cfg.SendRate, cfg.SendBurst)
+	api.setVoiceTranscoding(cfg.VoiceTranscoding)
+	api.setBroadcastPacing(cfg.BroadcastPacing)
+	api.setTranscription(cfg.Transcription)
+	api.setAutoReply(cfg.AutoReply)
+	api.setMediaLimits(cfg.MediaLimits)
+	api.setStripImageMetadata(cfg.StripImageMetadata)
+	return nil
+}
+
+var errConfigNotSaved = errors.New("failed to save config")
+
+// updateConfig applies update to the current config and reports failures
+// over HTTP. It returns false if a response has already been written.
+func (api *WhatsAppAPI) updateConfig(w http.ResponseWriter, update func(*Config) error) bool {
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	cfg := api.currentConfig()
+	if err := update(&cfg); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return false
+	}
+
+	err := api.applyConfig(cfg)
+	if errors.Is(err, errConfigNotSaved) {
+		http.Error(w, "Failed to save config", http.StatusInternalServerError)
+		return false
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func (api *WhatsAppAPI) getConfig(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(api.currentConfig())
+}
+
+// patchConfig updates any subset of the configuration at once. Fields that
+// are left out of the body keep their current values, and nothing is applied
+// unless the resulting config is valid as a whole.
+func (api *WhatsAppAPI) patchConfig(w http.ResponseWriter, r *http.Request) {
+	ok := api.updateConfig(w, func(cfg *Config) error {
+		return json.NewDecoder(r.Body).Decode(cfg)
+	})
+	if ok {
+		api.getConfig(w, r)
+	}
+}
+
+// ServerConfig holds the settings that only take effect at startup. They are
+// read from the environment rather than stored with Config.
+type ServerConfig struct {
+	ListenAddr     string
+	ReadTimeout    time.Duration
+	WriteTimeout   time.Duration
+	DBMaxOpenConns int
+	DBMaxIdleConns int
+	DBConnLifetime time.Duration
+	// MaxBodySize caps request bodies in bytes, MaxUploadSize those of the
+	// media upload endpoints.
+	MaxBodySize   int64
+	MaxUploadSize int64
+}
+
+func loadServerConfig() (ServerConfig, error) {
+	cfg := ServerConfig{
+		ListenAddr:     ":8080",
+		ReadTimeout:    30 * time.Second,
+		WriteTimeout:   60 * time.Second,
+		DBMaxOpenConns: 10,
+		DBMaxIdleConns: 5,
+		DBConnLifetime: time.Hour,
+		MaxBodySize:    defaultMaxBodySize,
+		MaxUploadSize:  defaultMaxUploadSize,
+	}
+	if addr := os.Getenv("LISTEN_ADDR"); addr != "" {
+		cfg.ListenAddr = addr
+	}
+	for name, value := range map[string]*time.Duration{
+		"READ_TIMEOUT":         &cfg.ReadTimeout,
+		"WRITE_TIMEOUT":        &cfg.WriteTimeout,
+		"DB_CONN_MAX_LIFETIME": &cfg.DBConnLifetime,
+	} {
+		if raw := os.Getenv(name); raw != "" {
+			d, err := time.ParseDuration(raw)
+			if err != nil || d < 0 {
+				return cfg, fmt.Errorf("%s must be a non-negative duration", name)
+			}
+			*value = d
+		}
+	}
+	for name, value := range map[string]*int{
+		"DB_MAX_OPEN_CONNS": &cfg.DBMaxOpenConns,
+		"DB_MAX_IDLE_CONNS": &cfg.DBMaxIdleConns,
+	} {
+		if raw := os.Getenv(name); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 0 {
+				return cfg, fmt.Errorf("%s must be a non-negative integer", name)
+			}
+			*value = n
+		}
+	}
+	for name, value := range map[string]*int64{
+		"MAX_BODY_SIZE":   &cfg.MaxBodySize,
+		"MAX_UPLOAD_SIZE": &cfg.MaxUploadSize,
+	} {
+		if raw := os.Getenv(name); raw != "" {
+			n, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil || n < 1 {
+				return cfg, fmt.Errorf("%s must be a positive number of bytes", name)
+			}
+			*value = n
+		}
+	}
+	return cfg, nil
+}
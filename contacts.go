@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxNumberChecks caps the numbers looked up in one request.
+const maxNumberChecks = 50
+
+// ContactInfo is a contact keyed by its JID, the same form used for message
+// senders.
+type ContactInfo struct {
+	JID string `json:"jid"`
+	ContactName
+	IsBusiness bool `json:"is_business"`
+}
+
+type ContactsResponse struct {
+	Contacts []ContactInfo `json:"contacts"`
+}
+
+// NumberCheck is the result for one number passed to checkNumbers. Number
+// is the input as given, JID is set for numbers that are on WhatsApp.
+type NumberCheck struct {
+	Number       string `json:"number"`
+	IsRegistered bool   `json:"is_registered"`
+	JID          string `json:"jid,omitempty"`
+	VerifiedName string `json:"verified_name,omitempty"`
+}
+
+type NumberChecksResponse struct {
+	Results []NumberCheck `json:"results"`
+}
+
+func (api *WhatsAppAPI) getContacts(w http.ResponseWriter, r *http.Request) {
+	if api.client.Store.ID == nil {
+		http.Error(w, "Not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	api.contactsMu.Lock()
+	response := ContactsResponse{Contacts: make([]ContactInfo, 0, len(api.contacts))}
+	for jid, name := range api.contacts {
+		response.Contacts = append(response.Contacts, ContactInfo{
+			JID:         jid,
+			ContactName: name,
+			IsBusiness:  name.BusinessName != "",
+		})
+	}
+	api.contactsMu.Unlock()
+	sort.Slice(response.Contacts, func(i, j int) bool {
+		return response.Contacts[i].JID < response.Contacts[j].JID
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// syncContacts reloads contacts from the device store. They are kept up to
+// date by contact and push name events, so this is only needed to pick up
+// changes made while events were missed.
+func (api *WhatsAppAPI) syncContacts(w http.ResponseWriter, r *http.Request) {
+	if api.client.Store.ID == nil {
+		http.Error(w, "Not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	if err := api.loadContacts(); err != nil {
+		http.Error(w, "Failed to sync contacts", http.StatusInternalServerError)
+		return
+	}
+	api.getContacts(w, r)
+}
+
+// checkNumbers looks up whether the comma separated numbers in msisdn are
+// registered on WhatsApp. Results are in the order the numbers were given.
+func (api *WhatsAppAPI) checkNumbers(w http.ResponseWriter, r *http.Request) {
+	numbers := splitList(r.URL.Query().Get("msisdn"))
+	if len(numbers) == 0 || len(numbers) > maxNumberChecks {
+		http.Error(w, "msisdn must list between 1 and "+strconv.Itoa(maxNumberChecks)+" numbers", http.StatusBadRequest)
+		return
+	}
+
+	normalized := make([]string, len(numbers))
+	queries := make([]string, 0, len(numbers))
+	for i, number := range numbers {
+		phone, err := normalizePhoneNumber(number)
+		if err != nil {
+			http.Error(w, number+": "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		normalized[i] = phone
+		queries = append(queries, "+"+phone)
+	}
+
+	if !api.requireConnected(w) {
+		return
+	}
+	found, err := api.client.IsOnWhatsApp(queries)
+	if err != nil {
+		api.requestLog(r).Errorf("Failed to check numbers: %v", err)
+		http.Error(w, "Failed to check numbers", http.StatusBadGateway)
+		return
+	}
+	byNumber := make(map[string]NumberCheck, len(found))
+	for _, result := range found {
+		check := NumberCheck{IsRegistered: result.IsIn}
+		if result.IsIn {
+			check.JID = result.JID.String()
+		}
+		if result.VerifiedName != nil {
+			check.VerifiedName = result.VerifiedName.Details.GetVerifiedName()
+		}
+		byNumber[strings.TrimPrefix(result.Query, "+")] = check
+	}
+
+	response := NumberChecksResponse{Results: make([]NumberCheck, len(numbers))}
+	for i, number := range numbers {
+		check := byNumber[normalized[i]]
+		check.Number = number
+		response.Results[i] = check
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mau.fi/whatsmeow/proto/waWeb"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// Delivery states of messages sent by this account. Sends that are still
+// pending or have failed are only known to the outbox, whose states are
+// reported as they are, since messages are stored once WhatsApp accepts them.
+const (
+	MessageStatusSent      = "sent"
+	MessageStatusDelivered = "delivered"
+	MessageStatusRead      = "read"
+	// MessageStatusPlayed is only reached by voice notes and other media
+	// the recipient plays.
+	MessageStatusPlayed = "played"
+)
+
+// messageStatusRank orders the states that receipts move a message through,
+// so that a late delivery receipt can't undo a read one.
+var messageStatusRank = map[string]int{
+	MessageStatusSent:      1,
+	MessageStatusDelivered: 2,
+	MessageStatusRead:      3,
+	MessageStatusPlayed:    4,
+}
+
+// StatusChange is a state a message we sent reached, with the time of the
+// receipt that moved it there.
+type StatusChange struct {
+	Status    string    `json:"status"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+type MessageStatusResponse struct {
+	ID      string         `json:"id"`
+	Status  string         `json:"status"`
+	Error   string         `json:"error,omitempty"`
+	History []StatusChange `json:"history,omitempty"`
+}
+
+// receiptStatus maps a receipt for one of our messages to the state it
+// implies, or "" if it doesn't affect the state.
+func receiptStatus(receiptType types.ReceiptType) string {
+	switch receiptType {
+	case types.ReceiptTypeDelivered:
+		return MessageStatusDelivered
+	case types.ReceiptTypeRead:
+		return MessageStatusRead
+	case types.ReceiptTypePlayed:
+		return MessageStatusPlayed
+	}
+	return ""
+}
+
+// historyStatus maps the state recorded in a history sync.
+func historyStatus(status waWeb.WebMessageInfo_Status) string {
+	switch status {
+	case waWeb.WebMessageInfo_DELIVERY_ACK:
+		return MessageStatusDelivered
+	case waWeb.WebMessageInfo_READ:
+		return MessageStatusRead
+	case waWeb.WebMessageInfo_PLAYED:
+		return MessageStatusPlayed
+	}
+	return MessageStatusSent
+}
+
+// advanceStatus moves the messages in ids that we sent forward to status,
+// recording the transition. A receipt skipping a state, such as a read
+// receipt arriving first, records only the state it implies.
+func (api *WhatsAppAPI) advanceStatus(ids []string, status string, ts time.Time) {
+	targets := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		targets[id] = true
+	}
+	api.updateMessages(func(msg *MessageInfo) bool {
+		if !targets[msg.ID] || !msg.Source.IsFromMe || messageStatusRank[status] <= messageStatusRank[msg.Status] {
+			return false
+		}
+		msg.Status = status
+		// Copies handed out earlier share the backing array.
+		msg.statusHistory = append(slices.Clip(msg.statusHistory), StatusChange{Status: status, Timestamp: ts})
+		return true
+	})
+}
+
+// getMessageStatus reports the delivery state of a message we sent, along
+// with the states it went through. Sends that WhatsApp hasn't accepted yet
+// are looked up in the outbox.
+func (api *WhatsAppAPI) getMessageStatus(w http.ResponseWriter, r *http.Request) {
+	if api.client.Store.ID == nil {
+		http.Error(w, "Not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	id := vars["messageId"]
+	response := MessageStatusResponse{ID: id}
+	if msg, ok := api.findMessage(id); ok {
+		if !msg.Source.IsFromMe {
+			http.Error(w, "Only messages sent by this account have a delivery status", http.StatusBadRequest)
+			return
+		}
+		response.Status = msg.Status
+		// Messages start out sent. Those from a history sync start in the
+		// state they were synced in instead, without the receipts that led
+		// to it.
+		initial := StatusChange{Status: MessageStatusSent, Timestamp: msg.Timestamp}
+		if len(msg.statusHistory) == 0 {
+			initial.Status = msg.Status
+		}
+		response.History = append([]StatusChange{initial}, msg.statusHistory...)
+	} else {
+		api.outbox.mu.Lock()
+		_, entry := api.outbox.find(id)
+		if entry != nil {
+			response.Status = entry.Status
+			response.Error = entry.LastError
+		}
+		api.outbox.mu.Unlock()
+		if entry == nil {
+			http.Error(w, "Message not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// eventBufferSize is how many events a slow subscriber may lag behind before
+// further events are dropped for it.
+const eventBufferSize = 64
+
+type Event struct {
+	Type      string      `json:"event"`
+	Timestamp time.Time   `json:"timestamp"`
+	Data      interface{} `json:"data"`
+}
+
+// EventBus fans events out to any number of in-process subscribers, such as
+// the server-sent events stream.
+type EventBus struct {
+	mu          sync.Mutex
+	subscribers map[chan Event]struct{}
+	closed      bool
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{subscribers: make(map[chan Event]struct{})}
+}
+
+func (b *EventBus) Subscribe() chan Event {
+	ch := make(chan Event, eventBufferSize)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(ch)
+		return ch
+	}
+	b.subscribers[ch] = struct{}{}
+	return ch
+}
+
+func (b *EventBus) Unsubscribe(ch chan Event) {
+	b.mu.Lock()
+	delete(b.subscribers, ch)
+	b.mu.Unlock()
+}
+
+// Close closes every subscriber channel so that streams end, e.g. on
+// shutdown. Later subscribers get an already closed channel.
+func (b *EventBus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for ch := range b.subscribers {
+		close(ch)
+		delete(b.subscribers, ch)
+	}
+}
+
+// Publish never blocks: a subscriber whose buffer is full misses the event
+// rather than stalling the whatsmeow event handler.
+func (b *EventBus) Publish(evt Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- evt:
+		default:
+		}
+	}
+}
+
+// emit publishes an event to in-process subscribers and webhooks.
+func (api *WhatsAppAPI) emit(event string, data interface{}) {
+	api.events.Publish(Event{Type: event, Timestamp: time.Now(), Data: data})
+	api.webhooks.dispatch(event, data)
+}
+
+func splitList(raw string) []string {
+	var items []string
+	for _, item := range strings.Split(raw, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// streamEvents is a server-sent events endpoint delivering events as they
+// happen. An optional comma separated "events" query parameter filters them.
+func (api *WhatsAppAPI) streamEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	filter := Webhook{Events: splitList(r.URL.Query().Get("events"))}
+
+	ch := api.events.Subscribe()
+	defer api.events.Unsubscribe(ch)
+
+	// Streams are long-lived, so the server's write timeout doesn't apply.
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	// Comments keep idle connections from being closed by proxies.
+	keepAlive := time.NewTicker(30 * time.Second)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case evt, ok := <-ch:
+			if !ok {
+				return
+			}
+			if !filter.wants(evt.Type) {
+				continue
+			}
+			data, err := json.Marshal(evt)
+			if err != nil {
+				api.requestLog(r).Errorf("Failed to encode %s event: %v", evt.Type, err)
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", evt.Type, data)
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+type ForwardRequest struct {
+	ChatID string `json:"chat_id"`
+}
+
+// forwardedContext marks a message as forwarded, counting how often it has
+// been forwarded before like the WhatsApp apps do.
+func forwardedContext(original *waE2E.ContextInfo) *waE2E.ContextInfo {
+	return &waE2E.ContextInfo{
+		IsForwarded:     proto.Bool(true),
+		ForwardingScore: proto.Uint32(original.GetForwardingScore() + 1),
+	}
+}
+
+// forwardedMedia returns a copy of the media message m pointing at a fresh
+// upload of its file and marked as forwarded. Everything else, such as the
+// caption, voice note flag or dimensions, is kept.
+func forwardedMedia(m *waE2E.Message, uploaded whatsmeow.UploadResponse) *waE2E.Message {
+	m = proto.Clone(m).(*waE2E.Message)
+	switch {
+	case m.GetImageMessage() != nil:
+		image := m.GetImageMessage()
+		image.URL = proto.String(uploaded.URL)
+		image.DirectPath = proto.String(uploaded.DirectPath)
+		image.MediaKey = uploaded.MediaKey
+		image.FileEncSHA256 = uploaded.FileEncSHA256
+		image.FileSHA256 = uploaded.FileSHA256
+		image.FileLength = proto.Uint64(uploaded.FileLength)
+		image.ContextInfo = forwardedContext(image.GetContextInfo())
+	case m.GetVideoMessage() != nil:
+		video := m.GetVideoMessage()
+		video.URL = proto.String(uploaded.URL)
+		video.DirectPath = proto.String(uploaded.DirectPath)
+		video.MediaKey = uploaded.MediaKey
+		video.FileEncSHA256 = uploaded.FileEncSHA256
+		video.FileSHA256 = uploaded.FileSHA256
+		video.FileLength = proto.Uint64(uploaded.FileLength)
+		video.ContextInfo = forwardedContext(video.GetContextInfo())
+	case m.GetAudioMessage() != nil:
+		audio := m.GetAudioMessage()
+		audio.URL = proto.String(uploaded.URL)
+		audio.DirectPath = proto.String(uploaded.DirectPath)
+		audio.MediaKey = uploaded.MediaKey
+		audio.FileEncSHA256 = uploaded.FileEncSHA256
+		audio.FileSHA256 = uploaded.FileSHA256
+		audio.FileLength = proto.Uint64(uploaded.FileLength)
+		audio.ContextInfo = forwardedContext(audio.GetContextInfo())
+	case m.GetDocumentMessage() != nil:
+		document := m.GetDocumentMessage()
+		document.URL = proto.String(uploaded.URL)
+		document.DirectPath = proto.String(uploaded.DirectPath)
+		document.MediaKey = uploaded.MediaKey
+		document.FileEncSHA256 = uploaded.FileEncSHA256
+		document.FileSHA256 = uploaded.FileSHA256
+		document.FileLength = proto.Uint64(uploaded.FileLength)
+		document.ContextInfo = forwardedContext(document.GetContextInfo())
+	case m.GetStickerMessage() != nil:
+		sticker := m.GetStickerMessage()
+		sticker.URL = proto.String(uploaded.URL)
+		sticker.DirectPath = proto.String(uploaded.DirectPath)
+		sticker.MediaKey = uploaded.MediaKey
+		sticker.FileEncSHA256 = uploaded.FileEncSHA256
+		sticker.FileSHA256 = uploaded.FileSHA256
+		sticker.FileLength = proto.Uint64(uploaded.FileLength)
+		sticker.ContextInfo = forwardedContext(sticker.GetContextInfo())
+	}
+	return m
+}
+
+// uploadMediaType is the upload type matching a media message; stickers
+// are uploaded like images.
+func uploadMediaType(m *waE2E.Message) whatsmeow.MediaType {
+	switch {
+	case m.GetVideoMessage() != nil:
+		return whatsmeow.MediaVideo
+	case m.GetAudioMessage() != nil:
+		return whatsmeow.MediaAudio
+	case m.GetDocumentMessage() != nil:
+		return whatsmeow.MediaDocument
+	}
+	return whatsmeow.MediaImage
+}
+
+// forwardMessage sends a copy of a stored message to another chat, marked
+// as forwarded. Media is downloaded and uploaded again, so the forward
+// doesn't depend on the original upload, which WhatsApp purges after a
+// while.
+func (api *WhatsAppAPI) forwardMessage(w http.ResponseWriter, r *http.Request) {
+	if !api.requireConnected(w) {
+		return
+	}
+
+	var req ForwardRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	chatJID, err := types.ParseJID(req.ChatID)
+	if err != nil {
+		http.Error(w, "Invalid chat JID", http.StatusBadRequest)
+		return
+	}
+
+	original, ok := api.findMessage(mux.Vars(r)["messageId"])
+	if !ok {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+	if original.RevokedAt != nil {
+		http.Error(w, "Deleted messages can't be forwarded", http.StatusBadRequest)
+		return
+	}
+
+	var msg *waE2E.Message
+	switch {
+	case original.raw != nil:
+		data, ok := api.downloadMedia(w, r, original)
+		if !ok {
+			return
+		}
+		if !api.allowSend(w) {
+			return
+		}
+		uploaded, err := api.client.Upload(r.Context(), data, uploadMediaType(original.raw))
+		if err != nil {
+			api.requestLog(r).Errorf("Failed to upload forwarded media of %s: %v", original.ID, err)
+			http.Error(w, "Failed to upload media", http.StatusInternalServerError)
+			return
+		}
+		msg = forwardedMedia(original.raw, uploaded)
+	case original.Content.Type == "text":
+		if !api.allowSend(w) {
+			return
+		}
+		msg = &waE2E.Message{ExtendedTextMessage: &waE2E.ExtendedTextMessage{
+			Text:        proto.String(original.Content.Text),
+			ContextInfo: forwardedContext(nil),
+		}}
+	default:
+		http.Error(w, "Only text and media messages can be forwarded", http.StatusBadRequest)
+		return
+	}
+
+	resp, err := api.sendOutbound(r.Context(), chatJID, msg)
+	if err != nil {
+		api.requestLog(r).Errorf("Failed to forward message %s to %s: %v", original.ID, chatJID, err)
+		http.Error(w, "Failed to forward message", http.StatusInternalServerError)
+		return
+	}
+
+	sent := MessageInfo{
+		ID:        resp.ID,
+		Timestamp: resp.Timestamp,
+		Source: MessageSource{
+			Chat:     chatJID.String(),
+			Sender:   api.client.Store.ID.ToNonAD().String(),
+			IsFromMe: true,
+			IsGroup:  chatJID.Server == types.GroupServer,
+		},
+		Content: extractMessageContent(msg),
+		IsRead:  true,
+		Status:  MessageStatusSent,
+	}
+	if sent.Content.Media != nil {
+		sent.raw = msg
+	}
+	api.appendMessage(sent)
+	if hasThumbnail(sent) {
+		go api.saveThumbnail(sent)
+	}
+
+	response := SendResponse{ID: resp.ID, Timestamp: resp.Timestamp}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
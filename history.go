@@ -0,0 +1,92 @@
+package main
+
+import (
+	"sort"
+
+	"go.mau.fi/whatsmeow/proto/waHistorySync"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// handleHistorySync backfills messages sent before this device was linked.
+// Backfilled messages are stored without emitting events, since clients
+// would otherwise be flooded with old messages on every new link.
+func (api *WhatsAppAPI) handleHistorySync(evt *events.HistorySync) {
+	data := evt.Data
+	if data.GetSyncType() == waHistorySync.HistorySync_PUSH_NAME {
+		for _, pushName := range data.GetPushnames() {
+			jid, err := types.ParseJID(pushName.GetID())
+			if err == nil && pushName.GetPushname() != "" {
+				api.updatePushName(jid, pushName.GetPushname())
+			}
+		}
+		return
+	}
+
+	stored := api.snapshotMessages()
+	known := make(map[string]bool, len(stored))
+	for _, msg := range stored {
+		known[msg.ID] = true
+	}
+
+	added := 0
+	for _, conv := range data.GetConversations() {
+		chatJID, err := types.ParseJID(conv.GetID())
+		if err != nil {
+			api.log.Warnf("Skipping history of invalid chat %q: %v", conv.GetID(), err)
+			continue
+		}
+
+		var backfill []MessageInfo
+		for _, historyMsg := range conv.GetMessages() {
+			evt, err := api.client.ParseWebMessage(chatJID, historyMsg.GetMessage())
+			if err != nil {
+				api.log.Debugf("Skipping unparseable history message in %s: %v", chatJID, err)
+				continue
+			}
+			// Reactions and edits in the history are already applied to the
+			// messages they target.
+			if evt.Message.GetReactionMessage() != nil || evt.Message.GetProtocolMessage() != nil {
+				continue
+			}
+			if known[evt.Info.ID] {
+				continue
+			}
+			known[evt.Info.ID] = true
+			msg := newMessageInfo(evt)
+			if msg.Source.IsFromMe {
+				msg.Status = historyStatus(historyMsg.GetMessage().GetStatus())
+			}
+			backfill = append(backfill, msg)
+		}
+
+		// Only the newest unread_count incoming messages are unread, so
+		// older history isn't reported as unread.
+		sort.Slice(backfill, func(i, j int) bool {
+			return backfill[i].Timestamp.After(backfill[j].Timestamp)
+		})
+		unread := int(conv.GetUnreadCount())
+		for i := range backfill {
+			if backfill[i].Source.IsFromMe {
+				backfill[i].IsRead = true
+			} else if unread > 0 {
+				unread--
+			} else {
+				backfill[i].IsRead = true
+			}
+		}
+
+		api.appendMessage(backfill...)
+		added += len(backfill)
+	}
+
+	// Keep the history in chronological order, as live messages are.
+	api.replaceMessages(func(messages []MessageInfo) []MessageInfo {
+		sort.SliceStable(messages, func(i, j int) bool {
+			return messages[i].Timestamp.Before(messages[j].Timestamp)
+		})
+		return messages
+	})
+	api.log.Infof("History sync (%s) added %d messages from %d conversations",
+		data.GetSyncType(), added, len(data.GetConversations()))
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+// maxImageSize limits multipart image uploads held in memory; larger ones
+// spill to disk. The accepted size is set by media_limits.
+const maxImageSize = 16 << 20
+
+// thumbnailSize is the longest side in pixels of the preview WhatsApp shows
+// before the image is downloaded.
+const thumbnailSize = 72
+
+// imageMimeTypes are the formats that can be decoded to build a thumbnail.
+var imageMimeTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+}
+
+type SendImageRequest struct {
+	ChatID string `json:"chat_id"`
+	// Image is the JPEG or PNG file, base64 encoded in JSON.
+	Image     []byte `json:"image"`
+	Caption   string `json:"caption,omitempty"`
+	ReplyToID string `json:"reply_to_id,omitempty"`
+	// StripMetadata overrides the strip_image_metadata config.
+	StripMetadata *bool `json:"strip_metadata,omitempty"`
+}
+
+// readImageRequest accepts either a multipart form with an "image" file or a
+// JSON body with a base64 image.
+func readImageRequest(r *http.Request) (SendImageRequest, error) {
+	var req SendImageRequest
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		err := json.NewDecoder(r.Body).Decode(&req)
+		return req, err
+	}
+
+	if err := r.ParseMultipartForm(maxImageSize); err != nil {
+		return req, err
+	}
+	file, _, err := r.FormFile("image")
+	if err != nil {
+		return req, err
+	}
+	defer file.Close()
+
+	req.Image, err = io.ReadAll(file)
+	if err != nil {
+		return req, err
+	}
+	req.ChatID = r.FormValue("chat_id")
+	req.Caption = r.FormValue("caption")
+	req.ReplyToID = r.FormValue("reply_to_id")
+	if raw := r.FormValue("strip_metadata"); raw != "" {
+		strip, err := strconv.ParseBool(raw)
+		if err != nil {
+			return req, err
+		}
+		req.StripMetadata = &strip
+	}
+	return req, nil
+}
+
+// thumbnail scales img down so that its longest side is thumbnailSize and
+// encodes it as JPEG, which is what WhatsApp expects for previews.
+func thumbnail(img image.Image) ([]byte, error) {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return nil, errors.New("image is empty")
+	}
+
+	scaledWidth, scaledHeight := thumbnailSize, thumbnailSize
+	if width > height {
+		scaledHeight = max(1, height*thumbnailSize/width)
+	} else {
+		scaledWidth = max(1, width*thumbnailSize/height)
+	}
+
+	// Nearest-neighbour sampling is good enough at this size.
+	scaled := image.NewRGBA(image.Rect(0, 0, scaledWidth, scaledHeight))
+	for y := 0; y < scaledHeight; y++ {
+		for x := 0; x < scaledWidth; x++ {
+			scaled.Set(x, y, img.At(bounds.Min.X+x*width/scaledWidth, bounds.Min.Y+y*height/scaledHeight))
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, scaled, &jpeg.Options{Quality: 75}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (api *WhatsAppAPI) sendImageMessage(w http.ResponseWriter, r *http.Request) {
+	if !api.requireConnected(w) {
+		return
+	}
+
+	limits := api.getMediaLimits()
+	// Base64 in JSON takes up to a third more than the image itself.
+	r.Body = http.MaxBytesReader(w, r.Body, limits.Image.MaxSize*2)
+	req, err := readImageRequest(r)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	chatJID, err := types.ParseJID(req.ChatID)
+	if err != nil {
+		http.Error(w, "Invalid chat JID", http.StatusBadRequest)
+		return
+	}
+	mimeType := http.DetectContentType(req.Image)
+	if err := limits.Image.check("Image", len(req.Image), mimeType); err != nil {
+		writeError(w, err, "Image is not accepted")
+		return
+	}
+	if !imageMimeTypes[mimeType] {
+		http.Error(w, "Image must be a JPEG or PNG", http.StatusBadRequest)
+		return
+	}
+	img, _, err := image.Decode(bytes.NewReader(req.Image))
+	if err != nil {
+		http.Error(w, "Image could not be decoded", http.StatusBadRequest)
+		return
+	}
+	if api.stripImageMetadata(req.StripMetadata) {
+		if req.Image, img, err = reencodeImage(req.Image); err != nil {
+			api.requestLog(r).Errorf("Failed to re-encode image: %v", err)
+			http.Error(w, "Failed to strip image metadata", http.StatusInternalServerError)
+			return
+		}
+	}
+	thumb, err := thumbnail(img)
+	if err != nil {
+		http.Error(w, "Image could not be decoded", http.StatusBadRequest)
+		return
+	}
+	contextInfo, ok := api.replyContext(w, req.ReplyToID)
+	if !ok {
+		return
+	}
+
+	if !api.allowSend(w) {
+		return
+	}
+	uploaded, err := api.client.Upload(r.Context(), req.Image, whatsmeow.MediaImage)
+	if err != nil {
+		api.requestLog(r).Errorf("Failed to upload image: %v", err)
+		http.Error(w, "Failed to upload image", http.StatusInternalServerError)
+		return
+	}
+
+	bounds := img.Bounds()
+	msg := &waE2E.Message{ImageMessage: &waE2E.ImageMessage{
+		Caption:       proto.String(req.Caption),
+		Mimetype:      proto.String(mimeType),
+		Width:         proto.Uint32(uint32(bounds.Dx())),
+		Height:        proto.Uint32(uint32(bounds.Dy())),
+		JPEGThumbnail: thumb,
+		URL:           proto.String(uploaded.URL),
+		DirectPath:    proto.String(uploaded.DirectPath),
+		MediaKey:      uploaded.MediaKey,
+		FileEncSHA256: uploaded.FileEncSHA256,
+		FileSHA256:    uploaded.FileSHA256,
+		FileLength:    proto.Uint64(uploaded.FileLength),
+		ContextInfo:   contextInfo,
+	}}
+
+	resp, err := api.sendOutbound(r.Context(), chatJID, msg)
+	if err != nil {
+		api.requestLog(r).Errorf("Failed to send image to %s: %v", chatJID, err)
+		http.Error(w, "Failed to send image", http.StatusInternalServerError)
+		return
+	}
+
+	sent := MessageInfo{
+		ID:        resp.ID,
+		Timestamp: resp.Timestamp,
+		Source: MessageSource{
+			Chat:     chatJID.String(),
+			Sender:   api.client.Store.ID.ToNonAD().String(),
+			IsFromMe: true,
+			IsGroup:  chatJID.Server == types.GroupServer,
+		},
+		Content: extractMessageContent(msg),
+		IsRead:  true,
+		Status:  MessageStatusSent,
+		raw:     msg,
+	}
+	api.appendMessage(sent)
+	api.saveThumbnail(sent)
+
+	response := SendResponse{ID: resp.ID, Timestamp: resp.Timestamp}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"mime"
+	"net/http"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultMaxBodySize   = 1 << 20
+	defaultMaxUploadSize = 32 << 20
+)
+
+// MediaLimit restricts one kind of media. MaxSize is in bytes; MimeTypes
+// lists the accepted types, with none meaning any the endpoint supports.
+type MediaLimit struct {
+	MaxSize   int64    `json:"max_size"`
+	MimeTypes []string `json:"mime_types"`
+}
+
+// MediaLimits restricts the media sent through the API. Audio applies to
+// voice notes as uploaded, before any conversion, and MaxAudioSeconds to
+// their duration, with 0 meaning no limit.
+type MediaLimits struct {
+	Image           MediaLimit `json:"image"`
+	Video           MediaLimit `json:"video"`
+	Audio           MediaLimit `json:"audio"`
+	MaxAudioSeconds uint32     `json:"max_audio_seconds"`
+}
+
+// defaultMediaLimits follows WhatsApp's own 16 MB cap on media.
+var defaultMediaLimits = MediaLimits{
+	Image: MediaLimit{MaxSize: 16 << 20},
+	Video: MediaLimit{MaxSize: 16 << 20},
+	Audio: MediaLimit{MaxSize: 16 << 20},
+}
+
+func (l MediaLimits) validate() error {
+	for name, limit := range map[string]MediaLimit{"image": l.Image, "video": l.Video, "audio": l.Audio} {
+		if limit.MaxSize < 1 {
+			return errors.New("media_limits." + name + ".max_size must be a positive number of bytes")
+		}
+		for _, mimeType := range limit.MimeTypes {
+			if _, _, err := mime.ParseMediaType(mimeType); err != nil {
+				return errors.New("media_limits." + name + ".mime_types contains an invalid type: " + mimeType)
+			}
+		}
+	}
+	return nil
+}
+
+// sniffMimeType detects the type of data from its content. Ogg is reported
+// as audio/ogg, which is what WhatsApp calls it.
+func sniffMimeType(data []byte) string {
+	mimeType, _, _ := mime.ParseMediaType(http.DetectContentType(data))
+	if mimeType == "application/ogg" {
+		return "audio/ogg"
+	}
+	return mimeType
+}
+
+// check tests media of kind (e.g. "Image") against the limit, returning an
+// *httpError with 413 or 415 if it isn't accepted. Parameters of mimeType
+// such as codecs are ignored.
+func (l MediaLimit) check(kind string, size int, mimeType string) error {
+	if int64(size) > l.MaxSize {
+		return &httpError{status: http.StatusRequestEntityTooLarge,
+			msg: kind + " is larger than the limit of " + strconv.FormatInt(l.MaxSize, 10) + " bytes"}
+	}
+	if len(l.MimeTypes) == 0 {
+		return nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(mimeType)
+	if !slices.ContainsFunc(l.MimeTypes, func(allowed string) bool {
+		allowedType, _, _ := mime.ParseMediaType(allowed)
+		return strings.EqualFold(allowedType, mediaType)
+	}) {
+		return &httpError{status: http.StatusUnsupportedMediaType,
+			msg: kind + " type " + mediaType + " is not allowed, expected one of " + strings.Join(l.MimeTypes, ", ")}
+	}
+	return nil
+}
+
+func (api *WhatsAppAPI) getMediaLimits() MediaLimits {
+	api.mediaLimitsMu.Lock()
+	defer api.mediaLimitsMu.Unlock()
+	return api.mediaLimits
+}
+
+func (api *WhatsAppAPI) setMediaLimits(l MediaLimits) {
+	api.mediaLimitsMu.Lock()
+	defer api.mediaLimitsMu.Unlock()
+	api.mediaLimits = l
+}
+
+// uploadPaths get the larger upload limit, as they carry media either as
+// multipart files or base64 encoded in JSON.
+var uploadPaths = map[string]bool{
+	"/messages/send-voice": true,
+	"/messages/send-image": true,
+	"/status":              true,
+	"/auto-reply/voice":    true,
+}
+
+// isUploadPath reports whether path gets the larger upload limit. Chunks of
+// resumable uploads go to /uploads/{uploadId}; templates carry only text.
+func isUploadPath(path string) bool {
+	return uploadPaths[path] || strings.HasPrefix(path, "/uploads/")
+}
+
+// bodyLimitMiddleware caps request bodies so that a huge body can't exhaust
+// memory. Bodies that announce their size are rejected with 413 up front;
+// others fail once they are read past the limit.
+func bodyLimitMiddleware(maxBody, maxUpload int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := maxBody
+		if isUploadPath(r.URL.Path) {
+			limit = maxUpload
+		}
+		if r.ContentLength > limit {
+			http.Error(w, "Request body too large, the limit is "+strconv.FormatInt(limit, 10)+" bytes",
+				http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// recoverMiddleware turns a panicking handler into a 500 response with the
+// stack logged, rather than a dropped connection.
+func recoverMiddleware(log *slog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+			log.Error("Handler panicked",
+				"request_id", requestID(r.Context()),
+				"method", r.Method,
+				"path", r.URL.Path,
+				"panic", err,
+				"stack", string(debug.Stack()))
+			// This fails harmlessly if the handler had already responded.
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBodyLimitMiddleware(t *testing.T) {
+	const maxBody, maxUpload = 16, 64
+	handler := bodyLimitMiddleware(maxBody, maxUpload, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadAll(r.Body); err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		}
+	}))
+
+	tests := []struct {
+		name string
+		path string
+		size int
+		// chunked hides the size, so the body is only cut off while read.
+		chunked bool
+		want    int
+	}{
+		{"within limit", "/messages/send", maxBody, false, http.StatusOK},
+		{"oversized", "/messages/send", maxBody + 1, false, http.StatusRequestEntityTooLarge},
+		{"oversized without length", "/messages/send", maxBody + 1, true, http.StatusRequestEntityTooLarge},
+		{"upload within limit", "/messages/send-voice", maxUpload, false, http.StatusOK},
+		{"oversized upload", "/messages/send-voice", maxUpload + 1, false, http.StatusRequestEntityTooLarge},
+		{"upload chunk", "/uploads/abc", maxUpload, false, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body io.Reader = strings.NewReader(strings.Repeat("x", tt.size))
+			if tt.chunked {
+				body = io.MultiReader(body)
+			}
+			req := httptest.NewRequest(http.MethodPost, tt.path, body)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
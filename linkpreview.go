@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"image"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// linkPreviewTimeout bounds fetching the page and its image together, as
+	// the message waits for the preview.
+	linkPreviewTimeout = 5 * time.Second
+	// Only the head of the page is needed for its metadata.
+	maxPreviewPage  = 512 << 10
+	maxPreviewImage = 5 << 20
+)
+
+var (
+	urlPattern = regexp.MustCompile(`https?://[^\s<>"]+`)
+	// Pages are only searched for <meta> and <title> tags, which doesn't
+	// need a full HTML parser.
+	metaTagPattern  = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	attrPattern     = regexp.MustCompile(`(?is)([a-z:-]+)\s*=\s*("[^"]*"|'[^']*')`)
+	titleTagPattern = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+)
+
+// LinkPreview is the metadata of a page shown above a message linking to it.
+type LinkPreview struct {
+	URL         string
+	Title       string
+	Description string
+	ImageURL    string
+	Thumbnail   []byte
+}
+
+// firstURL returns the first http(s) URL in text, without trailing
+// punctuation that is more likely part of the sentence.
+func firstURL(text string) string {
+	return strings.TrimRight(urlPattern.FindString(text), ".,;:!?)'")
+}
+
+// publicOnly refuses connections to loopback, private and link-local
+// addresses, so that previews can't be used to probe the internal network.
+// It runs after DNS resolution, so it covers redirects and hostnames too.
+func publicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() {
+		return fmt.Errorf("%s is not a public address", host)
+	}
+	return nil
+}
+
+var previewClient = &http.Client{
+	Transport: &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: linkPreviewTimeout, Control: publicOnly}).DialContext,
+		TLSHandshakeTimeout: linkPreviewTimeout,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 5 {
+			return errors.New("too many redirects")
+		}
+		return nil
+	},
+}
+
+func fetchLimited(ctx context.Context, target string, limit int64) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	// Some sites only serve their metadata to browsers and link crawlers.
+	req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; WhatsApp link preview)")
+	resp, err := previewClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, limit))
+	return data, resp.Header.Get("Content-Type"), err
+}
+
+// pageMetadata reads the Open Graph title, description and image of a page,
+// falling back to its <title> and meta description.
+func pageMetadata(page string) (title, description, imageURL string) {
+	meta := make(map[string]string)
+	for _, tag := range metaTagPattern.FindAllString(page, -1) {
+		attrs := make(map[string]string)
+		for _, attr := range attrPattern.FindAllStringSubmatch(tag, -1) {
+			attrs[strings.ToLower(attr[1])] = html.UnescapeString(strings.Trim(attr[2], `"'`))
+		}
+		key := attrs["property"]
+		if key == "" {
+			key = attrs["name"]
+		}
+		if key = strings.ToLower(key); key != "" && meta[key] == "" {
+			meta[key] = strings.TrimSpace(attrs["content"])
+		}
+	}
+
+	title = meta["og:title"]
+	if title == "" {
+		if match := titleTagPattern.FindStringSubmatch(page); match != nil {
+			title = strings.TrimSpace(html.UnescapeString(match[1]))
+		}
+	}
+	description = meta["og:description"]
+	if description == "" {
+		description = meta["description"]
+	}
+	return title, description, meta["og:image"]
+}
+
+// fetchLinkPreview builds a preview for pageURL. The thumbnail is optional: a
+// page whose image can't be loaded still gets a title and description.
+func fetchLinkPreview(ctx context.Context, pageURL string) (LinkPreview, error) {
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return LinkPreview{}, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, linkPreviewTimeout)
+	defer cancel()
+
+	page, contentType, err := fetchLimited(ctx, pageURL, maxPreviewPage)
+	if err != nil {
+		return LinkPreview{}, err
+	}
+	if !strings.HasPrefix(contentType, "text/html") {
+		return LinkPreview{}, fmt.Errorf("page is %s, not HTML", contentType)
+	}
+	preview := LinkPreview{URL: pageURL}
+	preview.Title, preview.Description, preview.ImageURL = pageMetadata(string(page))
+	if preview.Title == "" {
+		return LinkPreview{}, errors.New("page has no title")
+	}
+	// Image URLs may be relative to the page.
+	if ref, err := base.Parse(preview.ImageURL); err == nil && preview.ImageURL != "" {
+		preview.ImageURL = ref.String()
+	}
+
+	if preview.ImageURL != "" {
+		data, _, err := fetchLimited(ctx, preview.ImageURL, maxPreviewImage)
+		if err == nil {
+			if img, _, err := image.Decode(bytes.NewReader(data)); err == nil {
+				preview.Thumbnail, _ = thumbnail(img)
+			}
+		}
+	}
+	return preview, nil
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	defaultLiveLocationDuration = 15 * time.Minute
+	// maxLiveLocationDuration matches the longest share the WhatsApp apps
+	// offer.
+	maxLiveLocationDuration = 8 * time.Hour
+)
+
+// LocationInfo is the position carried by a location or live location
+// message. Each live location update is stored as a message of its own.
+type LocationInfo struct {
+	Latitude       float64 `json:"latitude"`
+	Longitude      float64 `json:"longitude"`
+	AccuracyMeters uint32  `json:"accuracy_meters,omitempty"`
+	SpeedMps       float32 `json:"speed_mps,omitempty"`
+	// Heading is in degrees clockwise from magnetic north.
+	Heading  uint32 `json:"heading,omitempty"`
+	Name     string `json:"name,omitempty"`
+	Address  string `json:"address,omitempty"`
+	Sequence int64  `json:"sequence,omitempty"`
+	// TimeOffset is the number of seconds since the live share started.
+	TimeOffset uint32 `json:"time_offset,omitempty"`
+}
+
+func extractLocationContent(m *waE2E.Message) (MessageContent, bool) {
+	if live := m.GetLiveLocationMessage(); live != nil {
+		return MessageContent{
+			Text: live.GetCaption(),
+			Type: "live_location",
+			Location: &LocationInfo{
+				Latitude:       live.GetDegreesLatitude(),
+				Longitude:      live.GetDegreesLongitude(),
+				AccuracyMeters: live.GetAccuracyInMeters(),
+				SpeedMps:       live.GetSpeedInMps(),
+				Heading:        live.GetDegreesClockwiseFromMagneticNorth(),
+				Sequence:       live.GetSequenceNumber(),
+				TimeOffset:     live.GetTimeOffset(),
+			},
+			QuotedID: live.GetContextInfo().GetStanzaID(),
+		}, true
+	} else if loc := m.GetLocationMessage(); loc != nil {
+		return MessageContent{
+			Text: loc.GetComment(),
+			Type: "location",
+			Location: &LocationInfo{
+				Latitude:       loc.GetDegreesLatitude(),
+				Longitude:      loc.GetDegreesLongitude(),
+				AccuracyMeters: loc.GetAccuracyInMeters(),
+				SpeedMps:       loc.GetSpeedInMps(),
+				Heading:        loc.GetDegreesClockwiseFromMagneticNorth(),
+				Name:           loc.GetName(),
+				Address:        loc.GetAddress(),
+			},
+			QuotedID: loc.GetContextInfo().GetStanzaID(),
+		}, true
+	}
+	return MessageContent{}, false
+}
+
+// liveShare is a live location share started through the API. Updates are
+// only accepted until it expires.
+type liveShare struct {
+	chat     types.JID
+	caption  string
+	started  time.Time
+	expires  time.Time
+	sequence int64
+}
+
+type LiveLocationShare struct {
+	ID        string    `json:"id"`
+	ChatID    string    `json:"chat_id"`
+	Caption   string    `json:"caption,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Sequence  int64     `json:"sequence"`
+}
+
+type LiveLocationPosition struct {
+	Latitude       float64 `json:"latitude"`
+	Longitude      float64 `json:"longitude"`
+	AccuracyMeters uint32  `json:"accuracy_meters"`
+	SpeedMps       float32 `json:"speed_mps"`
+	Heading        uint32  `json:"heading"`
+}
+
+func (p LiveLocationPosition) valid() bool {
+	return p.Latitude >= -90 && p.Latitude <= 90 &&
+		p.Longitude >= -180 && p.Longitude <= 180 &&
+		p.Heading < 360
+}
+
+type StartLiveLocationRequest struct {
+	ChatID string `json:"chat_id"`
+	LiveLocationPosition
+	Caption string `json:"caption"`
+	// Duration is a Go duration string and defaults to 15 minutes.
+	Duration string `json:"duration"`
+}
+
+// LiveLocationResponse is returned for the start of a share and for every
+// update, each of which is a message of its own.
+type LiveLocationResponse struct {
+	SendResponse
+	ShareID   string    `json:"share_id"`
+	Sequence  int64     `json:"sequence"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func liveLocationMessage(share *liveShare, pos LiveLocationPosition, now time.Time) *waE2E.Message {
+	return &waE2E.Message{LiveLocationMessage: &waE2E.LiveLocationMessage{
+		DegreesLatitude:                   proto.Float64(pos.Latitude),
+		DegreesLongitude:                  proto.Float64(pos.Longitude),
+		AccuracyInMeters:                  proto.Uint32(pos.AccuracyMeters),
+		SpeedInMps:                        proto.Float32(pos.SpeedMps),
+		DegreesClockwiseFromMagneticNorth: proto.Uint32(pos.Heading),
+		Caption:                           proto.String(share.caption),
+		SequenceNumber:                    proto.Int64(share.sequence),
+		TimeOffset:                        proto.Uint32(uint32(now.Sub(share.started) / time.Second)),
+	}}
+}
+
+// sendLiveLocation sends the current position of share and stores it like
+// any other sent message.
+func (api *WhatsAppAPI) sendLiveLocation(w http.ResponseWriter, r *http.Request, share *liveShare, shareID string, pos LiveLocationPosition) {
+	if !api.allowSend(w) {
+		return
+	}
+	msg := liveLocationMessage(share, pos, time.Now())
+	resp, err := api.sendOutbound(r.Context(), share.chat, msg)
+	if err != nil {
+		api.requestLog(r).Errorf("Failed to send live location to %s: %v", share.chat, err)
+		http.Error(w, "Failed to send live location", http.StatusInternalServerError)
+		return
+	}
+	if shareID == "" {
+		shareID = resp.ID
+		api.liveMu.Lock()
+		api.liveShares[shareID] = share
+		api.liveMu.Unlock()
+	}
+
+	api.appendMessage(MessageInfo{
+		ID:        resp.ID,
+		Timestamp: resp.Timestamp,
+		Source: MessageSource{
+			Chat:     share.chat.String(),
+			Sender:   api.client.Store.ID.ToNonAD().String(),
+			IsFromMe: true,
+			IsGroup:  share.chat.Server == types.GroupServer,
+		},
+		Content: extractMessageContent(msg),
+		IsRead:  true,
+		Status:  MessageStatusSent,
+	})
+
+	response := LiveLocationResponse{
+		SendResponse: SendResponse{ID: resp.ID, Timestamp: resp.Timestamp},
+		ShareID:      shareID,
+		Sequence:     share.sequence,
+		ExpiresAt:    share.expires,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// startLiveLocation starts sharing a live location with a chat. The share is
+// identified by the ID of its first message.
+func (api *WhatsAppAPI) startLiveLocation(w http.ResponseWriter, r *http.Request) {
+	if !api.requireConnected(w) {
+		return
+	}
+
+	var req StartLiveLocationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	chatJID, err := types.ParseJID(req.ChatID)
+	if err != nil {
+		http.Error(w, "Invalid chat JID", http.StatusBadRequest)
+		return
+	}
+	if !req.valid() {
+		http.Error(w, "Invalid coordinates", http.StatusBadRequest)
+		return
+	}
+	duration := defaultLiveLocationDuration
+	if req.Duration != "" {
+		duration, err = time.ParseDuration(req.Duration)
+		if err != nil || duration <= 0 || duration > maxLiveLocationDuration {
+			http.Error(w, "duration must be positive and at most 8h", http.StatusBadRequest)
+			return
+		}
+	}
+
+	now := time.Now()
+	share := &liveShare{
+		chat:     chatJID,
+		caption:  req.Caption,
+		started:  now,
+		expires:  now.Add(duration),
+		sequence: 1,
+	}
+	api.sendLiveLocation(w, r, share, "", req.LiveLocationPosition)
+}
+
+// updateLiveLocation sends a new position for an active share.
+func (api *WhatsAppAPI) updateLiveLocation(w http.ResponseWriter, r *http.Request) {
+	if !api.requireConnected(w) {
+		return
+	}
+
+	var pos LiveLocationPosition
+	if err := json.NewDecoder(r.Body).Decode(&pos); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !pos.valid() {
+		http.Error(w, "Invalid coordinates", http.StatusBadRequest)
+		return
+	}
+
+	shareID := mux.Vars(r)["shareId"]
+	api.liveMu.Lock()
+	current, ok := api.liveShares[shareID]
+	expired := ok && time.Now().After(current.expires)
+	var share liveShare
+	if ok && !expired {
+		// Sequence numbers are handed out under the lock so that concurrent
+		// updates don't reuse one.
+		current.sequence++
+		share = *current
+	}
+	api.liveMu.Unlock()
+	if !ok {
+		http.Error(w, "Live location share not found", http.StatusNotFound)
+		return
+	}
+	if expired {
+		http.Error(w, "Live location share has expired", http.StatusGone)
+		return
+	}
+
+	api.sendLiveLocation(w, r, &share, shareID, pos)
+}
+
+// stopLiveLocation ends a share early. Recipients keep the last position.
+func (api *WhatsAppAPI) stopLiveLocation(w http.ResponseWriter, r *http.Request) {
+	shareID := mux.Vars(r)["shareId"]
+	api.liveMu.Lock()
+	_, ok := api.liveShares[shareID]
+	delete(api.liveShares, shareID)
+	api.liveMu.Unlock()
+	if !ok {
+		http.Error(w, "Live location share not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getLiveLocations lists the shares that haven't expired yet, oldest first.
+// Expired shares are dropped as a side effect.
+func (api *WhatsAppAPI) getLiveLocations(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	shares := make([]LiveLocationShare, 0)
+
+	api.liveMu.Lock()
+	for id, share := range api.liveShares {
+		if now.After(share.expires) {
+			delete(api.liveShares, id)
+			continue
+		}
+		shares = append(shares, LiveLocationShare{
+			ID:        id,
+			ChatID:    share.chat.String(),
+			Caption:   share.caption,
+			StartedAt: share.started,
+			ExpiresAt: share.expires,
+			Sequence:  share.sequence,
+		})
+	}
+	api.liveMu.Unlock()
+
+	sort.Slice(shares, func(i, j int) bool {
+		return shares[i].StartedAt.Before(shares[j].StartedAt)
+	})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(shares)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// requestIDHeader carries the request ID. An ID sent by the caller, such as
+// the Python proxy, is kept so that both services log the same one.
+const requestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// newLogger returns the JSON logger every component logs through. LOG_LEVEL
+// is one of debug, info, warn or error and defaults to info.
+func newLogger() (*slog.Logger, error) {
+	var level slog.Level
+	if raw := os.Getenv("LOG_LEVEL"); raw != "" {
+		if err := level.UnmarshalText([]byte(raw)); err != nil {
+			return nil, fmt.Errorf("invalid LOG_LEVEL %q", raw)
+		}
+	}
+	return slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level})), nil
+}
+
+// slogLogger adapts slog to the logger interface whatsmeow and the rest of
+// the service use, so that all logs end up in the same place.
+type slogLogger struct {
+	log    *slog.Logger
+	module string
+}
+
+func newModuleLogger(log *slog.Logger, module string) waLog.Logger {
+	return &slogLogger{log: log.With("module", module), module: module}
+}
+
+func (l *slogLogger) logf(level slog.Level, msg string, args []any) {
+	ctx := context.Background()
+	if l.log.Enabled(ctx, level) {
+		l.log.Log(ctx, level, fmt.Sprintf(msg, args...))
+	}
+}
+
+func (l *slogLogger) Errorf(msg string, args ...any) { l.logf(slog.LevelError, msg, args) }
+func (l *slogLogger) Warnf(msg string, args ...any)  { l.logf(slog.LevelWarn, msg, args) }
+func (l *slogLogger) Infof(msg string, args ...any)  { l.logf(slog.LevelInfo, msg, args) }
+func (l *slogLogger) Debugf(msg string, args ...any) { l.logf(slog.LevelDebug, msg, args) }
+
+// Sub nests modules the way whatsmeow's own loggers do, e.g. Client/Socket.
+func (l *slogLogger) Sub(module string) waLog.Logger {
+	module = l.module + "/" + module
+	return &slogLogger{log: l.log.With("module", module), module: module}
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestLog returns the API logger tagged with the ID of request r.
+func (api *WhatsAppAPI) requestLog(r *http.Request) waLog.Logger {
+	return newModuleLogger(api.logger.With("request_id", requestID(r.Context())), "API")
+}
+
+// statusRecorder remembers the status code written through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush keeps event streams working through the recorder.
+func (w *statusRecorder) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack lets WebSocket upgrades through the recorder.
+func (w *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("connection can't be hijacked")
+	}
+	if w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return hijacker.Hijack()
+}
+
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// requestLogMiddleware assigns every request an ID, returned in the
+// X-Request-ID header, and logs it once it has been served. Probes are only
+// logged at debug level so they don't drown out everything else.
+func requestLogMiddleware(log *slog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if id == "" || len(id) > 64 {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		r = r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		level := slog.LevelInfo
+		if r.URL.Path == "/healthz" || r.URL.Path == "/readyz" {
+			level = slog.LevelDebug
+		}
+		log.LogAttrs(r.Context(), level, "HTTP request",
+			slog.String("request_id", id),
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", rec.status),
+			slog.Duration("duration", time.Since(start)),
+		)
+	})
+}
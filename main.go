@@ -2,28 +2,172 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/skip2/go-qrcode"
 	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
 	"go.mau.fi/whatsmeow/store/sqlstore"
 	"go.mau.fi/whatsmeow/types"
 	"go.mau.fi/whatsmeow/types/events"
 	waLog "go.mau.fi/whatsmeow/util/log"
+	"google.golang.org/protobuf/proto"
 )
 
+// defaultConnectionDebounce is how long a connection state must hold before
+// it is committed. The CONNECTION_DEBOUNCE environment variable changes the
+// default; a debounce saved through the config endpoint takes precedence.
+const defaultConnectionDebounce = 2 * time.Second
+
+// maxThreadDepth caps how many messages a reply thread lookup will collect.
+const maxThreadDepth = 50
+
+// qrRotation is roughly how long WhatsApp keeps a QR code valid before the
+// next one replaces it.
+const qrRotation = 20 * time.Second
+
+// qrImageSize is the width and height in pixels of rendered QR codes.
+const qrImageSize = 256
+
+// defaultQRTimeout is how long QR requests wait for the first code after
+// pairing starts before responding with 504.
+const defaultQRTimeout = 10 * time.Second
+
+// clockSkewThreshold is how far the local clock may drift from WhatsApp's
+// server time before it is reported as a likely cause of auth failures.
+const clockSkewThreshold = 30 * time.Second
+
+// clockCheckURL is requested on connect for the server time in its Date
+// header, which is available before any live message has arrived.
+const clockCheckURL = "https://web.whatsapp.com/"
+
 type WhatsAppAPI struct {
-	client     *whatsmeow.Client
-	log        waLog.Logger
+	client *whatsmeow.Client
+	log    waLog.Logger
+	logger *slog.Logger
+
+	// messages is shared by the event handler, HTTP handlers and
+	// background workers, so it is only accessed through appendMessage and
+	// the other helpers that hold messagesMu.
+	messagesMu sync.RWMutex
 	messages   []MessageInfo
-	currentQR  string
+
+	// pairingMu guards pairing, which event handlers update while HTTP
+	// handlers report it.
+	pairingMu sync.Mutex
+	pairing   *PairingState
+
+	// qrMu guards the current QR code. qrReady is closed while a code is
+	// available so requests can wait for one without touching the QR channel.
+	qrMu      sync.Mutex
+	currentQR string
+	qrUpdated time.Time
+	qrReady   chan struct{}
+	qrTimeout time.Duration
+
+	// liveEvents is set once the offline backlog has been delivered, so that
+	// message timestamps can be compared against the local clock. whatsmeow
+	// dispatches events from several goroutines, so it is atomic. clockMu
+	// guards clockSkew, which is read by HTTP handlers.
+	liveEvents atomic.Bool
+	clockMu    sync.Mutex
+	clockSkew  *ClockSkewInfo
+
+	// presences holds the latest chat presence (typing/recording) per chat.
+	presencesMu sync.Mutex
+	presences   map[string]PresenceInfo
+
+	callsMu sync.Mutex
+	calls   []CallInfo
+	// rejectCalls is changed through the API while calls come in.
+	rejectCalls atomic.Bool
+
+	voiceMu          sync.Mutex
+	voiceTranscoding VoiceTranscoding
+
+	mediaLimitsMu sync.Mutex
+	mediaLimits   MediaLimits
+
+	imageMetadataMu sync.Mutex
+	stripMetadata   bool
+
+	transcriptionMu sync.Mutex
+	transcription   Transcription
+
+	autoReplyMu    sync.Mutex
+	autoReply      AutoReply
+	autoReplyVoice *voiceNote
+	// autoReplied holds when each chat was last auto-replied to.
+	autoReplied map[string]time.Time
+
+	broadcastMu     sync.Mutex
+	broadcastPacing BroadcastPacing
+	broadcasts      *broadcasts
+
+	// liveShares holds live location shares started through the API, keyed
+	// by the ID of their first message.
+	liveMu     sync.Mutex
+	liveShares map[string]*liveShare
+
+	// mediaRetries holds the downloads waiting for the sender's phone to
+	// upload expired media again, keyed by message ID.
+	mediaRetryMu sync.Mutex
+	mediaRetries map[string][]chan *events.MediaRetry
+
+	// chatNames holds local display name overrides keyed by chat JID.
+	chatNamesMu sync.Mutex
+	chatNames   map[string]string
+	// groupNames caches group subjects keyed by group JID.
+	groupNamesMu sync.Mutex
+	groupNames   map[string]string
+	// chatStates holds archived and pinned flags keyed by chat JID.
+	chatStatesMu sync.Mutex
+	chatStates   map[string]ChatState
+
+	// contacts caches contact names keyed by non-AD JID. It is loaded from
+	// the device store on connect and kept fresh from contact events.
+	contactsMu sync.Mutex
+	contacts   map[string]ContactName
+
+	// Connection state changes are debounced so that flapping networks
+	// settle on a single status instead of reporting every transition.
+	statusMu         sync.Mutex
+	connectionStatus string
+	pendingStatus    string
+	statusTimer      *time.Timer
+	statusDebounce   time.Duration
+
+	events   *EventBus
+	webhooks *webhookDispatcher
+	outbox   *outbox
+	quality  *qualityTracker
+
+	settings   *settingsStore
+	mediaCache *mediaCache
+	thumbnails *thumbnailStore
+	archive    *archiver
+	uploads    *uploads
+	polls      *pollStore
+	templates  *templateStore
+	reconnect  *reconnector
+	sendLimit  *rateLimiter
+	metrics    *apiMetrics
+	activity   *activityTracker
 }
 
 type MessageInfo struct {
@@ -32,18 +176,63 @@ type MessageInfo struct {
 	Source    MessageSource   `json:"source"`
 	Content   MessageContent  `json:"content"`
 	IsRead    bool           `json:"is_read"`
+	Reactions []Reaction     `json:"reactions,omitempty"`
+	EditedAt  *time.Time     `json:"edited_at,omitempty"`
+	// RevokedAt is set once the message was deleted for everyone. Its
+	// content is cleared, but it stays in the history so clients can show
+	// that a message was deleted.
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	// Status is the delivery state of messages sent by this account.
+	Status string `json:"status,omitempty"`
+	// statusHistory lists the states receipts moved Status to.
+	statusHistory []StatusChange
+
+	// raw keeps the original proto of media messages so that their caption
+	// can be edited and the media downloaded again.
+	raw *waE2E.Message
+}
+
+type Reaction struct {
+	Sender    string    `json:"sender"`
+	Emoji     string    `json:"emoji"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// ReactionEvent is sent to webhooks when a reaction is added or removed.
+// TargetFromMe tells whether the reacted-to message was sent by this account.
+type ReactionEvent struct {
+	MessageID    string    `json:"message_id"`
+	Chat         string    `json:"chat"`
+	Sender       string    `json:"sender"`
+	Emoji        string    `json:"emoji"`
+	Removed      bool      `json:"removed"`
+	TargetFromMe bool      `json:"target_from_me"`
+	Timestamp    time.Time `json:"timestamp"`
 }
 
 type MessageSource struct {
 	Chat     string `json:"chat"`
 	Sender   string `json:"sender"`
+	PushName string `json:"push_name,omitempty"`
+	ChatName string `json:"chat_name,omitempty"`
 	IsFromMe bool   `json:"is_from_me"`
 	IsGroup  bool   `json:"is_group"`
 }
 
 type MessageContent struct {
-	Text string `json:"text,omitempty"`
-	Type string `json:"type"`
+	Text     string     `json:"text,omitempty"`
+	Type     string     `json:"type"`
+	QuotedID string     `json:"quoted_id,omitempty"`
+	Media    *MediaInfo `json:"media,omitempty"`
+	// Mentions are the JIDs @-mentioned in a text message.
+	Mentions []string `json:"mentions,omitempty"`
+
+	// Location is set for location and live location messages.
+	Location *LocationInfo `json:"location,omitempty"`
+	Poll     *PollInfo     `json:"poll,omitempty"`
+	// Transcript is set on incoming voice notes once they have been
+	// transcribed, if transcription is configured.
+	Transcript string `json:"transcript,omitempty"`
 }
 
 type QRResponse struct {
@@ -51,12 +240,46 @@ type QRResponse struct {
 }
 
 type AuthStatusResponse struct {
-	IsAuthenticated bool   `json:"is_authenticated"`
-	Phone          string `json:"phone,omitempty"`
+	IsAuthenticated  bool          `json:"is_authenticated"`
+	Phone            string        `json:"phone,omitempty"`
+	ConnectionStatus string        `json:"connection_status"`
+	Pairing          *PairingState `json:"pairing,omitempty"`
+	// Reconnect is set while the reconnector is retrying after an
+	// unexpected disconnect.
+	Reconnect *ReconnectState `json:"reconnect,omitempty"`
+	// LastSeen is when a message was last sent or received, or the client
+	// last connected.
+	LastSeen *time.Time `json:"last_seen,omitempty"`
+}
+
+type AccountResponse struct {
+	JID            string `json:"jid"`
+	LID            string `json:"lid,omitempty"`
+	Phone          string `json:"phone"`
+	Device         uint16 `json:"device"`
+	PushName       string `json:"push_name,omitempty"`
+	IsBusiness     bool   `json:"is_business"`
+	BusinessName   string `json:"business_name,omitempty"`
+	Platform       string `json:"platform,omitempty"`
+	RegistrationID uint32 `json:"registration_id"`
+	Connected      bool   `json:"connected"`
 }
 
 type MessagesResponse struct {
+	Messages   []MessageInfo `json:"messages"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+type ThreadResponse struct {
 	Messages []MessageInfo `json:"messages"`
+	// MissingIDs lists quoted messages that are referenced by the thread but
+	// aren't stored locally, so clients can render a gap.
+	MissingIDs []string `json:"missing_ids"`
+	Truncated  bool     `json:"truncated"`
+}
+
+type DeleteMessagesResponse struct {
+	Deleted int `json:"deleted"`
 }
 
 type ReadStatusRequest struct {
@@ -73,86 +296,470 @@ type PairCodeResponse struct {
 	PairCode string `json:"pair_code"`
 }
 
+type ReceiptInfo struct {
+	Chat       string    `json:"chat"`
+	Sender     string    `json:"sender"`
+	MessageIDs []string  `json:"message_ids"`
+	Type       string    `json:"type"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+type PresenceInfo struct {
+	Chat      string    `json:"chat"`
+	Sender    string    `json:"sender"`
+	State     string    `json:"state"`
+	Media     string    `json:"media"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+type PresenceRequest struct {
+	ChatID string `json:"chat_id"`
+	State  string `json:"state"`
+	Media  string `json:"media"`
+}
+
+const (
+	CallRinging  = "ringing"
+	CallRejected = "rejected"
+	CallAccepted = "accepted"
+	CallMissed   = "missed"
+)
+
+type CallInfo struct {
+	ID        string    `json:"id"`
+	From      string    `json:"from"`
+	Timestamp time.Time `json:"timestamp"`
+	IsVideo   bool      `json:"is_video"`
+	Outcome   string    `json:"outcome"`
+	// EndReason is the reason WhatsApp gave when the call ended.
+	EndReason string `json:"end_reason,omitempty"`
+}
+
+type CallsResponse struct {
+	Calls []CallInfo `json:"calls"`
+}
+
+type CallSettingsRequest struct {
+	RejectCalls bool `json:"reject_calls"`
+}
+
+type ContactName struct {
+	FullName     string `json:"full_name,omitempty"`
+	PushName     string `json:"push_name,omitempty"`
+	BusinessName string `json:"business_name,omitempty"`
+}
+
+type ChatNameRequest struct {
+	Name string `json:"name"`
+}
+
+type GroupParticipant struct {
+	JID          string `json:"jid"`
+	Name         string `json:"name,omitempty"`
+	IsAdmin      bool   `json:"is_admin"`
+	IsSuperAdmin bool   `json:"is_super_admin"`
+}
+
+type GroupInfoResponse struct {
+	JID          string             `json:"jid"`
+	Name         string             `json:"name"`
+	Topic        string             `json:"topic,omitempty"`
+	Owner        string             `json:"owner,omitempty"`
+	Participants []GroupParticipant `json:"participants"`
+}
+
+type GroupsResponse struct {
+	Groups []GroupInfoResponse `json:"groups"`
+}
+
+type ClockSkewInfo struct {
+	Skew       time.Duration `json:"-"`
+	SkewMillis int64         `json:"skew_ms"`
+	ServerTime time.Time     `json:"server_time"`
+	LocalTime  time.Time     `json:"local_time"`
+	Exceeded   bool          `json:"exceeded"`
+}
+
+type DiagnosticsResponse struct {
+	Connected          bool              `json:"connected"`
+	ClockSkew          *ClockSkewInfo    `json:"clock_skew,omitempty"`
+	ClockSkewThreshold int64             `json:"clock_skew_threshold_ms"`
+	Quality            ConnectionQuality `json:"quality"`
+}
+
 func main() {
-	dbLog := waLog.Stdout("Database", "INFO", true)
-	container, err := sqlstore.New(context.Background(), "sqlite3", "file:whatsapp.db?_foreign_keys=on", dbLog)
+	logger, err := newLogger()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err := run(logger); err != nil {
+		logger.Error("Fatal error", "error", err)
+		os.Exit(1)
+	}
+}
+
+func run(logger *slog.Logger) error {
+	serverCfg, err := loadServerConfig()
+	if err != nil {
+		return err
+	}
+	archive, err := newArchiver()
+	if err != nil {
+		return err
+	}
+
+	dbLog := newModuleLogger(logger, "Database")
+	db, err := sql.Open("sqlite3", "file:whatsapp.db?_foreign_keys=on")
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	db.SetMaxOpenConns(serverCfg.DBMaxOpenConns)
+	db.SetMaxIdleConns(serverCfg.DBMaxIdleConns)
+	db.SetConnMaxLifetime(serverCfg.DBConnLifetime)
+
+	container := sqlstore.NewWithDB(db, "sqlite3", dbLog)
+	if err := container.Upgrade(context.Background()); err != nil {
+		return fmt.Errorf("failed to upgrade database: %w", err)
+	}
+
+	settings, err := newSettingsStore(db)
+	if err != nil {
+		return fmt.Errorf("failed to set up settings: %w", err)
+	}
+	mediaCache, err := newMediaCache(db)
+	if err != nil {
+		return fmt.Errorf("failed to set up media cache: %w", err)
+	}
+	thumbnails, err := newThumbnailStore(db)
+	if err != nil {
+		return fmt.Errorf("failed to set up thumbnail store: %w", err)
+	}
+	polls, err := newPollStore(db)
+	if err != nil {
+		return fmt.Errorf("failed to set up poll store: %w", err)
+	}
+	templates, err := newTemplateStore(db)
+	if err != nil {
+		return fmt.Errorf("failed to set up template store: %w", err)
+	}
+	outbox, err := newOutbox(db)
+	if err != nil {
+		return fmt.Errorf("failed to set up outbox: %w", err)
+	}
+	uploads, err := newUploads(serverCfg.MaxUploadSize)
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("failed to set up uploads: %w", err)
+	}
+	defer uploads.close()
+
+	cfg := Config{
+		ConnectionDebounce: defaultConnectionDebounce.String(),
+		QRTimeout:          defaultQRTimeout.String(),
+		ReconnectPolicy:    defaultReconnectPolicy,
+		SendRate:           defaultSendRate,
+		SendBurst:          defaultSendBurst,
+		VoiceTranscoding:   defaultVoiceTranscoding,
+		BroadcastPacing:    defaultBroadcastPacing,
+		AutoReply:          defaultAutoReply,
+		MediaLimits:        defaultMediaLimits,
+	}
+	if raw := os.Getenv("CONNECTION_DEBOUNCE"); raw != "" {
+		cfg.ConnectionDebounce = raw
+	}
+	if _, err := settings.load(configKey, &cfg); err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	if err := cfg.validate(); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	statusDebounce, _ := time.ParseDuration(cfg.ConnectionDebounce)
+	qrTimeout, _ := time.ParseDuration(cfg.QRTimeout)
+
+	var webhooks []Webhook
+	if _, err := settings.load(webhooksKey, &webhooks); err != nil {
+		return fmt.Errorf("failed to load webhooks: %w", err)
+	}
+
+	var autoReplyVoice *voiceNote
+	if _, err := settings.load(autoReplyVoiceKey, &autoReplyVoice); err != nil {
+		return fmt.Errorf("failed to load auto-reply voice note: %w", err)
+	}
+
+	var lastSeen time.Time
+	if _, err := settings.load(lastSeenKey, &lastSeen); err != nil {
+		return fmt.Errorf("failed to load last seen time: %w", err)
 	}
 
 	deviceStore, err := container.GetFirstDevice(context.Background())
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("failed to load device: %w", err)
 	}
 
-	clientLog := waLog.Stdout("Client", "INFO", true)
+	clientLog := newModuleLogger(logger, "Client")
 	client := whatsmeow.NewClient(deviceStore, clientLog)
+	// Reconnects are handled by our own reconnector so the policy is
+	// configurable.
+	client.EnableAutoReconnect = false
 
 	api := &WhatsAppAPI{
-		client:    client,
-		log:       clientLog,
-		messages:  make([]MessageInfo, 0),
-		currentQR: "",
+		client:     client,
+		log:        clientLog,
+		logger:     logger,
+		messages:   make([]MessageInfo, 0),
+		currentQR:  "",
+		qrReady:    make(chan struct{}),
+		qrTimeout:  qrTimeout,
+		presences:  make(map[string]PresenceInfo),
+		calls:      make([]CallInfo, 0),
+		chatNames:  make(map[string]string),
+		chatStates: make(map[string]ChatState),
+		groupNames: make(map[string]string),
+		contacts:   make(map[string]ContactName),
+		liveShares: make(map[string]*liveShare),
+		events:     NewEventBus(),
+		webhooks:   newWebhookDispatcher(newModuleLogger(logger, "Webhooks"), webhooks),
+		outbox:     outbox,
+		quality:    newQualityTracker(),
+		settings:   settings,
+		mediaCache: mediaCache,
+		thumbnails: thumbnails,
+		archive:    archive,
+		uploads:    uploads,
+		polls:      polls,
+		templates:  templates,
+		reconnect:  newReconnector(cfg.ReconnectPolicy),
+		sendLimit:  newRateLimiter(cfg.SendRate, cfg.SendBurst),
+		metrics:    newAPIMetrics(),
+		activity:   &activityTracker{lastSeen: lastSeen},
+
+		voiceTranscoding: cfg.VoiceTranscoding,
+		transcription:    cfg.Transcription,
+		autoReply:        cfg.AutoReply,
+		autoReplyVoice:   autoReplyVoice,
+		autoReplied:      make(map[string]time.Time),
+		mediaLimits:      cfg.MediaLimits,
+		stripMetadata:    cfg.StripImageMetadata,
+		mediaRetries:     make(map[string][]chan *events.MediaRetry),
+		broadcastPacing:  cfg.BroadcastPacing,
+		broadcasts:       newBroadcasts(),
+		connectionStatus: "disconnected",
+		statusDebounce:   statusDebounce,
 	}
+	api.rejectCalls.Store(cfg.RejectCalls)
 
 	client.AddEventHandler(api.eventHandler)
 
 	router := mux.NewRouter()
+	apiKeys := splitList(os.Getenv("API_KEYS"))
+	if len(apiKeys) == 0 {
+		logger.Warn("API_KEYS is not set, the API is accessible without authentication")
+	}
+	router.Use(apiKeyMiddleware(apiKeys))
 	
 	// Authentication endpoints
 	router.HandleFunc("/qr", api.getQR).Methods("GET")
+	router.HandleFunc("/qr.png", api.getQRImage).Methods("GET")
 	router.HandleFunc("/auth/status", api.getAuthStatus).Methods("GET")
 	router.HandleFunc("/auth/logout", api.logout).Methods("POST")
 	router.HandleFunc("/auth/pair-phone", api.pairPhone).Methods("POST")
+	router.HandleFunc("/account", api.getAccount).Methods("GET")
 	
 	// Message endpoints
 	router.HandleFunc("/messages", api.getMessages).Methods("GET")
+	router.HandleFunc("/messages", api.deleteMessages).Methods("DELETE")
 	router.HandleFunc("/messages/{chatId}", api.getChatMessages).Methods("GET")
 	router.HandleFunc("/messages/read-status", api.updateReadStatus).Methods("POST")
+	router.HandleFunc("/messages/search", api.searchMessages).Methods("POST")
+	router.HandleFunc("/messages/send", api.sendTextMessage).Methods("POST")
+	router.HandleFunc("/messages/send-voice", api.sendVoiceMessage).Methods("POST")
+	router.HandleFunc("/messages/send-image", api.sendImageMessage).Methods("POST")
+	router.HandleFunc("/messages/send-live-location", api.startLiveLocation).Methods("POST")
+	router.HandleFunc("/messages/send-poll", api.sendPoll).Methods("POST")
+	router.HandleFunc("/messages/send-template", api.sendTemplate).Methods("POST")
+	router.HandleFunc("/messages/by-id/{messageId}", api.getMessage).Methods("GET")
+	router.HandleFunc("/messages/{messageId}/thread", api.getMessageThread).Methods("GET")
+	router.HandleFunc("/messages/{messageId}/status", api.getMessageStatus).Methods("GET")
+	router.HandleFunc("/messages/broadcast", api.broadcastMessage).Methods("POST")
+	router.HandleFunc("/broadcasts/{broadcastId}", api.getBroadcast).Methods("GET")
+	router.HandleFunc("/broadcasts/{broadcastId}", api.cancelBroadcast).Methods("DELETE")
+	router.HandleFunc("/messages/{messageId}/edit", api.editMessage).Methods("POST")
+	router.HandleFunc("/messages/{messageId}/react", api.reactToMessage).Methods("POST")
+	router.HandleFunc("/messages/{messageId}/forward", api.forwardMessage).Methods("POST")
+	router.HandleFunc("/messages/{messageId}", api.revokeMessage).Methods("DELETE")
+	router.HandleFunc("/media/{messageId}", api.getMedia).Methods("GET")
+	router.HandleFunc("/media/{messageId}/audio", api.getAudio).Methods("GET")
+	router.HandleFunc("/media/{messageId}/thumbnail", api.getThumbnail).Methods("GET")
+	router.HandleFunc("/polls/{messageId}/results", api.getPollResults).Methods("GET")
+	router.HandleFunc("/live-locations", api.getLiveLocations).Methods("GET")
+	router.HandleFunc("/live-locations/{shareId}", api.updateLiveLocation).Methods("PUT")
+	router.HandleFunc("/live-locations/{shareId}", api.stopLiveLocation).Methods("DELETE")
+
+	// Status endpoints
+	router.HandleFunc("/status", api.postStatus).Methods("POST")
+	router.HandleFunc("/status", api.getStatuses).Methods("GET")
+
+	// Presence endpoints
+	router.HandleFunc("/presence", api.sendPresence).Methods("POST")
+	router.HandleFunc("/presence/{chatId}", api.getPresence).Methods("GET")
+
+	// Chat endpoints
+	router.HandleFunc("/contacts", api.getContacts).Methods("GET")
+	router.HandleFunc("/contacts/sync", api.syncContacts).Methods("POST")
+	router.HandleFunc("/check-number", api.checkNumbers).Methods("GET")
+	router.HandleFunc("/chats", api.getChats).Methods("GET")
+	router.HandleFunc("/chats/{chatId}/name", api.setChatName).Methods("PUT")
+	router.HandleFunc("/chats/{chatId}/mark-read", api.markChatRead).Methods("POST")
+
+	// Group endpoints
+	router.HandleFunc("/groups", api.getGroups).Methods("GET")
+	router.HandleFunc("/groups/{groupId}", api.getGroupInfo).Methods("GET")
+
+	// Call endpoints
+	router.HandleFunc("/calls", api.getCalls).Methods("GET")
+	router.HandleFunc("/calls/settings", api.updateCallSettings).Methods("POST")
+
+	// Auto-reply endpoints
+	router.HandleFunc("/auto-reply/voice", api.getAutoReplyVoice).Methods("GET")
+	router.HandleFunc("/auto-reply/voice", api.setAutoReplyVoice).Methods("PUT")
+	router.HandleFunc("/auto-reply/voice", api.deleteAutoReplyVoice).Methods("DELETE")
+
+	// Outbox endpoints
+	router.HandleFunc("/outbox", api.getOutbox).Methods("GET")
+	router.HandleFunc("/outbox/{entryId}/retry", api.retryOutbox).Methods("POST")
+	router.HandleFunc("/outbox/{entryId}", api.cancelOutbox).Methods("DELETE")
+
+	// Event endpoints
+	router.HandleFunc("/events", api.streamEvents).Methods("GET")
+	router.HandleFunc("/ws", api.serveWebSocket).Methods("GET")
+
+	// Template endpoints
+	router.HandleFunc("/templates", api.listTemplates).Methods("GET")
+	router.HandleFunc("/templates", api.createTemplate).Methods("POST")
+	router.HandleFunc("/templates/{templateId}", api.getTemplate).Methods("GET")
+	router.HandleFunc("/templates/{templateId}", api.updateTemplate).Methods("PUT")
+	router.HandleFunc("/templates/{templateId}", api.deleteTemplate).Methods("DELETE")
+
+	// Chunked upload endpoints
+	router.HandleFunc("/uploads", api.createUpload).Methods("POST")
+	router.HandleFunc("/uploads/{uploadId}", api.getUpload).Methods("GET")
+	router.HandleFunc("/uploads/{uploadId}", api.appendUpload).Methods("PATCH")
+	router.HandleFunc("/uploads/{uploadId}", api.deleteUpload).Methods("DELETE")
+
+	// Webhook endpoints
+	router.HandleFunc("/webhooks", api.listWebhooks).Methods("GET")
+	router.HandleFunc("/webhooks", api.addWebhook).Methods("POST")
+	router.HandleFunc("/webhooks/{webhookId}", api.deleteWebhook).Methods("DELETE")
+
+	// Connection endpoints
+	router.HandleFunc("/reconnect-policy", api.getReconnectPolicy).Methods("GET")
+	router.HandleFunc("/reconnect-policy", api.updateReconnectPolicy).Methods("PUT")
+
+	// Config endpoints
+	router.HandleFunc("/config", api.getConfig).Methods("GET")
+	router.HandleFunc("/config", api.patchConfig).Methods("PATCH")
+
+	// Diagnostics endpoints
+	router.HandleFunc("/diagnostics", api.getDiagnostics).Methods("GET")
+	router.HandleFunc("/metrics", api.getMetrics).Methods("GET")
+	router.HandleFunc("/send-readiness", api.getSendReadiness).Methods("GET")
 	
+	// Probes are served outside the router so they don't need an API key.
+	root := http.NewServeMux()
+	root.HandleFunc("GET /healthz", api.getLiveness)
+	root.HandleFunc("GET /readyz", api.getReadiness)
+	root.Handle("/", router)
+
+	// Outermost first: every request is logged, including the 500 a
+	// recovered panic turns into.
+	var handler http.Handler = root
+	handler = bodyLimitMiddleware(serverCfg.MaxBodySize, serverCfg.MaxUploadSize, handler)
+	handler = recoverMiddleware(logger, handler)
+	handler = requestLogMiddleware(logger, handler)
+
 	server := &http.Server{
-		Addr:    ":8080",
-		Handler: router,
+		Addr:              serverCfg.ListenAddr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       serverCfg.ReadTimeout,
+		WriteTimeout:      serverCfg.WriteTimeout,
+		IdleTimeout:       2 * time.Minute,
 	}
 
+	serverErr := make(chan error, 1)
 	go func() {
-		log.Println("Starting server on :8080")
+		logger.Info("Starting server", "addr", server.Addr)
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Server failed to start: %v", err)
+			serverErr <- err
 		}
 	}()
 
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 
+	stopFlush := make(chan struct{})
+	go api.flushLastSeen(stopFlush)
+	stopWorkers := make(chan struct{})
+	var workers sync.WaitGroup
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		api.runOutbox(stopWorkers)
+	}()
+	if api.archive != nil {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			api.runArchiver(stopWorkers)
+		}()
+	}
+
+	api.restorePairing()
+
 	if client.Store.ID == nil {
-		qrChan, _ := client.GetQRChannel(context.Background())
-		err = client.Connect()
-		if err != nil {
-			panic(err)
+		if api.pairingSnapshot() == nil {
+			api.startPairing("qr", "")
 		}
-		for evt := range qrChan {
-			if evt.Event == "code" {
-				api.log.Infof("QR code: %s", evt.Code)
-			} else {
-				api.log.Infof("QR channel result: %s", evt.Event)
-			}
-		}
-	} else {
-		err = client.Connect()
-		if err != nil {
-			panic(err)
+		if err := api.connectForQR(); err != nil {
+			return fmt.Errorf("failed to connect for pairing: %w", err)
 		}
+	} else if err := api.connect(context.Background()); err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
 	}
 
-	<-c
-	log.Println("Shutting down server...")
-	
+	select {
+	case <-c:
+	case err := <-serverErr:
+		client.Disconnect()
+		return fmt.Errorf("server failed: %w", err)
+	}
+	logger.Info("Shutting down server")
+	api.stopReconnect()
+
+	// Stop accepting requests and let in-flight ones finish before the
+	// client and database they use go away. Event streams never finish on
+	// their own, so they are ended once shutdown starts.
+	server.RegisterOnShutdown(api.events.Close)
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	
+	if err := server.Shutdown(ctx); err != nil {
+		logger.Warn("Timed out waiting for in-flight requests", "error", err)
+	}
+	// Queued messages that didn't go out yet stay in the outbox for the
+	// next start.
+	close(stopWorkers)
+	workers.Wait()
+
+	if client.IsConnected() {
+		logger.Info("Disconnecting from WhatsApp")
+	}
 	client.Disconnect()
-	server.Shutdown(ctx)
+	close(stopFlush)
+	api.saveLastSeen()
+	if err := db.Close(); err != nil {
+		logger.Error("Failed to close database", "error", err)
+	}
+	return nil
 }
 
 func (api *WhatsAppAPI) eventHandler(evt interface{}) {
@@ -161,112 +768,785 @@ func (api *WhatsAppAPI) eventHandler(evt interface{}) {
 		api.handleMessage(v)
 	case *events.Receipt:
 		api.handleReceipt(v)
+	case *events.MediaRetry:
+		api.handleMediaRetry(v)
+	case *events.ChatPresence:
+		api.handleChatPresence(v)
+	case *events.CallOffer:
+		api.handleCallOffer(v)
+	case *events.CallAccept:
+		api.handleCallAccept(v)
+	case *events.CallTerminate:
+		api.handleCallTerminate(v)
 	case *events.QR:
 		if len(v.Codes) > 0 {
-			api.currentQR = v.Codes[0]
-			api.log.Infof("QR code updated: %s", api.currentQR)
+			api.setQR(v.Codes[0])
+			api.log.Infof("QR code updated: %s", v.Codes[0])
 		}
 	case *events.PairSuccess:
 		api.log.Infof("Pairing successful! Device: %s, Business: %s, Platform: %s", 
 			v.ID.String(), v.BusinessName, v.Platform)
+		api.finishPairing()
+		api.metrics.pairings.inc("success")
 	case *events.PairError:
 		api.log.Errorf("Pairing failed! Device: %s, Error: %v", v.ID.String(), v.Error)
+		api.metrics.pairings.inc("failure")
 	case *events.Connected:
 		api.log.Infof("WhatsApp client connected successfully!")
+		api.liveEvents.Store(false)
+		go api.measureClockSkew()
+		api.setConnectionStatus("connected")
+		api.activity.touch(time.Now())
+		api.stopReconnect()
+		api.loadContacts()
+		api.outbox.notify()
+	case *events.HistorySync:
+		api.handleHistorySync(v)
+	case *events.GroupInfo:
+		if v.Name != nil {
+			api.rememberGroupName(v.JID.String(), v.Name.Name)
+		}
+	case *events.Archive:
+		api.handleArchive(v)
+	case *events.Pin:
+		api.handlePin(v)
+	case *events.Contact:
+		api.handleContact(v)
+	case *events.PushName:
+		api.updatePushName(v.JID, v.NewPushName)
+	case *events.Disconnected:
+		api.setConnectionStatus("disconnected")
+		api.quality.trackDisconnect(time.Now())
+		// A device that was unlinked has nothing to reconnect to.
+		if api.client.Store.ID != nil {
+			api.startReconnect()
+		}
+	case *events.LoggedOut:
+		api.handleLoggedOut(v)
+	case *events.KeepAliveTimeout:
+		api.quality.trackKeepAlive(v.ErrorCount)
+	case *events.KeepAliveRestored:
+		api.quality.trackKeepAlive(0)
+	case *events.OfflineSyncCompleted:
+		api.liveEvents.Store(true)
+	}
+}
+
+// setConnectionStatus records a connection state transition. The status is
+// only committed once it has been stable for statusDebounce, so a burst of
+// connect/disconnect events collapses into a single change.
+func (api *WhatsAppAPI) setConnectionStatus(status string) {
+	api.statusMu.Lock()
+	defer api.statusMu.Unlock()
+
+	api.pendingStatus = status
+	if api.statusTimer != nil {
+		api.statusTimer.Stop()
+	}
+	api.statusTimer = time.AfterFunc(api.statusDebounce, api.commitConnectionStatus)
+}
+
+func (api *WhatsAppAPI) commitConnectionStatus() {
+	api.statusMu.Lock()
+	defer api.statusMu.Unlock()
+
+	if api.pendingStatus == api.connectionStatus {
+		return
+	}
+	api.log.Infof("Connection status changed: %s -> %s", api.connectionStatus, api.pendingStatus)
+	api.connectionStatus = api.pendingStatus
+	api.emit("connection", map[string]string{"status": api.connectionStatus})
+}
+
+func (api *WhatsAppAPI) getConnectionStatus() string {
+	api.statusMu.Lock()
+	defer api.statusMu.Unlock()
+	return api.connectionStatus
+}
+
+// checkClockSkew compares a server-provided timestamp with the local clock.
+// Only events delivered live are considered, since messages from the offline
+// backlog are legitimately older than the time they arrive.
+func (api *WhatsAppAPI) checkClockSkew(serverTime time.Time) {
+	if !api.liveEvents.Load() || serverTime.IsZero() {
+		return
+	}
+	api.recordClockSkew(serverTime)
+}
+
+// measureClockSkew takes a measurement right after connecting, so that the
+// skew is known even if no live message arrives. The server time comes from
+// the Date header of WhatsApp's web server, which has a resolution of one
+// second.
+func (api *WhatsAppAPI) measureClockSkew() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, clockCheckURL, nil)
+	if err != nil {
+		api.log.Warnf("Failed to check the clock against WhatsApp servers: %v", err)
+		return
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		api.log.Warnf("Failed to check the clock against WhatsApp servers: %v", err)
+		return
+	}
+	resp.Body.Close()
+
+	serverTime, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		api.log.Warnf("WhatsApp servers sent no usable Date header: %v", err)
+		return
+	}
+	info := api.recordClockSkew(serverTime)
+	api.log.Infof("Clock skew against WhatsApp servers on connect: %s", info.Skew.Round(time.Second))
+}
+
+// recordClockSkew stores the skew of the local clock against serverTime and
+// warns when it first exceeds clockSkewThreshold.
+func (api *WhatsAppAPI) recordClockSkew(serverTime time.Time) *ClockSkewInfo {
+	now := time.Now()
+	skew := now.Sub(serverTime)
+	info := &ClockSkewInfo{
+		Skew:       skew,
+		SkewMillis: skew.Milliseconds(),
+		ServerTime: serverTime,
+		LocalTime:  now,
+		Exceeded:   skew > clockSkewThreshold || skew < -clockSkewThreshold,
+	}
+
+	if previous := api.clockSkewSnapshot(); info.Exceeded && (previous == nil || !previous.Exceeded) {
+		api.log.Warnf("!!! System clock is off by %s compared to WhatsApp servers. "+
+			"Pairing and connection failures are likely until the clock is fixed !!!", skew.Round(time.Second))
 	}
+	api.clockMu.Lock()
+	api.clockSkew = info
+	api.clockMu.Unlock()
+	return info
+}
+
+// clockSkewSnapshot returns the last clock skew measurement, if any. The
+// info is never modified once stored, so it can be shared.
+func (api *WhatsAppAPI) clockSkewSnapshot() *ClockSkewInfo {
+	api.clockMu.Lock()
+	defer api.clockMu.Unlock()
+	return api.clockSkew
 }
 
 func (api *WhatsAppAPI) handleMessage(evt *events.Message) {
+	api.checkClockSkew(evt.Info.Timestamp)
+	api.activity.touch(time.Now())
+	if !evt.Info.IsFromMe && evt.Info.PushName != "" {
+		api.updatePushName(evt.Info.Sender, evt.Info.PushName)
+	}
+
+	if reaction := evt.Message.GetReactionMessage(); reaction != nil {
+		api.handleReaction(evt, reaction)
+		return
+	}
+	if evt.Message.GetPollUpdateMessage() != nil {
+		api.handlePollVote(evt)
+		return
+	}
+	// REVOKE is the zero value, which GetType also returns for messages
+	// without a protocol message.
+	if protocol := evt.Message.GetProtocolMessage(); protocol != nil {
+		switch protocol.GetType() {
+		case waE2E.ProtocolMessage_MESSAGE_EDIT:
+			api.handleEdit(protocol, evt.Info.Timestamp)
+			return
+		case waE2E.ProtocolMessage_REVOKE:
+			api.handleRevoke(protocol.GetKey().GetID(), evt.Info.Timestamp)
+			return
+		}
+	}
+
+	msg := newMessageInfo(evt)
+	if msg.Content.Poll != nil {
+		api.savePoll(msg)
+	}
+	api.appendMessage(msg)
+	api.metrics.messagesReceived.inc(msg.Content.Type)
+	api.log.Infof("Received message: %s from %s", msg.Content.Text, msg.Source.Sender)
+	api.emit("message", msg)
+	if hasThumbnail(msg) {
+		go api.saveThumbnail(msg)
+	}
+	if msg.Content.Media != nil && msg.Content.Media.PTT && !msg.Source.IsFromMe {
+		go api.transcribe(msg)
+		api.enqueueArchive(msg, nil)
+	}
+	if !msg.Source.IsFromMe {
+		api.scheduleAutoReply(evt.Info.Chat, AutoReplyMessages)
+	}
+}
+
+// newMessageInfo converts a received message into its stored form.
+func newMessageInfo(evt *events.Message) MessageInfo {
 	msg := MessageInfo{
 		ID:        evt.Info.ID,
 		Timestamp: evt.Info.Timestamp,
 		Source: MessageSource{
 			Chat:     evt.Info.Chat.String(),
 			Sender:   evt.Info.Sender.String(),
+			PushName: evt.Info.PushName,
 			IsFromMe: evt.Info.IsFromMe,
 			IsGroup:  evt.Info.IsGroup,
 		},
 		IsRead: false,
 	}
+	if evt.Info.IsFromMe {
+		msg.Status = MessageStatusSent
+	}
 
-	if evt.Message.GetConversation() != "" {
-		msg.Content = MessageContent{
-			Text: evt.Message.GetConversation(),
-			Type: "text",
+	msg.Content = extractMessageContent(evt.Message)
+	if msg.Content.Media != nil {
+		msg.raw = evt.Message
+	}
+	return msg
+}
+
+// setReaction records sender's reaction on a stored message, replacing any
+// earlier one. An empty emoji removes it. It returns the updated message.
+func (api *WhatsAppAPI) setReaction(messageID, sender, emoji string, ts time.Time) (MessageInfo, bool) {
+	return api.updateMessage(messageID, func(msg *MessageInfo) {
+		reactions := make([]Reaction, 0, len(msg.Reactions)+1)
+		for _, existing := range msg.Reactions {
+			if existing.Sender != sender {
+				reactions = append(reactions, existing)
+			}
 		}
-	} else if evt.Message.GetExtendedTextMessage() != nil {
-		msg.Content = MessageContent{
-			Text: evt.Message.GetExtendedTextMessage().GetText(),
-			Type: "text",
+		if emoji != "" {
+			reactions = append(reactions, Reaction{Sender: sender, Emoji: emoji, Timestamp: ts})
 		}
+		msg.Reactions = reactions
+	})
+}
+
+// handleReaction attaches a reaction to the message it targets instead of
+// storing it as a message of its own. An empty emoji removes the sender's
+// reaction.
+func (api *WhatsAppAPI) handleReaction(evt *events.Message, reaction *waE2E.ReactionMessage) {
+	key := reaction.GetKey()
+	sender := evt.Info.Sender.ToNonAD().String()
+	event := ReactionEvent{
+		MessageID: key.GetID(),
+		Chat:      evt.Info.Chat.String(),
+		Sender:    sender,
+		Emoji:     reaction.GetText(),
+		Removed:   reaction.GetText() == "",
+		Timestamp: evt.Info.Timestamp,
+	}
+
+	// The key's FromMe is relative to the reactor, so a reaction to our
+	// message from someone else has FromMe unset. In groups the participant
+	// names the original sender directly.
+	if participant := key.GetParticipant(); participant != "" && api.client.Store.ID != nil {
+		jid, err := types.ParseJID(participant)
+		event.TargetFromMe = err == nil && jid.User == api.client.Store.ID.User
 	} else {
-		msg.Content = MessageContent{
-			Type: "other",
-		}
+		event.TargetFromMe = key.GetFromMe() == evt.Info.IsFromMe
 	}
 
-	api.messages = append(api.messages, msg)
-	api.log.Infof("Received message: %s from %s", msg.Content.Text, msg.Source.Sender)
+	if target, ok := api.setReaction(event.MessageID, sender, event.Emoji, event.Timestamp); ok {
+		event.TargetFromMe = target.Source.IsFromMe
+	}
+
+	if event.TargetFromMe && !evt.Info.IsFromMe {
+		api.log.Infof("%s reacted %q to our message %s", sender, event.Emoji, event.MessageID)
+	}
+	api.emit("reaction", event)
 }
 
-func (api *WhatsAppAPI) handleReceipt(evt *events.Receipt) {
-	if evt.Type == types.ReceiptTypeRead || evt.Type == types.ReceiptTypeReadSelf {
-		for i, msg := range api.messages {
-			if msg.ID == evt.MessageIDs[0] {
-				api.messages[i].IsRead = true
-				break
-			}
+// extractMessageContent converts a message proto into the stored content.
+func extractMessageContent(m *waE2E.Message) MessageContent {
+	if m.GetConversation() != "" {
+		return MessageContent{
+			Text: m.GetConversation(),
+			Type: "text",
+		}
+	} else if m.GetExtendedTextMessage() != nil {
+		return MessageContent{
+			Text:     m.GetExtendedTextMessage().GetText(),
+			Type:     "text",
+			QuotedID: m.GetExtendedTextMessage().GetContextInfo().GetStanzaID(),
+			Mentions: m.GetExtendedTextMessage().GetContextInfo().GetMentionedJID(),
 		}
+	} else if content, ok := extractMediaContent(m); ok {
+		return content
+	} else if content, ok := extractLocationContent(m); ok {
+		return content
+	} else if content, ok := extractPollContent(m); ok {
+		return content
+	}
+	return MessageContent{
+		Type: "other",
 	}
 }
 
-func (api *WhatsAppAPI) getQR(w http.ResponseWriter, r *http.Request) {
-	if api.client.Store.ID != nil {
-		http.Error(w, "Already authenticated", http.StatusBadRequest)
+// handleEdit applies an edit to a stored message. For media only the caption
+// can change, so the original media reference is kept.
+func (api *WhatsAppAPI) handleEdit(protocol *waE2E.ProtocolMessage, editedAt time.Time) {
+	id := protocol.GetKey().GetID()
+	edited := extractMessageContent(protocol.GetEditedMessage())
+	msg, ok := api.updateMessage(id, func(msg *MessageInfo) {
+		msg.Content.Text = edited.Text
+		msg.EditedAt = &editedAt
+		if msg.raw != nil {
+			msg.raw = withCaption(msg.raw, edited.Text)
+		}
+	})
+	if !ok {
+		api.log.Debugf("Ignoring edit of unknown message %s", id)
 		return
 	}
+	api.emit("message", msg)
+}
 
-	if api.currentQR == "" {
-		http.Error(w, "QR code not available", http.StatusNotFound)
+// handleRevoke marks a message as deleted for everyone and drops its content.
+func (api *WhatsAppAPI) handleRevoke(id string, revokedAt time.Time) {
+	msg, ok := api.updateMessage(id, func(msg *MessageInfo) {
+		msg.Content = MessageContent{Type: msg.Content.Type}
+		msg.RevokedAt = &revokedAt
+		msg.raw = nil
+	})
+	if !ok {
+		api.log.Debugf("Ignoring revoke of unknown message %s", id)
 		return
 	}
+	api.emit("message", msg)
+}
 
-	response := QRResponse{QR: api.currentQR}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+// withCaption returns a copy of a media message with its caption replaced.
+func withCaption(m *waE2E.Message, caption string) *waE2E.Message {
+	m = proto.Clone(m).(*waE2E.Message)
+	if image := m.GetImageMessage(); image != nil {
+		image.Caption = proto.String(caption)
+	} else if video := m.GetVideoMessage(); video != nil {
+		video.Caption = proto.String(caption)
+	} else if document := m.GetDocumentMessage(); document != nil {
+		document.Caption = proto.String(caption)
+	}
+	return m
 }
 
-func (api *WhatsAppAPI) getAuthStatus(w http.ResponseWriter, r *http.Request) {
-	response := AuthStatusResponse{
-		IsAuthenticated: api.client.Store.ID != nil,
+func (api *WhatsAppAPI) handleReceipt(evt *events.Receipt) {
+	if evt.Type == types.ReceiptTypeDelivered {
+		api.quality.trackDelivered(evt.MessageIDs, evt.Timestamp)
 	}
-	
-	if response.IsAuthenticated && api.client.Store.ID != nil {
-		response.Phone = api.client.Store.ID.User
+	api.emit("receipt", ReceiptInfo{
+		Chat:       evt.Chat.String(),
+		Sender:     evt.Sender.String(),
+		MessageIDs: evt.MessageIDs,
+		Type:       string(evt.Type),
+		Timestamp:  evt.Timestamp,
+	})
+
+	// Receipts from our own other devices don't say anything about the
+	// recipient.
+	if status := receiptStatus(evt.Type); status != "" && !evt.IsFromMe {
+		api.advanceStatus(evt.MessageIDs, status, evt.Timestamp)
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	if evt.Type == types.ReceiptTypeRead || evt.Type == types.ReceiptTypeReadSelf {
+		ids := make(map[string]bool, len(evt.MessageIDs))
+		for _, id := range evt.MessageIDs {
+			ids[id] = true
+		}
+		api.updateMessages(func(msg *MessageInfo) bool {
+			if !ids[msg.ID] {
+				return false
+			}
+			msg.IsRead = true
+			return true
+		})
+	}
 }
 
-func (api *WhatsAppAPI) logout(w http.ResponseWriter, r *http.Request) {
-	if api.client.Store.ID == nil {
-		http.Error(w, "Not authenticated", http.StatusBadRequest)
-		return
+// loadContacts fills the contact cache from the device store. Later changes
+// arrive incrementally through contact and push name events.
+func (api *WhatsAppAPI) loadContacts() error {
+	contacts, err := api.client.Store.Contacts.GetAllContacts(context.Background())
+	if err != nil {
+		api.log.Errorf("Failed to load contacts: %v", err)
+		return err
 	}
 
-	err := api.client.Logout(context.Background())
-	if err != nil {
-		http.Error(w, "Failed to logout", http.StatusInternalServerError)
-		return
+	api.contactsMu.Lock()
+	defer api.contactsMu.Unlock()
+	for jid, contact := range contacts {
+		name := ContactName{FullName: contact.FullName, PushName: contact.PushName, BusinessName: contact.BusinessName}
+		if name.FullName == "" {
+			name.FullName = contact.FirstName
+		}
+		if name.FullName == "" {
+			name.FullName = contact.BusinessName
+		}
+		api.contacts[jid.ToNonAD().String()] = name
 	}
+	api.log.Infof("Loaded %d contacts", len(contacts))
+	return nil
+}
 
-	w.WriteHeader(http.StatusOK)
+func (api *WhatsAppAPI) handleContact(evt *events.Contact) {
+	key := evt.JID.ToNonAD().String()
+	api.contactsMu.Lock()
+	defer api.contactsMu.Unlock()
+	contact := api.contacts[key]
+	contact.FullName = evt.Action.GetFullName()
+	if contact.FullName == "" {
+		contact.FullName = evt.Action.GetFirstName()
+	}
+	api.contacts[key] = contact
 }
 
-func (api *WhatsAppAPI) pairPhone(w http.ResponseWriter, r *http.Request) {
-	if api.client.Store.ID != nil {
-		http.Error(w, "Already authenticated", http.StatusBadRequest)
+func (api *WhatsAppAPI) updatePushName(jid types.JID, pushName string) {
+	key := jid.ToNonAD().String()
+	api.contactsMu.Lock()
+	defer api.contactsMu.Unlock()
+	contact := api.contacts[key]
+	if contact.PushName == pushName {
+		return
+	}
+	contact.PushName = pushName
+	api.contacts[key] = contact
+}
+
+func (api *WhatsAppAPI) handleChatPresence(evt *events.ChatPresence) {
+	presence := PresenceInfo{
+		Chat:      evt.Chat.String(),
+		Sender:    evt.Sender.String(),
+		State:     string(evt.State),
+		Media:     string(evt.Media),
+		Timestamp: time.Now(),
+	}
+	// WhatsApp leaves the media empty for plain typing.
+	if presence.Media == "" {
+		presence.Media = string(types.ChatPresenceMediaText)
+	}
+
+	api.presencesMu.Lock()
+	api.presences[presence.Chat] = presence
+	api.presencesMu.Unlock()
+	api.emit("presence", presence)
+}
+
+func (api *WhatsAppAPI) handleCallOffer(evt *events.CallOffer) {
+	call := CallInfo{
+		ID:        evt.CallID,
+		From:      evt.From.String(),
+		Timestamp: evt.Timestamp,
+		IsVideo:   evt.Data != nil && evt.Data.GetChildByTag("video").Tag == "video",
+		Outcome:   CallRinging,
+	}
+
+	if api.rejectCalls.Load() {
+		err := api.client.RejectCall(evt.From, evt.CallID)
+		if err != nil {
+			api.log.Errorf("Failed to reject call %s from %s: %v", evt.CallID, call.From, err)
+		} else {
+			call.Outcome = CallRejected
+		}
+	}
+
+	api.callsMu.Lock()
+	api.calls = append(api.calls, call)
+	api.callsMu.Unlock()
+	api.emit("call", call)
+	api.log.Infof("Incoming call %s from %s (video: %t, outcome: %s)", call.ID, call.From, call.IsVideo, call.Outcome)
+}
+
+func (api *WhatsAppAPI) handleCallTerminate(evt *events.CallTerminate) {
+	call, ok := api.updateCall(evt.CallID, func(call *CallInfo) {
+		// Calls we rejected or accepted keep that outcome, everything else
+		// that ends without being answered was missed.
+		if call.Outcome == CallRinging {
+			call.Outcome = CallMissed
+		}
+		call.EndReason = evt.Reason
+	})
+	if !ok {
+		return
+	}
+
+	if call.Outcome != CallAccepted {
+		api.scheduleAutoReply(evt.From, AutoReplyMissedCalls)
+	}
+	api.emit("call", call)
+}
+
+// handleCallAccept records that a call was answered, on this device or on
+// another one of the account.
+func (api *WhatsAppAPI) handleCallAccept(evt *events.CallAccept) {
+	call, ok := api.updateCall(evt.CallID, func(call *CallInfo) {
+		if call.Outcome == CallRinging {
+			call.Outcome = CallAccepted
+		}
+	})
+	if ok {
+		api.emit("call", call)
+	}
+}
+
+// updateCall applies update to the logged call with the given ID and returns
+// its new state, or false if the call isn't logged.
+func (api *WhatsAppAPI) updateCall(id string, update func(*CallInfo)) (CallInfo, bool) {
+	api.callsMu.Lock()
+	defer api.callsMu.Unlock()
+	for i := range api.calls {
+		if api.calls[i].ID == id {
+			update(&api.calls[i])
+			return api.calls[i], true
+		}
+	}
+	return CallInfo{}, false
+}
+
+func (api *WhatsAppAPI) getQR(w http.ResponseWriter, r *http.Request) {
+	if api.client.Store.ID != nil {
+		http.Error(w, "Already authenticated", http.StatusBadRequest)
+		return
+	}
+
+	code, _, ok := api.waitForQR(r.Context())
+	if !ok {
+		http.Error(w, "Timed out waiting for QR code", http.StatusGatewayTimeout)
+		return
+	}
+
+	response := QRResponse{QR: code}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (api *WhatsAppAPI) setQR(code string) {
+	api.qrMu.Lock()
+	defer api.qrMu.Unlock()
+
+	if code == api.currentQR {
+		return
+	}
+	api.currentQR = code
+	api.qrUpdated = time.Now()
+	if code != "" {
+		api.metrics.qrCodes.inc("")
+	}
+
+	select {
+	case <-api.qrReady:
+		if code == "" {
+			api.qrReady = make(chan struct{})
+		}
+	default:
+		if code != "" {
+			close(api.qrReady)
+		}
+	}
+}
+
+// consumeQR is the only reader of the QR channel. GetQRChannel can only be
+// called once per connection, so handlers read the cached code instead.
+func (api *WhatsAppAPI) consumeQR(qrChan <-chan whatsmeow.QRChannelItem) {
+	for evt := range qrChan {
+		switch evt.Event {
+		case whatsmeow.QRChannelEventCode:
+			// The channel rotates through the codes, so it is the source
+			// of truth for which one is currently scannable.
+			api.setQR(evt.Code)
+			api.log.Infof("QR code: %s", evt.Code)
+		case whatsmeow.QRChannelSuccess.Event, whatsmeow.QRChannelTimeout.Event:
+			// The last code is no longer scannable either way.
+			api.setQR("")
+			api.log.Infof("QR channel result: %s", evt.Event)
+		default:
+			api.log.Infof("QR channel result: %s", evt.Event)
+		}
+	}
+}
+
+// waitForQR returns the current QR code and when it was issued, waiting up
+// to the configured QR timeout for one to arrive. Only the background QR
+// channel loop feeds setQR, so any number of requests can wait at once.
+func (api *WhatsAppAPI) waitForQR(ctx context.Context) (string, time.Time, bool) {
+	api.qrMu.Lock()
+	ready := api.qrReady
+	timeout := api.qrTimeout
+	api.qrMu.Unlock()
+
+	select {
+	case <-ready:
+	default:
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case <-ready:
+		case <-timer.C:
+			return "", time.Time{}, false
+		case <-ctx.Done():
+			return "", time.Time{}, false
+		}
+	}
+
+	api.qrMu.Lock()
+	defer api.qrMu.Unlock()
+	return api.currentQR, api.qrUpdated, api.currentQR != ""
+}
+
+// getQRImage renders the current QR code as a PNG so it can be embedded
+// directly with an <img> tag.
+func (api *WhatsAppAPI) getQRImage(w http.ResponseWriter, r *http.Request) {
+	if api.client.Store.ID != nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	code, updated, ok := api.waitForQR(r.Context())
+	if !ok {
+		http.Error(w, "Timed out waiting for QR code", http.StatusGatewayTimeout)
+		return
+	}
+
+	png, err := qrcode.Encode(code, qrcode.Medium, qrImageSize)
+	if err != nil {
+		api.requestLog(r).Errorf("Failed to render QR code: %v", err)
+		http.Error(w, "Failed to render QR code", http.StatusInternalServerError)
+		return
+	}
+
+	// Let clients cache the image only until the code is due to rotate.
+	maxAge := int((qrRotation - time.Since(updated)).Seconds())
+	if maxAge < 0 {
+		maxAge = 0
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", maxAge))
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(png)
+}
+
+func (api *WhatsAppAPI) getAuthStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(api.authSnapshot())
+}
+
+// authSnapshot copies the authentication state under the locks guarding it,
+// so the response can be encoded while event handlers keep updating it.
+func (api *WhatsAppAPI) authSnapshot() AuthStatusResponse {
+	response := AuthStatusResponse{
+		ConnectionStatus: api.getConnectionStatus(),
+		Pairing:          api.pairingSnapshot(),
+	}
+	if id := api.client.Store.ID; id != nil {
+		response.IsAuthenticated = true
+		response.Phone = id.User
+	}
+
+	api.reconnect.mu.Lock()
+	if api.reconnect.state.Reconnecting {
+		state := api.reconnect.state
+		response.Reconnect = &state
+	}
+	api.reconnect.mu.Unlock()
+
+	if lastSeen := api.activity.get(); !lastSeen.IsZero() {
+		response.LastSeen = &lastSeen
+	}
+	return response
+}
+
+// getAccount describes the linked account using the registration details
+// whatsmeow keeps in the device store.
+func (api *WhatsAppAPI) getAccount(w http.ResponseWriter, r *http.Request) {
+	store := api.client.Store
+	if store.ID == nil {
+		http.Error(w, "Not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	response := AccountResponse{
+		JID:            store.ID.String(),
+		Phone:          store.ID.User,
+		Device:         store.ID.Device,
+		PushName:       store.PushName,
+		IsBusiness:     store.BusinessName != "",
+		BusinessName:   store.BusinessName,
+		Platform:       store.Platform,
+		RegistrationID: store.RegistrationID,
+		Connected:      api.client.IsConnected(),
+	}
+	if !store.LID.IsEmpty() {
+		response.LID = store.LID.String()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// logout unlinks the device. Messages, chats and contacts are kept so the
+// archive stays readable, unless purge=true is passed.
+func (api *WhatsAppAPI) logout(w http.ResponseWriter, r *http.Request) {
+	if api.client.Store.ID == nil {
+		http.Error(w, "Not authenticated", http.StatusBadRequest)
+		return
+	}
+	purge, ok := parseBoolFilter(r, "purge")
+	if !ok {
+		http.Error(w, "Invalid purge flag, expected true or false", http.StatusBadRequest)
+		return
+	}
+
+	// Logging out is deliberate, so don't fight it with reconnect attempts.
+	api.stopReconnect()
+
+	err := api.client.Logout(context.Background())
+	if err != nil {
+		http.Error(w, "Failed to logout", http.StatusInternalServerError)
+		return
+	}
+
+	if purge != nil && *purge {
+		api.purgeHistory()
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// purgeHistory forgets everything stored about the account: messages,
+// calls, contacts, chat names and flags, and unsent messages. Service
+// settings such as the config and webhooks are kept.
+func (api *WhatsAppAPI) purgeHistory() {
+	api.replaceMessages(func([]MessageInfo) []MessageInfo {
+		return make([]MessageInfo, 0)
+	})
+	api.callsMu.Lock()
+	api.calls = make([]CallInfo, 0)
+	api.callsMu.Unlock()
+	api.presencesMu.Lock()
+	api.presences = make(map[string]PresenceInfo)
+	api.presencesMu.Unlock()
+	api.chatNamesMu.Lock()
+	api.chatNames = make(map[string]string)
+	api.chatNamesMu.Unlock()
+	api.chatStatesMu.Lock()
+	api.chatStates = make(map[string]ChatState)
+	api.chatStatesMu.Unlock()
+	api.groupNamesMu.Lock()
+	api.groupNames = make(map[string]string)
+	api.groupNamesMu.Unlock()
+	api.contactsMu.Lock()
+	api.contacts = make(map[string]ContactName)
+	api.contactsMu.Unlock()
+
+	if err := api.outbox.clear(); err != nil {
+		api.log.Errorf("Failed to clear the outbox: %v", err)
+	}
+	if err := api.thumbnails.clear(); err != nil {
+		api.log.Errorf("Failed to clear thumbnails: %v", err)
+	}
+	api.log.Infof("Purged stored history after logout")
+}
+
+func (api *WhatsAppAPI) pairPhone(w http.ResponseWriter, r *http.Request) {
+	if api.client.Store.ID != nil {
+		http.Error(w, "Already authenticated", http.StatusBadRequest)
 		return
 	}
 
@@ -276,14 +1556,21 @@ func (api *WhatsAppAPI) pairPhone(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.PhoneNumber == "" {
-		http.Error(w, "Phone number is required", http.StatusBadRequest)
+	phone, err := normalizePhoneNumber(req.PhoneNumber)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	ctx, cancel := context.WithTimeout(r.Context(), connectTimeout)
+	defer cancel()
+
 	if !api.client.IsConnected() {
-		err := api.client.Connect()
-		if err != nil {
+		err := api.connect(ctx)
+		if errors.Is(err, errConnectTimeout) {
+			http.Error(w, "Timed out connecting to WhatsApp", http.StatusGatewayTimeout)
+			return
+		} else if err != nil {
 			http.Error(w, "Failed to connect", http.StatusInternalServerError)
 			return
 		}
@@ -291,13 +1578,15 @@ func (api *WhatsAppAPI) pairPhone(w http.ResponseWriter, r *http.Request) {
 		time.Sleep(time.Second)
 	}
 
-	pairCode, err := api.client.PairPhone(context.Background(), req.PhoneNumber, req.ShowNotification, whatsmeow.PairClientChrome, "Chrome (Windows)")
+	pairCode, err := api.client.PairPhone(ctx, phone, req.ShowNotification, whatsmeow.PairClientChrome, "Chrome (Windows)")
 	if err != nil {
-		api.log.Errorf("Failed to generate pair code: %v", err)
+		api.requestLog(r).Errorf("Failed to generate pair code: %v", err)
 		http.Error(w, "Failed to generate pair code", http.StatusInternalServerError)
 		return
 	}
 
+	api.startPairing("phone", phone)
+
 	response := PairCodeResponse{PairCode: pairCode}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
@@ -309,7 +1598,25 @@ func (api *WhatsAppAPI) getMessages(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	response := MessagesResponse{Messages: api.messages}
+	api.writeMessagesPage(w, r, api.snapshotMessages())
+}
+
+// writeMessagesPage responds with the page of messages selected by the
+// limit, before and after query parameters.
+func (api *WhatsAppAPI) writeMessagesPage(w http.ResponseWriter, r *http.Request, messages []MessageInfo) {
+	query, err := parseMessageQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, next, err := api.paginateMessages(messages, query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := MessagesResponse{Messages: api.enrichMessages(page), NextCursor: next}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
@@ -324,15 +1631,13 @@ func (api *WhatsAppAPI) getChatMessages(w http.ResponseWriter, r *http.Request)
 	chatId := vars["chatId"]
 
 	var chatMessages []MessageInfo
-	for _, msg := range api.messages {
+	for _, msg := range api.snapshotMessages() {
 		if msg.Source.Chat == chatId {
 			chatMessages = append(chatMessages, msg)
 		}
 	}
 
-	response := MessagesResponse{Messages: chatMessages}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	api.writeMessagesPage(w, r, chatMessages)
 }
 
 func (api *WhatsAppAPI) updateReadStatus(w http.ResponseWriter, r *http.Request) {
@@ -347,34 +1652,556 @@ func (api *WhatsAppAPI) updateReadStatus(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	for i, msg := range api.messages {
-		if msg.ID == req.MessageID {
-			api.messages[i].IsRead = req.Read
-			
-			if req.Read {
-				chatJID, err := types.ParseJID(msg.Source.Chat)
-				if err != nil {
-					http.Error(w, "Invalid chat JID", http.StatusBadRequest)
-					return
-				}
-
-				senderJID, err := types.ParseJID(msg.Source.Sender)
-				if err != nil {
-					http.Error(w, "Invalid sender JID", http.StatusBadRequest)
-					return
-				}
-
-				err = api.client.MarkRead([]string{req.MessageID}, time.Now(), chatJID, senderJID)
-				if err != nil {
-					http.Error(w, "Failed to mark as read", http.StatusInternalServerError)
-					return
-				}
-			}
-			
-			w.WriteHeader(http.StatusOK)
+	msg, ok := api.findMessage(req.MessageID)
+	if !ok {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+
+	// Read receipts are sent before the local state changes so that a
+	// message is never shown as read without the sender seeing it.
+	if req.Read && !msg.IsRead && !msg.Source.IsFromMe {
+		if !api.requireConnected(w) {
+			return
+		}
+
+		chatJID, err := types.ParseJID(msg.Source.Chat)
+		if err != nil {
+			http.Error(w, "Invalid chat JID", http.StatusBadRequest)
+			return
+		}
+
+		senderJID, err := types.ParseJID(msg.Source.Sender)
+		if err != nil {
+			http.Error(w, "Invalid sender JID", http.StatusBadRequest)
+			return
+		}
+
+		err = api.client.MarkRead([]string{req.MessageID}, time.Now(), chatJID, senderJID)
+		if err != nil {
+			api.requestLog(r).Errorf("Failed to send read receipt for %s: %v", req.MessageID, err)
+			http.Error(w, "Failed to mark as read", http.StatusInternalServerError)
+			return
+		}
+	}
+	api.updateMessage(msg.ID, func(msg *MessageInfo) {
+		msg.IsRead = req.Read
+	})
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (api *WhatsAppAPI) getDiagnostics(w http.ResponseWriter, r *http.Request) {
+	response := DiagnosticsResponse{
+		Connected:          api.client.IsConnected(),
+		ClockSkew:          api.clockSkewSnapshot(),
+		ClockSkewThreshold: clockSkewThreshold.Milliseconds(),
+		Quality:            api.quality.snapshot(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (api *WhatsAppAPI) sendPresence(w http.ResponseWriter, r *http.Request) {
+	if api.client.Store.ID == nil {
+		http.Error(w, "Not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	var req PresenceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	chatJID, err := types.ParseJID(req.ChatID)
+	if err != nil {
+		http.Error(w, "Invalid chat JID", http.StatusBadRequest)
+		return
+	}
+
+	// Recording a voice note is sent as composing with audio media.
+	state := types.ChatPresence(req.State)
+	media := types.ChatPresenceMedia(req.Media)
+	if req.State == "recording" {
+		state = types.ChatPresenceComposing
+		media = types.ChatPresenceMediaAudio
+	}
+	if state != types.ChatPresenceComposing && state != types.ChatPresencePaused {
+		http.Error(w, "State must be composing, recording or paused", http.StatusBadRequest)
+		return
+	}
+
+	if media == "" {
+		media = types.ChatPresenceMediaText
+	} else if media != types.ChatPresenceMediaText && media != types.ChatPresenceMediaAudio {
+		http.Error(w, "Media must be text or audio", http.StatusBadRequest)
+		return
+	}
+
+	err = api.client.SendChatPresence(chatJID, state, media)
+	if err != nil {
+		api.requestLog(r).Errorf("Failed to send chat presence: %v", err)
+		http.Error(w, "Failed to send presence", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (api *WhatsAppAPI) getPresence(w http.ResponseWriter, r *http.Request) {
+	if api.client.Store.ID == nil {
+		http.Error(w, "Not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	api.presencesMu.Lock()
+	presence, ok := api.presences[vars["chatId"]]
+	api.presencesMu.Unlock()
+	if !ok {
+		http.Error(w, "No presence for chat", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(presence)
+}
+
+// appendMessage stores messages after the ones already stored.
+func (api *WhatsAppAPI) appendMessage(msgs ...MessageInfo) {
+	api.messagesMu.Lock()
+	api.messages = append(api.messages, msgs...)
+	api.messagesMu.Unlock()
+}
+
+// snapshotMessages returns a copy of the stored messages, which callers can
+// go through without holding messagesMu.
+func (api *WhatsAppAPI) snapshotMessages() []MessageInfo {
+	api.messagesMu.RLock()
+	defer api.messagesMu.RUnlock()
+	return slices.Clone(api.messages)
+}
+
+// updateMessage applies update to the stored message with the given ID and
+// returns its new state, or false if it isn't stored. Copies handed out
+// earlier share the media and proto of the message, so update must replace
+// those rather than change them.
+func (api *WhatsAppAPI) updateMessage(id string, update func(*MessageInfo)) (MessageInfo, bool) {
+	api.messagesMu.Lock()
+	defer api.messagesMu.Unlock()
+	for i := range api.messages {
+		if api.messages[i].ID == id {
+			update(&api.messages[i])
+			return api.messages[i], true
+		}
+	}
+	return MessageInfo{}, false
+}
+
+// updateMessages calls update on every stored message and returns how many
+// it reported as changed. The same rules as for updateMessage apply.
+func (api *WhatsAppAPI) updateMessages(update func(*MessageInfo) bool) int {
+	api.messagesMu.Lock()
+	defer api.messagesMu.Unlock()
+	changed := 0
+	for i := range api.messages {
+		if update(&api.messages[i]) {
+			changed++
+		}
+	}
+	return changed
+}
+
+// replaceMessages replaces the stored messages with what replace returns
+// for them, as one step.
+func (api *WhatsAppAPI) replaceMessages(replace func([]MessageInfo) []MessageInfo) {
+	api.messagesMu.Lock()
+	api.messages = replace(api.messages)
+	api.messagesMu.Unlock()
+}
+
+// deleteMessages removes locally stored messages matching the chat_id, before
+// and type query parameters. Nothing is revoked on WhatsApp. At least one
+// filter is required so that a bare request can't wipe the whole history.
+func (api *WhatsAppAPI) deleteMessages(w http.ResponseWriter, r *http.Request) {
+	if api.client.Store.ID == nil {
+		http.Error(w, "Not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+	chatId := query.Get("chat_id")
+	msgType := query.Get("type")
+
+	var before time.Time
+	if raw := query.Get("before"); raw != "" {
+		var err error
+		before, err = time.Parse(time.RFC3339, raw)
+		if err != nil {
+			http.Error(w, "Invalid before timestamp, expected RFC3339", http.StatusBadRequest)
 			return
 		}
 	}
 
-	http.Error(w, "Message not found", http.StatusNotFound)
-}
\ No newline at end of file
+	if chatId == "" && msgType == "" && before.IsZero() {
+		http.Error(w, "At least one filter (chat_id, before, type) is required", http.StatusBadRequest)
+		return
+	}
+
+	deleted := 0
+	// Unread counts are derived from the remaining messages, so they stay
+	// consistent without any extra bookkeeping.
+	api.replaceMessages(func(messages []MessageInfo) []MessageInfo {
+		kept := make([]MessageInfo, 0, len(messages))
+		for _, msg := range messages {
+			if (chatId == "" || msg.Source.Chat == chatId) &&
+				(msgType == "" || msg.Content.Type == msgType) &&
+				(before.IsZero() || msg.Timestamp.Before(before)) {
+				deleted++
+				continue
+			}
+			kept = append(kept, msg)
+		}
+		return kept
+	})
+
+	response := DeleteMessagesResponse{Deleted: deleted}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (api *WhatsAppAPI) getCalls(w http.ResponseWriter, r *http.Request) {
+	if api.client.Store.ID == nil {
+		http.Error(w, "Not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	api.callsMu.Lock()
+	response := CallsResponse{Calls: slices.Clone(api.calls)}
+	api.callsMu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (api *WhatsAppAPI) updateCallSettings(w http.ResponseWriter, r *http.Request) {
+	ok := api.updateConfig(w, func(cfg *Config) error {
+		var req CallSettingsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return err
+		}
+		cfg.RejectCalls = req.RejectCalls
+		return nil
+	})
+	if ok {
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (api *WhatsAppAPI) findMessage(id string) (MessageInfo, bool) {
+	api.messagesMu.RLock()
+	defer api.messagesMu.RUnlock()
+	for _, msg := range api.messages {
+		if msg.ID == id {
+			return msg, true
+		}
+	}
+	return MessageInfo{}, false
+}
+
+// getMessage returns a single stored message, in the same shape as the
+// entries of the message lists.
+func (api *WhatsAppAPI) getMessage(w http.ResponseWriter, r *http.Request) {
+	if api.client.Store.ID == nil {
+		http.Error(w, "Not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	msg, ok := api.findMessage(vars["messageId"])
+	if !ok {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(api.enrichMessages([]MessageInfo{msg})[0])
+}
+
+// getMessageThread returns the reply chain around a message: the quoted
+// messages it replies to (walking up) and the replies to any of those
+// (walking down), ordered by timestamp.
+func (api *WhatsAppAPI) getMessageThread(w http.ResponseWriter, r *http.Request) {
+	if api.client.Store.ID == nil {
+		http.Error(w, "Not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	start, ok := api.findMessage(vars["messageId"])
+	if !ok {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+
+	response := ThreadResponse{
+		Messages:   []MessageInfo{start},
+		MissingIDs: make([]string, 0),
+	}
+	seen := map[string]bool{start.ID: true}
+
+	// Walk up the quoted references to the root of the thread.
+	for current := start; current.Content.QuotedID != "" && !seen[current.Content.QuotedID]; {
+		if len(response.Messages) >= maxThreadDepth {
+			response.Truncated = true
+			break
+		}
+		quoted, ok := api.findMessage(current.Content.QuotedID)
+		if !ok {
+			response.MissingIDs = append(response.MissingIDs, current.Content.QuotedID)
+			break
+		}
+		seen[quoted.ID] = true
+		response.Messages = append(response.Messages, quoted)
+		current = quoted
+	}
+
+	// Walk down, repeatedly collecting replies to messages already in the thread.
+	for added := true; added && !response.Truncated; {
+		added = false
+		for _, msg := range api.snapshotMessages() {
+			if seen[msg.ID] || msg.Content.QuotedID == "" || !seen[msg.Content.QuotedID] {
+				continue
+			}
+			if len(response.Messages) >= maxThreadDepth {
+				response.Truncated = true
+				break
+			}
+			seen[msg.ID] = true
+			response.Messages = append(response.Messages, msg)
+			added = true
+		}
+	}
+
+	sort.Slice(response.Messages, func(i, j int) bool {
+		return response.Messages[i].Timestamp.Before(response.Messages[j].Timestamp)
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// displayName resolves a human readable name for a JID, preferring the
+// contact's saved name, then their push name and finally the bare number.
+func (api *WhatsAppAPI) displayName(jid types.JID) string {
+	api.contactsMu.Lock()
+	cached, ok := api.contacts[jid.ToNonAD().String()]
+	api.contactsMu.Unlock()
+	if ok {
+		if cached.FullName != "" {
+			return cached.FullName
+		}
+		if cached.PushName != "" {
+			return cached.PushName
+		}
+	}
+
+	contact, err := api.client.Store.Contacts.GetContact(context.Background(), jid)
+	if err == nil && contact.Found {
+		switch {
+		case contact.FullName != "":
+			return contact.FullName
+		case contact.FirstName != "":
+			return contact.FirstName
+		case contact.BusinessName != "":
+			return contact.BusinessName
+		case contact.PushName != "":
+			return contact.PushName
+		}
+	}
+
+	return jid.User
+}
+
+func (api *WhatsAppAPI) getGroupInfo(w http.ResponseWriter, r *http.Request) {
+	if api.client.Store.ID == nil {
+		http.Error(w, "Not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	groupJID, err := types.ParseJID(vars["groupId"])
+	if err != nil || groupJID.Server != types.GroupServer {
+		http.Error(w, "Invalid group JID", http.StatusBadRequest)
+		return
+	}
+
+	info, err := api.client.GetGroupInfo(groupJID)
+	if err != nil {
+		api.requestLog(r).Errorf("Failed to get group info for %s: %v", groupJID, err)
+		http.Error(w, "Failed to get group info", http.StatusInternalServerError)
+		return
+	}
+
+	// Names are resolved locally, so only do it when the caller asks.
+	response := api.groupInfoResponse(info, r.URL.Query().Get("enrich") == "true")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// getGroups lists the groups this account is a member of.
+func (api *WhatsAppAPI) getGroups(w http.ResponseWriter, r *http.Request) {
+	if !api.requireConnected(w) {
+		return
+	}
+
+	groups, err := api.client.GetJoinedGroups()
+	if err != nil {
+		api.requestLog(r).Errorf("Failed to get joined groups: %v", err)
+		http.Error(w, "Failed to get groups", http.StatusInternalServerError)
+		return
+	}
+
+	enrich := r.URL.Query().Get("enrich") == "true"
+	response := GroupsResponse{Groups: make([]GroupInfoResponse, 0, len(groups))}
+	for _, info := range groups {
+		response.Groups = append(response.Groups, api.groupInfoResponse(info, enrich))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// groupInfoResponse converts group info for the API and remembers the group
+// subject so that it can be used as the chat name.
+func (api *WhatsAppAPI) groupInfoResponse(info *types.GroupInfo, enrich bool) GroupInfoResponse {
+	api.rememberGroupName(info.JID.String(), info.Name)
+
+	response := GroupInfoResponse{
+		JID:          info.JID.String(),
+		Name:         info.Name,
+		Topic:        info.Topic,
+		Participants: make([]GroupParticipant, 0, len(info.Participants)),
+	}
+	if !info.OwnerJID.IsEmpty() {
+		response.Owner = info.OwnerJID.String()
+	}
+	for _, p := range info.Participants {
+		participant := GroupParticipant{
+			JID:          p.JID.String(),
+			IsAdmin:      p.IsAdmin,
+			IsSuperAdmin: p.IsSuperAdmin,
+		}
+		if enrich {
+			participant.Name = api.displayName(p.JID)
+		}
+		response.Participants = append(response.Participants, participant)
+	}
+	return response
+}
+
+// chatName returns the name to show for a chat: the local override if one is
+// set, otherwise the contact name for direct chats or the last known subject
+// for groups.
+func (api *WhatsAppAPI) chatName(chat string) string {
+	api.chatNamesMu.Lock()
+	name, ok := api.chatNames[chat]
+	api.chatNamesMu.Unlock()
+	if ok {
+		return name
+	}
+
+	jid, err := types.ParseJID(chat)
+	if err != nil {
+		return ""
+	}
+	if jid.Server == types.GroupServer {
+		api.groupNamesMu.Lock()
+		defer api.groupNamesMu.Unlock()
+		return api.groupNames[chat]
+	}
+	return api.displayName(jid)
+}
+
+// rememberGroupName caches the subject of a group for chatName.
+func (api *WhatsAppAPI) rememberGroupName(group, name string) {
+	api.groupNamesMu.Lock()
+	api.groupNames[group] = name
+	api.groupNamesMu.Unlock()
+}
+
+// enrichMessages returns a copy of msgs with the chat names filled in, and
+// presigned URLs for archived media if presigning is on.
+func (api *WhatsAppAPI) enrichMessages(msgs []MessageInfo) []MessageInfo {
+	names := make(map[string]string)
+	enriched := make([]MessageInfo, len(msgs))
+	for i, msg := range msgs {
+		name, ok := names[msg.Source.Chat]
+		if !ok {
+			name = api.chatName(msg.Source.Chat)
+			names[msg.Source.Chat] = name
+		}
+		msg.Source.ChatName = name
+		if presigned, ok := api.presignedArchiveURL(msg.Content.Media, ""); ok {
+			media := *msg.Content.Media
+			media.ArchiveURL = presigned
+			msg.Content.Media = &media
+		}
+		enriched[i] = msg
+	}
+	return enriched
+}
+
+// setChatName sets a local display name for a chat. It is never sent to
+// WhatsApp; an empty name clears the override.
+func (api *WhatsAppAPI) setChatName(w http.ResponseWriter, r *http.Request) {
+	if api.client.Store.ID == nil {
+		http.Error(w, "Not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	var req ChatNameRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	vars := mux.Vars(r)
+	chatId := vars["chatId"]
+	if _, err := types.ParseJID(chatId); err != nil {
+		http.Error(w, "Invalid chat JID", http.StatusBadRequest)
+		return
+	}
+
+	api.chatNamesMu.Lock()
+	if req.Name == "" {
+		delete(api.chatNames, chatId)
+	} else {
+		api.chatNames[chatId] = req.Name
+	}
+	api.chatNamesMu.Unlock()
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// getStatuses returns the status updates posted by contacts, which arrive as
+// messages in the status@broadcast chat.
+func (api *WhatsAppAPI) getStatuses(w http.ResponseWriter, r *http.Request) {
+	if api.client.Store.ID == nil {
+		http.Error(w, "Not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	statusChat := types.StatusBroadcastJID.String()
+	statuses := make([]MessageInfo, 0)
+	for _, msg := range api.snapshotMessages() {
+		if msg.Source.Chat == statusChat {
+			statuses = append(statuses, msg)
+		}
+	}
+
+	response := MessagesResponse{Messages: api.enrichMessages(statuses)}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waCommon"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/store"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+	"google.golang.org/protobuf/proto"
+)
+
+// newTestAPI returns an unpaired, disconnected API with no database behind
+// it, which is enough for the handlers that only touch in-memory state.
+func newTestAPI(t *testing.T) *WhatsAppAPI {
+	t.Helper()
+	api := &WhatsAppAPI{
+		client:   &whatsmeow.Client{Store: &store.Device{}},
+		log:      waLog.Noop,
+		events:   NewEventBus(),
+		webhooks: newWebhookDispatcher(waLog.Noop, nil),
+		quality:  newQualityTracker(),
+		metrics:  newAPIMetrics(),
+		activity: &activityTracker{},
+
+		messages:         make([]MessageInfo, 0),
+		qrReady:          make(chan struct{}),
+		qrTimeout:        time.Second,
+		presences:        make(map[string]PresenceInfo),
+		chatNames:        make(map[string]string),
+		chatStates:       make(map[string]ChatState),
+		groupNames:       make(map[string]string),
+		contacts:         make(map[string]ContactName),
+		autoReplied:      make(map[string]time.Time),
+		liveShares:       make(map[string]*liveShare),
+		mediaRetries:     make(map[string][]chan *events.MediaRetry),
+		connectionStatus: "disconnected",
+		statusDebounce:   10 * time.Millisecond,
+	}
+	t.Cleanup(api.events.Close)
+	return api
+}
+
+// collectEvents returns the events of the given type emitted until wait
+// has passed.
+func collectEvents(api *WhatsAppAPI, event string, wait time.Duration, during func()) []Event {
+	ch := api.events.Subscribe()
+	defer api.events.Unsubscribe(ch)
+	during()
+
+	var collected []Event
+	timeout := time.After(wait)
+	for {
+		select {
+		case evt := <-ch:
+			if evt.Type == event {
+				collected = append(collected, evt)
+			}
+		case <-timeout:
+			return collected
+		}
+	}
+}
+
+func TestSetConnectionStatusDebounces(t *testing.T) {
+	api := newTestAPI(t)
+
+	got := collectEvents(api, "connection", 20*api.statusDebounce, func() {
+		for i := 0; i < 10; i++ {
+			api.setConnectionStatus("connected")
+			api.setConnectionStatus("disconnected")
+		}
+		api.setConnectionStatus("connected")
+	})
+
+	if len(got) != 1 {
+		t.Fatalf("got %d connection events for a burst, want 1: %v", len(got), got)
+	}
+	if data := got[0].Data.(map[string]string); data["status"] != "connected" {
+		t.Errorf("settled on %q, want connected", data["status"])
+	}
+	if status := api.getConnectionStatus(); status != "connected" {
+		t.Errorf("getConnectionStatus() = %q, want connected", status)
+	}
+}
+
+func TestSetConnectionStatusIgnoresFlapBack(t *testing.T) {
+	api := newTestAPI(t)
+
+	got := collectEvents(api, "connection", 20*api.statusDebounce, func() {
+		api.setConnectionStatus("connected")
+		api.setConnectionStatus("disconnected")
+	})
+
+	if len(got) != 0 {
+		t.Errorf("got %d connection events for a burst ending on the current status, want none", len(got))
+	}
+}
+
+// pollQR requests the QR code the way clients do. It may run in its own
+// goroutine, so it reports failures without stopping the test.
+func pollQR(t *testing.T, api *WhatsAppAPI) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	api.getQR(rec, httptest.NewRequest(http.MethodGet, "/qr", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("GET /qr = %d %s", rec.Code, rec.Body)
+		return ""
+	}
+	var response QRResponse
+	if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+		t.Error(err)
+	}
+	return response.QR
+}
+
+func TestGetQRReturnsCurrentCode(t *testing.T) {
+	api := newTestAPI(t)
+
+	// The first poll waits for the code, as it does right after connecting.
+	first := make(chan string, 1)
+	go func() { first <- pollQR(t, api) }()
+	time.Sleep(10 * time.Millisecond)
+	api.setQR("code-1")
+	if got := <-first; got != "code-1" {
+		t.Errorf("first poll = %q, want code-1", got)
+	}
+
+	// Polling again doesn't consume the code.
+	if got := pollQR(t, api); got != "code-1" {
+		t.Errorf("second poll = %q, want code-1", got)
+	}
+
+	api.setQR("code-2")
+	if got := pollQR(t, api); got != "code-2" {
+		t.Errorf("poll after rotation = %q, want code-2", got)
+	}
+}
+
+func TestGetQRTimesOutWithoutCode(t *testing.T) {
+	api := newTestAPI(t)
+	api.qrTimeout = 10 * time.Millisecond
+	api.setQR("code-1")
+	api.setQR("")
+
+	if _, _, ok := api.waitForQR(t.Context()); ok {
+		t.Error("waitForQR() returned a code after it stopped being scannable")
+	}
+}
+
+func TestHandleReactionToOutboundMessage(t *testing.T) {
+	api := newTestAPI(t)
+	me := types.NewJID("15550000001", types.DefaultUserServer)
+	api.client.Store.ID = &me
+	chat := types.NewJID("15550000002", types.DefaultUserServer)
+	api.appendMessage(MessageInfo{
+		ID:        "OUTBOUND",
+		Timestamp: time.Now(),
+		Source:    MessageSource{Chat: chat.String(), Sender: me.String(), IsFromMe: true},
+		Content:   MessageContent{Text: "hi", Type: "text"},
+		Status:    MessageStatusSent,
+	})
+
+	react := func(emoji string) *events.Message {
+		return &events.Message{
+			Info: types.MessageInfo{
+				MessageSource: types.MessageSource{Chat: chat, Sender: chat},
+				ID:            "REACTION",
+				Timestamp:     time.Now(),
+			},
+			Message: &waE2E.Message{ReactionMessage: &waE2E.ReactionMessage{
+				// FromMe is relative to the reactor, who didn't send it.
+				Key: &waCommon.MessageKey{
+					RemoteJID: proto.String(chat.String()),
+					FromMe:    proto.Bool(false),
+					ID:        proto.String("OUTBOUND"),
+				},
+				Text: proto.String(emoji),
+			}},
+		}
+	}
+
+	got := collectEvents(api, "reaction", 10*time.Millisecond, func() {
+		api.handleMessage(react("👍"))
+	})
+	if len(got) != 1 {
+		t.Fatalf("got %d reaction events, want 1", len(got))
+	}
+	event := got[0].Data.(ReactionEvent)
+	if !event.TargetFromMe || event.MessageID != "OUTBOUND" || event.Emoji != "👍" || event.Removed {
+		t.Errorf("reaction event = %+v, want 👍 on our message OUTBOUND", event)
+	}
+	msg, _ := api.findMessage("OUTBOUND")
+	if len(msg.Reactions) != 1 || msg.Reactions[0].Sender != chat.String() || msg.Reactions[0].Emoji != "👍" {
+		t.Errorf("reactions = %+v, want the 👍 from %s", msg.Reactions, chat)
+	}
+	if len(api.snapshotMessages()) != 1 {
+		t.Error("the reaction was stored as a message of its own")
+	}
+
+	// An empty reaction removes it again.
+	api.handleMessage(react(""))
+	if msg, _ := api.findMessage("OUTBOUND"); len(msg.Reactions) != 0 {
+		t.Errorf("reactions = %+v after removal, want none", msg.Reactions)
+	}
+}
+
+func TestHandleEditKeepsMedia(t *testing.T) {
+	api := newTestAPI(t)
+	chat := types.NewJID("15550000002", types.DefaultUserServer)
+	original := &waE2E.Message{ImageMessage: &waE2E.ImageMessage{
+		Caption:       proto.String("old caption"),
+		Mimetype:      proto.String("image/jpeg"),
+		MediaKey:      []byte{1, 2, 3},
+		DirectPath:    proto.String("/v/image"),
+		FileSHA256:    []byte{4, 5, 6},
+		FileEncSHA256: []byte{7, 8, 9},
+	}}
+	stored := newMessageInfo(&events.Message{
+		Info: types.MessageInfo{
+			MessageSource: types.MessageSource{Chat: chat, Sender: chat},
+			ID:            "IMAGE",
+			Timestamp:     time.Now(),
+		},
+		Message: original,
+	})
+	api.appendMessage(stored)
+
+	// Edits of media only carry the new caption.
+	api.handleEdit(&waE2E.ProtocolMessage{
+		Type: waE2E.ProtocolMessage_MESSAGE_EDIT.Enum(),
+		Key:  &waCommon.MessageKey{ID: proto.String("IMAGE")},
+		EditedMessage: &waE2E.Message{ImageMessage: &waE2E.ImageMessage{
+			Caption: proto.String("new caption"),
+		}},
+	}, time.Now())
+
+	msg, _ := api.findMessage("IMAGE")
+	if msg.Content.Text != "new caption" || msg.EditedAt == nil {
+		t.Errorf("edited message has text %q, edited at %v, want the new caption", msg.Content.Text, msg.EditedAt)
+	}
+	if msg.Content.Type != "image" || !reflect.DeepEqual(msg.Content.Media, stored.Content.Media) {
+		t.Errorf("media = %+v, want it unchanged: %+v", msg.Content.Media, stored.Content.Media)
+	}
+	image := msg.raw.GetImageMessage()
+	if image.GetCaption() != "new caption" || image.GetDirectPath() != "/v/image" ||
+		!reflect.DeepEqual(image.GetMediaKey(), []byte{1, 2, 3}) || !reflect.DeepEqual(image.GetFileSHA256(), []byte{4, 5, 6}) {
+		t.Errorf("stored proto = %v, want the new caption with the original media reference", image)
+	}
+	if original.GetImageMessage().GetCaption() != "old caption" {
+		t.Error("the edit changed the proto shared with earlier copies of the message")
+	}
+}
+
+func TestHandleMessageStoresPlainMessages(t *testing.T) {
+	api := newTestAPI(t)
+	sender := types.NewJID("15550000002", types.DefaultUserServer)
+	info := types.MessageInfo{
+		MessageSource: types.MessageSource{Chat: sender, Sender: sender},
+		ID:            "PLAIN",
+		Timestamp:     time.Now(),
+	}
+
+	api.handleMessage(&events.Message{Info: info, Message: &waE2E.Message{Conversation: proto.String("hello")}})
+	msg, ok := api.findMessage("PLAIN")
+	if !ok || msg.Content.Text != "hello" || msg.RevokedAt != nil {
+		t.Fatalf("stored message = %+v, %t, want the plain text message", msg, ok)
+	}
+
+	revokedAt := time.Now()
+	info.ID = "REVOKE"
+	info.Timestamp = revokedAt
+	api.handleMessage(&events.Message{Info: info, Message: &waE2E.Message{ProtocolMessage: &waE2E.ProtocolMessage{
+		Type: waE2E.ProtocolMessage_REVOKE.Enum(),
+		Key:  &waCommon.MessageKey{ID: proto.String("PLAIN")},
+	}}})
+	if msg, _ := api.findMessage("PLAIN"); msg.RevokedAt == nil || msg.Content.Text != "" {
+		t.Errorf("message after revoke = %+v, want it revoked", msg)
+	}
+	if _, ok := api.findMessage("REVOKE"); ok {
+		t.Error("the revoke was stored as a message of its own")
+	}
+}
+
+// TestConcurrentAccess receives, stores and reads messages and chat state
+// from many goroutines at once, as whatsmeow's event dispatch, background
+// workers and HTTP handlers do. It only finds anything with -race.
+func TestConcurrentAccess(t *testing.T) {
+	api := newTestAPI(t)
+	me := types.NewJID("15550000001", types.DefaultUserServer)
+	api.client.Store.ID = &me
+	group := types.NewJID("120363000000000001", types.GroupServer)
+	contacts := make([]types.JID, 4)
+	for i := range contacts {
+		contacts[i] = types.NewJID(fmt.Sprintf("1555000010%d", i), types.DefaultUserServer)
+		// Names are cached up front, as the device store is unavailable.
+		api.updatePushName(contacts[i], fmt.Sprintf("Contact %d", i))
+	}
+
+	const rounds = 100
+	var wg sync.WaitGroup
+	run := func(do func(i int)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				do(i)
+			}
+		}()
+	}
+	serve := func(handler http.HandlerFunc, method, target, body string, vars map[string]string) {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		handler(httptest.NewRecorder(), mux.SetURLVars(req, vars))
+	}
+
+	// Incoming messages, receipts and other events.
+	run(func(i int) {
+		sender := contacts[i%len(contacts)]
+		api.eventHandler(&events.Message{
+			Info: types.MessageInfo{
+				MessageSource: types.MessageSource{Chat: sender, Sender: sender},
+				ID:            fmt.Sprintf("IN%d", i),
+				PushName:      fmt.Sprintf("Contact %d", i%len(contacts)),
+				Timestamp:     time.Now(),
+			},
+			Message: &waE2E.Message{Conversation: proto.String("hello")},
+		})
+	})
+	run(func(i int) {
+		sender := contacts[i%len(contacts)]
+		api.eventHandler(&events.Receipt{
+			MessageSource: types.MessageSource{Chat: sender, Sender: sender},
+			MessageIDs:    []string{fmt.Sprintf("IN%d", i), fmt.Sprintf("OUT%d", i)},
+			Type:          types.ReceiptTypeRead,
+			Timestamp:     time.Now(),
+		})
+		api.eventHandler(&events.Receipt{
+			MessageSource: types.MessageSource{Chat: sender, Sender: sender},
+			MessageIDs:    []string{fmt.Sprintf("OUT%d", i)},
+			Type:          types.ReceiptTypeDelivered,
+			Timestamp:     time.Now(),
+		})
+	})
+	run(func(i int) {
+		sender := contacts[i%len(contacts)]
+		api.eventHandler(&events.ChatPresence{
+			MessageSource: types.MessageSource{Chat: sender, Sender: sender},
+			State:         types.ChatPresenceComposing,
+		})
+		api.eventHandler(&events.GroupInfo{JID: group, Name: &types.GroupName{Name: fmt.Sprintf("Group %d", i)}})
+		// The actions are left out, their getters are nil-safe.
+		api.eventHandler(&events.Archive{JID: sender})
+		api.eventHandler(&events.Pin{JID: sender})
+		api.eventHandler(&events.Contact{JID: sender})
+		api.eventHandler(&events.PushName{JID: sender, NewPushName: fmt.Sprintf("Push %d", i)})
+		api.eventHandler(&events.OfflineSyncCompleted{})
+		api.eventHandler(&events.KeepAliveTimeout{ErrorCount: i})
+	})
+	run(func(i int) {
+		call := fmt.Sprintf("CALL%d", i)
+		api.eventHandler(&events.CallOffer{BasicCallMeta: types.BasicCallMeta{From: contacts[0], CallID: call}})
+		api.eventHandler(&events.CallTerminate{BasicCallMeta: types.BasicCallMeta{From: contacts[0], CallID: call}})
+	})
+
+	// Sends as stored by the send handlers and the outbox.
+	run(func(i int) {
+		chat := contacts[i%len(contacts)]
+		api.appendMessage(MessageInfo{
+			ID:        fmt.Sprintf("OUT%d", i),
+			Timestamp: time.Now(),
+			Source:    MessageSource{Chat: chat.String(), Sender: me.String(), IsFromMe: true},
+			Content:   MessageContent{Text: "hi", Type: "text"},
+			Status:    MessageStatusSent,
+		})
+	})
+
+	// Clients reading and changing state.
+	run(func(i int) {
+		chat := contacts[i%len(contacts)].String()
+		serve(api.getMessages, http.MethodGet, "/messages", "", nil)
+		serve(api.getChatMessages, http.MethodGet, "/messages/"+chat, "", map[string]string{"chatId": chat})
+		serve(api.getChats, http.MethodGet, "/chats", "", nil)
+		serve(api.getPresence, http.MethodGet, "/presence/"+chat, "", map[string]string{"chatId": chat})
+		serve(api.getContacts, http.MethodGet, "/contacts", "", nil)
+		serve(api.getCalls, http.MethodGet, "/calls", "", nil)
+		serve(api.getStatuses, http.MethodGet, "/status", "", nil)
+		serve(api.searchMessages, http.MethodPost, "/messages/search", `{"query":"hello"}`, nil)
+		serve(api.getDiagnostics, http.MethodGet, "/diagnostics", "", nil)
+	})
+	run(func(i int) {
+		chat := contacts[i%len(contacts)].String()
+		serve(api.setChatName, http.MethodPut, "/chats/"+chat+"/name", fmt.Sprintf(`{"name":"Chat %d"}`, i), map[string]string{"chatId": chat})
+		serve(api.updateReadStatus, http.MethodPost, "/messages/read-status", fmt.Sprintf(`{"message_id":"IN%d","read":false}`, i), nil)
+		serve(api.deleteMessages, http.MethodDelete, "/messages?type=other", "", nil)
+		api.chatName(group.String())
+	})
+
+	wg.Wait()
+
+	if got := len(api.snapshotMessages()); got != 2*rounds {
+		t.Errorf("stored %d messages, want %d", got, 2*rounds)
+	}
+}
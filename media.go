@@ -0,0 +1,196 @@
+package main
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+)
+
+// MediaInfo describes the media attached to a message. The key, path and
+// hashes are what whatsmeow needs to download and verify the file later.
+type MediaInfo struct {
+	Mimetype      string `json:"mimetype,omitempty"`
+	FileLength    uint64 `json:"file_length,omitempty"`
+	Duration      uint32 `json:"duration,omitempty"`
+	Filename      string `json:"filename,omitempty"`
+	PTT           bool   `json:"ptt,omitempty"`
+	Codec         string `json:"codec,omitempty"`
+	Waveform      []int  `json:"waveform,omitempty"`
+	MediaKey      []byte `json:"media_key,omitempty"`
+	DirectPath    string `json:"direct_path,omitempty"`
+	FileSHA256    []byte `json:"file_sha256,omitempty"`
+	FileEncSHA256 []byte `json:"file_enc_sha256,omitempty"`
+
+	// ArchiveURL is where a voice note was archived to. With presigning
+	// on, responses carry a temporary URL for archiveKey instead.
+	ArchiveURL string `json:"archive_url,omitempty"`
+	archiveKey string
+}
+
+// mediaMessage is implemented by every downloadable media message proto.
+type mediaMessage interface {
+	GetMimetype() string
+	GetFileLength() uint64
+	GetMediaKey() []byte
+	GetDirectPath() string
+	GetFileSHA256() []byte
+	GetFileEncSHA256() []byte
+	GetContextInfo() *waE2E.ContextInfo
+}
+
+// audioCodec derives the codec of audio from its mimetype, which names it
+// explicitly for Ogg (e.g. "audio/ogg; codecs=opus") but only implies it
+// for other containers.
+func audioCodec(mimetype string) string {
+	mediaType, params, err := mime.ParseMediaType(mimetype)
+	if err != nil {
+		return ""
+	}
+	if codecs := params["codecs"]; codecs != "" {
+		return strings.ToLower(strings.TrimSpace(strings.Split(codecs, ",")[0]))
+	}
+	switch mediaType {
+	case "audio/mp4", "audio/aac", "audio/x-m4a":
+		return "aac"
+	case "audio/mpeg", "audio/mp3":
+		return "mp3"
+	case "audio/amr":
+		return "amr"
+	case "audio/ogg":
+		// WhatsApp only sends Opus in Ogg.
+		return "opus"
+	}
+	return ""
+}
+
+func newMediaInfo(m mediaMessage) *MediaInfo {
+	return &MediaInfo{
+		Mimetype:      m.GetMimetype(),
+		FileLength:    m.GetFileLength(),
+		MediaKey:      m.GetMediaKey(),
+		DirectPath:    m.GetDirectPath(),
+		FileSHA256:    m.GetFileSHA256(),
+		FileEncSHA256: m.GetFileEncSHA256(),
+	}
+}
+
+// extractMediaContent returns the content of a media message, or false if m
+// carries no media. Replies record the ID of the message they quote.
+func extractMediaContent(m *waE2E.Message) (MessageContent, bool) {
+	var content MessageContent
+	var media mediaMessage
+	switch {
+	case m.GetImageMessage() != nil:
+		image := m.GetImageMessage()
+		media = image
+		content = MessageContent{
+			Text:  image.GetCaption(),
+			Type:  "image",
+			Media: newMediaInfo(image),
+		}
+	case m.GetVideoMessage() != nil:
+		video := m.GetVideoMessage()
+		media = video
+		content = MessageContent{
+			Text:  video.GetCaption(),
+			Type:  "video",
+			Media: newMediaInfo(video),
+		}
+		content.Media.Duration = video.GetSeconds()
+	case m.GetAudioMessage() != nil:
+		audio := m.GetAudioMessage()
+		media = audio
+		content = MessageContent{
+			Type:  "audio",
+			Media: newMediaInfo(audio),
+		}
+		content.Media.Duration = audio.GetSeconds()
+		content.Media.PTT = audio.GetPTT()
+		content.Media.Codec = audioCodec(audio.GetMimetype())
+		for _, level := range audio.GetWaveform() {
+			content.Media.Waveform = append(content.Media.Waveform, int(level))
+		}
+	case m.GetDocumentMessage() != nil:
+		document := m.GetDocumentMessage()
+		media = document
+		content = MessageContent{
+			Text:  document.GetCaption(),
+			Type:  "document",
+			Media: newMediaInfo(document),
+		}
+		content.Media.Filename = document.GetFileName()
+	case m.GetStickerMessage() != nil:
+		sticker := m.GetStickerMessage()
+		media = sticker
+		content = MessageContent{
+			Type:  "sticker",
+			Media: newMediaInfo(sticker),
+		}
+	default:
+		return MessageContent{}, false
+	}
+	content.QuotedID = media.GetContextInfo().GetStanzaID()
+	return content, true
+}
+
+// downloadMedia downloads and decrypts the media of msg, responding with an
+// error if that fails. It returns false if a response has been written.
+// Expired media is requested from the sender's phone again, which makes
+// the request wait until it answers.
+func (api *WhatsAppAPI) downloadMedia(w http.ResponseWriter, r *http.Request, msg MessageInfo) ([]byte, bool) {
+	data, err := api.downloadWithRetry(r.Context(), msg)
+	if errors.Is(err, errMediaExpired) {
+		// WhatsApp purges media from its servers after a while, and the
+		// phone may no longer have it either.
+		http.Error(w, "Media has expired and is no longer available", http.StatusGone)
+		return nil, false
+	} else if err != nil {
+		api.requestLog(r).Errorf("Failed to download media of %s: %v", msg.ID, err)
+		http.Error(w, "Failed to download media", http.StatusInternalServerError)
+		return nil, false
+	}
+	return data, true
+}
+
+// getMedia downloads and decrypts the media of a stored message.
+func (api *WhatsAppAPI) getMedia(w http.ResponseWriter, r *http.Request) {
+	msg, ok := api.findMessage(mux.Vars(r)["messageId"])
+	if !ok {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+	if msg.raw == nil || msg.Content.Media == nil {
+		http.Error(w, "Message has no media", http.StatusBadRequest)
+		return
+	}
+	// Voice notes archived in their original format are fetched from the
+	// media store directly.
+	if presigned, ok := api.presignedArchiveURL(msg.Content.Media, "ogg"); ok {
+		http.Redirect(w, r, presigned, http.StatusTemporaryRedirect)
+		return
+	}
+	if !api.requireConnected(w) {
+		return
+	}
+
+	data, ok := api.downloadMedia(w, r, msg)
+	if !ok {
+		return
+	}
+
+	mimetype := msg.Content.Media.Mimetype
+	if mimetype == "" {
+		mimetype = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", mimetype)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	if msg.Content.Media.Filename != "" {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": msg.Content.Media.Filename}))
+	}
+	w.Write(data)
+}
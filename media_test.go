@@ -0,0 +1,102 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestExtractMediaContent(t *testing.T) {
+	key := []byte{1, 2, 3}
+	tests := []struct {
+		name string
+		evt  *events.Message
+		want MessageContent
+	}{
+		{
+			name: "image with caption",
+			evt: &events.Message{Message: &waE2E.Message{ImageMessage: &waE2E.ImageMessage{
+				Caption:    proto.String("look"),
+				Mimetype:   proto.String("image/jpeg"),
+				FileLength: proto.Uint64(1234),
+				MediaKey:   key,
+				DirectPath: proto.String("/v/image"),
+			}}},
+			want: MessageContent{Text: "look", Type: "image", Media: &MediaInfo{
+				Mimetype: "image/jpeg", FileLength: 1234, MediaKey: key, DirectPath: "/v/image",
+			}},
+		},
+		{
+			name: "video",
+			evt: &events.Message{Message: &waE2E.Message{VideoMessage: &waE2E.VideoMessage{
+				Mimetype: proto.String("video/mp4"),
+				Seconds:  proto.Uint32(12),
+			}}},
+			want: MessageContent{Type: "video", Media: &MediaInfo{Mimetype: "video/mp4", Duration: 12}},
+		},
+		{
+			name: "voice note",
+			evt: &events.Message{Message: &waE2E.Message{AudioMessage: &waE2E.AudioMessage{
+				Mimetype: proto.String("audio/ogg; codecs=opus"),
+				Seconds:  proto.Uint32(7),
+				PTT:      proto.Bool(true),
+				Waveform: []byte{0, 50, 99},
+			}}},
+			want: MessageContent{Type: "audio", Media: &MediaInfo{
+				Mimetype: "audio/ogg; codecs=opus", Duration: 7, PTT: true, Codec: "opus", Waveform: []int{0, 50, 99},
+			}},
+		},
+		{
+			name: "audio file",
+			evt: &events.Message{Message: &waE2E.Message{AudioMessage: &waE2E.AudioMessage{
+				Mimetype: proto.String("audio/mp4"),
+			}}},
+			want: MessageContent{Type: "audio", Media: &MediaInfo{Mimetype: "audio/mp4", Codec: "aac"}},
+		},
+		{
+			name: "document reply",
+			evt: &events.Message{Message: &waE2E.Message{DocumentMessage: &waE2E.DocumentMessage{
+				Caption:     proto.String("the report"),
+				FileName:    proto.String("report.pdf"),
+				Mimetype:    proto.String("application/pdf"),
+				ContextInfo: &waE2E.ContextInfo{StanzaID: proto.String("QUOTED")},
+			}}},
+			want: MessageContent{Text: "the report", Type: "document", QuotedID: "QUOTED", Media: &MediaInfo{
+				Mimetype: "application/pdf", Filename: "report.pdf",
+			}},
+		},
+		{
+			name: "sticker",
+			evt: &events.Message{Message: &waE2E.Message{StickerMessage: &waE2E.StickerMessage{
+				Mimetype: proto.String("image/webp"),
+			}}},
+			want: MessageContent{Type: "sticker", Media: &MediaInfo{Mimetype: "image/webp"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := extractMediaContent(tt.evt.Message)
+			if !ok {
+				t.Fatal("extractMediaContent() reported no media")
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("extractMediaContent() = %+v (media %+v), want %+v (media %+v)", got, got.Media, tt.want, tt.want.Media)
+			}
+		})
+	}
+}
+
+func TestExtractMediaContentWithoutMedia(t *testing.T) {
+	for _, m := range []*waE2E.Message{
+		nil,
+		{Conversation: proto.String("hello")},
+		{ExtendedTextMessage: &waE2E.ExtendedTextMessage{Text: proto.String("hello")}},
+	} {
+		if content, ok := extractMediaContent(m); ok {
+			t.Errorf("extractMediaContent(%v) = %+v, want no media", m, content)
+		}
+	}
+}
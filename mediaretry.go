@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/proto/waMmsRetry"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
+// mediaRetryTimeout is how long to wait for the sender's phone to upload
+// expired media again. It only answers while it is online.
+const mediaRetryTimeout = 30 * time.Second
+
+// errMediaExpired is returned when media is gone from WhatsApp's servers
+// and the sender's phone didn't upload it again.
+var errMediaExpired = errors.New("media has expired")
+
+func isMediaGone(err error) bool {
+	return errors.Is(err, whatsmeow.ErrMediaDownloadFailedWith404) ||
+		errors.Is(err, whatsmeow.ErrMediaDownloadFailedWith410)
+}
+
+// withDirectPath returns a copy of m with its media pointed at a new path,
+// as given by a media retry notification.
+func withDirectPath(m *waE2E.Message, path string) *waE2E.Message {
+	m = proto.Clone(m).(*waE2E.Message)
+	switch {
+	case m.GetImageMessage() != nil:
+		m.ImageMessage.DirectPath = proto.String(path)
+	case m.GetVideoMessage() != nil:
+		m.VideoMessage.DirectPath = proto.String(path)
+	case m.GetAudioMessage() != nil:
+		m.AudioMessage.DirectPath = proto.String(path)
+	case m.GetDocumentMessage() != nil:
+		m.DocumentMessage.DirectPath = proto.String(path)
+	case m.GetStickerMessage() != nil:
+		m.StickerMessage.DirectPath = proto.String(path)
+	}
+	return m
+}
+
+// handleMediaRetry passes a media retry notification on to the downloads
+// waiting for it.
+func (api *WhatsAppAPI) handleMediaRetry(evt *events.MediaRetry) {
+	api.mediaRetryMu.Lock()
+	waiters := api.mediaRetries[evt.MessageID]
+	delete(api.mediaRetries, evt.MessageID)
+	api.mediaRetryMu.Unlock()
+	for _, waiter := range waiters {
+		waiter <- evt
+	}
+}
+
+// requestMediaRetry asks the sender's phone to upload the media of msg
+// again and waits for the notification with its new path.
+func (api *WhatsAppAPI) requestMediaRetry(ctx context.Context, msg MessageInfo) (string, error) {
+	chat, err := types.ParseJID(msg.Source.Chat)
+	if err != nil {
+		return "", err
+	}
+	sender, err := types.ParseJID(msg.Source.Sender)
+	if err != nil {
+		return "", err
+	}
+	mediaKey := msg.Content.Media.MediaKey
+
+	waiter := make(chan *events.MediaRetry, 1)
+	api.mediaRetryMu.Lock()
+	api.mediaRetries[msg.ID] = append(api.mediaRetries[msg.ID], waiter)
+	api.mediaRetryMu.Unlock()
+	defer func() {
+		api.mediaRetryMu.Lock()
+		defer api.mediaRetryMu.Unlock()
+		waiters := api.mediaRetries[msg.ID]
+		for i, w := range waiters {
+			if w == waiter {
+				api.mediaRetries[msg.ID] = append(waiters[:i], waiters[i+1:]...)
+				break
+			}
+		}
+		if len(api.mediaRetries[msg.ID]) == 0 {
+			delete(api.mediaRetries, msg.ID)
+		}
+	}()
+
+	info := &types.MessageInfo{
+		ID:        msg.ID,
+		Timestamp: msg.Timestamp,
+		MessageSource: types.MessageSource{
+			Chat:     chat,
+			Sender:   sender,
+			IsFromMe: msg.Source.IsFromMe,
+			IsGroup:  msg.Source.IsGroup,
+		},
+	}
+	if err := api.client.SendMediaRetryReceipt(info, mediaKey); err != nil {
+		return "", fmt.Errorf("failed to send retry receipt: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, mediaRetryTimeout)
+	defer cancel()
+	var evt *events.MediaRetry
+	select {
+	case evt = <-waiter:
+	case <-ctx.Done():
+		return "", errMediaExpired
+	}
+
+	notif, err := whatsmeow.DecryptMediaRetryNotification(evt, mediaKey)
+	if errors.Is(err, whatsmeow.ErrMediaNotAvailableOnPhone) {
+		return "", errMediaExpired
+	} else if err != nil {
+		return "", err
+	}
+	if notif.GetResult() != waMmsRetry.MediaRetryNotification_SUCCESS {
+		return "", fmt.Errorf("%w: phone answered %s", errMediaExpired, notif.GetResult())
+	}
+	return notif.GetDirectPath(), nil
+}
+
+// downloadWithRetry downloads the media of msg. Media WhatsApp has purged
+// from its servers is requested again from the sender's phone, and the new
+// path is kept with the message for later downloads.
+func (api *WhatsAppAPI) downloadWithRetry(ctx context.Context, msg MessageInfo) ([]byte, error) {
+	data, err := api.client.DownloadAny(msg.raw)
+	if !isMediaGone(err) || msg.Content.Media == nil || len(msg.Content.Media.MediaKey) == 0 {
+		return data, err
+	}
+
+	api.log.Infof("Media of %s has expired, asking the sender to upload it again", msg.ID)
+	path, err := api.requestMediaRetry(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+	// The proto and media info are shared with copies of the message
+	// handed out earlier, so they are replaced rather than changed.
+	raw := withDirectPath(msg.raw, path)
+	api.updateMessage(msg.ID, func(msg *MessageInfo) {
+		msg.raw = raw
+		if msg.Content.Media != nil {
+			media := *msg.Content.Media
+			media.DirectPath = path
+			msg.Content.Media = &media
+		}
+	})
+	data, err = api.client.DownloadAny(raw)
+	if isMediaGone(err) {
+		return nil, errMediaExpired
+	}
+	return data, err
+}
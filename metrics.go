@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// counterVec is a monotonically increasing counter partitioned by a single
+// label. Label values must come from a small fixed set, such as message
+// types, to keep the number of series bounded.
+type counterVec struct {
+	mu     sync.Mutex
+	values map[string]uint64
+}
+
+func newCounterVec() *counterVec {
+	return &counterVec{values: make(map[string]uint64)}
+}
+
+func (c *counterVec) inc(label string) {
+	c.mu.Lock()
+	c.values[label]++
+	c.mu.Unlock()
+}
+
+func (c *counterVec) snapshot() map[string]uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	values := make(map[string]uint64, len(c.values))
+	for k, v := range c.values {
+		values[k] = v
+	}
+	return values
+}
+
+type apiMetrics struct {
+	messagesReceived *counterVec
+	messagesSent     *counterVec
+	qrCodes          *counterVec
+	pairings         *counterVec
+}
+
+func newAPIMetrics() *apiMetrics {
+	return &apiMetrics{
+		messagesReceived: newCounterVec(),
+		messagesSent:     newCounterVec(),
+		qrCodes:          newCounterVec(),
+		pairings:         newCounterVec(),
+	}
+}
+
+// writeMetric writes one metric in the Prometheus text exposition format.
+func writeMetric(w io.Writer, name, kind, help, label string, values map[string]uint64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if label == "" {
+			fmt.Fprintf(w, "%s %d\n", name, values[k])
+		} else {
+			fmt.Fprintf(w, "%s{%s=%q} %d\n", name, label, k, values[k])
+		}
+	}
+}
+
+// getMetrics exposes counters and gauges for Prometheus to scrape.
+func (api *WhatsAppAPI) getMetrics(w http.ResponseWriter, r *http.Request) {
+	status := map[string]uint64{"connected": 0, "disconnected": 0, "logged_out": 0}
+	status[api.getConnectionStatus()] = 1
+
+	authenticated := map[string]uint64{"": 0}
+	if api.client.Store.ID != nil {
+		authenticated[""] = 1
+	}
+
+	outbox := map[string]uint64{OutboxPending: 0, OutboxFailed: 0}
+	api.outbox.mu.Lock()
+	for _, entry := range api.outbox.entries {
+		outbox[entry.Status]++
+	}
+	api.outbox.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writeMetric(w, "whatsapp_authenticated", "gauge",
+		"Whether a WhatsApp account is linked.", "", authenticated)
+	writeMetric(w, "whatsapp_connection_status", "gauge",
+		"Current connection status, 1 for the active status.", "status", status)
+	writeMetric(w, "whatsapp_messages_received_total", "counter",
+		"Messages received by type.", "type", api.metrics.messagesReceived.snapshot())
+	writeMetric(w, "whatsapp_messages_sent_total", "counter",
+		"Messages accepted by WhatsApp by type.", "type", api.metrics.messagesSent.snapshot())
+	writeMetric(w, "whatsapp_outbox_entries", "gauge",
+		"Outbound messages not yet accepted by WhatsApp by status.", "status", outbox)
+	writeMetric(w, "whatsapp_qr_codes_total", "counter",
+		"QR codes issued for pairing.", "", api.metrics.qrCodes.snapshot())
+	writeMetric(w, "whatsapp_pairings_total", "counter",
+		"Pairing attempts by result.", "result", api.metrics.pairings.snapshot())
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	OutboxPending = "pending"
+	OutboxFailed  = "failed"
+)
+
+const (
+	// maxOutboxAttempts is how often a queued message is tried before it is
+	// marked failed and left for a manual retry.
+	maxOutboxAttempts = 5
+	// outboxRetryBackoff is the wait after the first failed attempt of a
+	// queued message; it doubles with every further attempt.
+	outboxRetryBackoff = 5 * time.Second
+	// outboxPollInterval is how often the queue is checked for messages
+	// that are due, besides whenever the client connects.
+	outboxPollInterval = 5 * time.Second
+	outboxSendTimeout  = 30 * time.Second
+)
+
+type OutboxAttempt struct {
+	Timestamp time.Time `json:"timestamp"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// OutboxEntry is an outbound message that hasn't been accepted by WhatsApp
+// yet. Entries are dropped from the outbox as soon as a send succeeds.
+// Queued entries have NextAttemptAt set and are sent in the background,
+// others were sent directly by a request and are only retried manually.
+type OutboxEntry struct {
+	ID            string          `json:"id"`
+	Chat          string          `json:"chat"`
+	Status        string          `json:"status"`
+	CreatedAt     time.Time       `json:"created_at"`
+	Attempts      []OutboxAttempt `json:"attempts"`
+	LastError     string          `json:"last_error,omitempty"`
+	NextAttemptAt *time.Time      `json:"next_attempt_at,omitempty"`
+
+	to      types.JID
+	message *waE2E.Message
+	// sending is set while an attempt is in flight.
+	sending bool
+}
+
+type OutboxResponse struct {
+	Entries []OutboxEntry `json:"entries"`
+}
+
+// outbox holds unsent messages. Entries are also kept in the database, so
+// that queued messages survive a restart.
+type outbox struct {
+	mu      sync.Mutex
+	entries []*OutboxEntry
+	db      *sql.DB
+	// wake prompts the queue worker to look for due entries.
+	wake chan struct{}
+}
+
+func newOutbox(db *sql.DB) (*outbox, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS outbox (
+		id              TEXT PRIMARY KEY,
+		chat            TEXT NOT NULL,
+		message         BLOB NOT NULL,
+		status          TEXT NOT NULL,
+		created_at      INTEGER NOT NULL,
+		attempts        TEXT NOT NULL,
+		last_error      TEXT NOT NULL,
+		next_attempt_at INTEGER
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	o := &outbox{entries: make([]*OutboxEntry, 0), db: db, wake: make(chan struct{}, 1)}
+	return o, o.load()
+}
+
+// load restores the entries saved before a restart. A direct send that was
+// in flight when the service stopped can't be known to have gone out, so it
+// is marked failed.
+func (o *outbox) load() error {
+	rows, err := o.db.Query(`SELECT id, chat, message, status, created_at, attempts, last_error, next_attempt_at
+		FROM outbox ORDER BY created_at`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		entry := &OutboxEntry{}
+		var message []byte
+		var attempts string
+		var createdAt int64
+		var nextAttempt sql.NullInt64
+		err := rows.Scan(&entry.ID, &entry.Chat, &message, &entry.Status, &createdAt, &attempts, &entry.LastError, &nextAttempt)
+		if err != nil {
+			return err
+		}
+		entry.CreatedAt = time.Unix(0, createdAt)
+		if nextAttempt.Valid {
+			next := time.Unix(0, nextAttempt.Int64)
+			entry.NextAttemptAt = &next
+		}
+		if entry.to, err = types.ParseJID(entry.Chat); err != nil {
+			return err
+		}
+		entry.message = &waE2E.Message{}
+		if err := proto.Unmarshal(message, entry.message); err != nil {
+			return err
+		}
+		if err := json.Unmarshal([]byte(attempts), &entry.Attempts); err != nil {
+			return err
+		}
+		if entry.Status == OutboxPending && entry.NextAttemptAt == nil {
+			entry.Status = OutboxFailed
+			entry.LastError = "interrupted by a restart"
+		}
+		o.entries = append(o.entries, entry)
+	}
+	return rows.Err()
+}
+
+// save persists entry. Callers must hold o.mu.
+func (o *outbox) save(entry *OutboxEntry) error {
+	message, err := proto.Marshal(entry.message)
+	if err != nil {
+		return err
+	}
+	attempts, err := json.Marshal(entry.Attempts)
+	if err != nil {
+		return err
+	}
+	var nextAttempt sql.NullInt64
+	if entry.NextAttemptAt != nil {
+		nextAttempt = sql.NullInt64{Int64: entry.NextAttemptAt.UnixNano(), Valid: true}
+	}
+	_, err = o.db.Exec(`INSERT OR REPLACE INTO outbox (id, chat, message, status, created_at, attempts, last_error, next_attempt_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		entry.ID, entry.Chat, message, entry.Status, entry.CreatedAt.UnixNano(), string(attempts), entry.LastError, nextAttempt)
+	return err
+}
+
+func (o *outbox) find(id string) (int, *OutboxEntry) {
+	for i, entry := range o.entries {
+		if entry.ID == id {
+			return i, entry
+		}
+	}
+	return -1, nil
+}
+
+func (o *outbox) add(entry *OutboxEntry) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.entries = append(o.entries, entry)
+	return o.save(entry)
+}
+
+// removeLocked drops an entry. Callers must hold o.mu.
+func (o *outbox) removeLocked(id string) (bool, error) {
+	i, entry := o.find(id)
+	if entry == nil {
+		return false, nil
+	}
+	o.entries = append(o.entries[:i], o.entries[i+1:]...)
+	_, err := o.db.Exec(`DELETE FROM outbox WHERE id = ?`, id)
+	return true, err
+}
+
+func (o *outbox) remove(id string) (bool, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.removeLocked(id)
+}
+
+func (o *outbox) clear() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.entries = make([]*OutboxEntry, 0)
+	_, err := o.db.Exec(`DELETE FROM outbox`)
+	return err
+}
+
+// notify wakes the queue worker without blocking.
+func (o *outbox) notify() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (api *WhatsAppAPI) newOutboxEntry(to types.JID, msg *waE2E.Message) *OutboxEntry {
+	return &OutboxEntry{
+		ID:        api.client.GenerateMessageID(),
+		Chat:      to.String(),
+		Status:    OutboxPending,
+		CreatedAt: time.Now(),
+		Attempts:  make([]OutboxAttempt, 0),
+		to:        to,
+		message:   msg,
+	}
+}
+
+// sendOutbound sends a message through the outbox so that failures are kept
+// around for inspection and manual retry instead of being lost.
+func (api *WhatsAppAPI) sendOutbound(ctx context.Context, to types.JID, msg *waE2E.Message) (whatsmeow.SendResponse, error) {
+	entry := api.newOutboxEntry(to, msg)
+	if err := api.outbox.add(entry); err != nil {
+		// The message can still be sent; only a failure wouldn't survive
+		// a restart.
+		api.log.Errorf("Failed to save outbound message %s: %v", entry.ID, err)
+	}
+	return api.attemptOutbound(ctx, entry)
+}
+
+// queueOutbound adds a message to the outbox for the queue worker to send,
+// e.g. while the client is reconnecting. Queued messages are retried with
+// backoff on failure.
+func (api *WhatsAppAPI) queueOutbound(to types.JID, msg *waE2E.Message) (*OutboxEntry, error) {
+	entry := api.newOutboxEntry(to, msg)
+	entry.NextAttemptAt = &entry.CreatedAt
+	if err := api.outbox.add(entry); err != nil {
+		api.outbox.remove(entry.ID)
+		return nil, err
+	}
+	api.outbox.notify()
+	return entry, nil
+}
+
+// attemptOutbound sends an entry once. Callers other than sendOutbound must
+// have claimed the entry by setting its sending flag.
+func (api *WhatsAppAPI) attemptOutbound(ctx context.Context, entry *OutboxEntry) (whatsmeow.SendResponse, error) {
+	api.outbox.mu.Lock()
+	entry.sending = true
+	entry.Status = OutboxPending
+	api.outbox.mu.Unlock()
+
+	// The entry ID doubles as the WhatsApp message ID, so a retry after an
+	// ambiguous failure can't produce a duplicate on the recipient's side.
+	resp, err := api.client.SendMessage(ctx, entry.to, entry.message, whatsmeow.SendRequestExtra{ID: entry.ID})
+
+	api.outbox.mu.Lock()
+	defer api.outbox.mu.Unlock()
+	entry.sending = false
+
+	attempt := OutboxAttempt{Timestamp: time.Now()}
+	if err != nil {
+		attempt.Error = err.Error()
+		entry.LastError = err.Error()
+		entry.Attempts = append(entry.Attempts, attempt)
+		if entry.NextAttemptAt != nil && len(entry.Attempts) < maxOutboxAttempts {
+			next := attempt.Timestamp.Add(outboxRetryBackoff << (len(entry.Attempts) - 1))
+			entry.NextAttemptAt = &next
+		} else {
+			entry.Status = OutboxFailed
+			entry.NextAttemptAt = nil
+		}
+		if saveErr := api.outbox.save(entry); saveErr != nil {
+			api.log.Errorf("Failed to save outbound message %s: %v", entry.ID, saveErr)
+		}
+		api.log.Warnf("Outbound message %s to %s failed (attempt %d): %v", entry.ID, entry.Chat, len(entry.Attempts), err)
+		return resp, err
+	}
+
+	api.quality.trackSent(resp.ID, resp.Timestamp)
+	api.activity.touch(time.Now())
+	api.metrics.messagesSent.inc(extractMessageContent(entry.message).Type)
+	if _, err := api.outbox.removeLocked(entry.ID); err != nil {
+		api.log.Errorf("Failed to remove sent message %s from the outbox: %v", entry.ID, err)
+	}
+	return resp, nil
+}
+
+// storeSent adds a message sent from the outbox to the history. Direct
+// sends store their messages themselves.
+func (api *WhatsAppAPI) storeSent(entry *OutboxEntry, resp whatsmeow.SendResponse) {
+	sent := MessageInfo{
+		ID:        resp.ID,
+		Timestamp: resp.Timestamp,
+		Source: MessageSource{
+			Chat:     entry.Chat,
+			Sender:   api.client.Store.ID.ToNonAD().String(),
+			IsFromMe: true,
+			IsGroup:  entry.to.Server == types.GroupServer,
+		},
+		Content: extractMessageContent(entry.message),
+		IsRead:  true,
+		Status:  MessageStatusSent,
+	}
+	if sent.Content.Media != nil {
+		sent.raw = entry.message
+	}
+	api.appendMessage(sent)
+}
+
+// runOutbox sends queued messages while the client is connected, one at a
+// time and within the send rate limit, until stop is closed.
+func (api *WhatsAppAPI) runOutbox(stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	ticker := time.NewTicker(outboxPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-api.outbox.wake:
+		}
+
+		for ctx.Err() == nil && api.client.IsConnected() && api.client.IsLoggedIn() {
+			entry := api.nextQueued()
+			if entry == nil {
+				break
+			}
+			if err := api.sendLimit.wait(ctx); err != nil {
+				api.outbox.mu.Lock()
+				entry.sending = false
+				api.outbox.mu.Unlock()
+				break
+			}
+			sendCtx, cancelSend := context.WithTimeout(ctx, outboxSendTimeout)
+			resp, err := api.attemptOutbound(sendCtx, entry)
+			cancelSend()
+			if err == nil {
+				api.storeSent(entry, resp)
+			}
+		}
+	}
+}
+
+// nextQueued claims the oldest queued entry that is due, if any.
+func (api *WhatsAppAPI) nextQueued() *OutboxEntry {
+	api.outbox.mu.Lock()
+	defer api.outbox.mu.Unlock()
+	now := time.Now()
+	for _, entry := range api.outbox.entries {
+		if entry.Status == OutboxPending && !entry.sending && entry.NextAttemptAt != nil && !entry.NextAttemptAt.After(now) {
+			entry.sending = true
+			return entry
+		}
+	}
+	return nil
+}
+
+func (api *WhatsAppAPI) getOutbox(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status != "" && status != OutboxPending && status != OutboxFailed {
+		http.Error(w, "Status must be pending or failed", http.StatusBadRequest)
+		return
+	}
+
+	api.outbox.mu.Lock()
+	response := OutboxResponse{Entries: make([]OutboxEntry, 0, len(api.outbox.entries))}
+	for _, entry := range api.outbox.entries {
+		if status == "" || entry.Status == status {
+			snapshot := *entry
+			snapshot.Attempts = append([]OutboxAttempt(nil), entry.Attempts...)
+			response.Entries = append(response.Entries, snapshot)
+		}
+	}
+	api.outbox.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (api *WhatsAppAPI) retryOutbox(w http.ResponseWriter, r *http.Request) {
+	if !api.requireConnected(w) {
+		return
+	}
+
+	vars := mux.Vars(r)
+	api.outbox.mu.Lock()
+	_, entry := api.outbox.find(vars["entryId"])
+	sending := entry != nil && entry.sending
+	if entry != nil {
+		entry.sending = true
+	}
+	api.outbox.mu.Unlock()
+
+	if entry == nil {
+		http.Error(w, "Outbox entry not found", http.StatusNotFound)
+		return
+	} else if sending {
+		http.Error(w, "Entry is already being sent", http.StatusConflict)
+		return
+	}
+	// The send is only reserved once the entry is known to exist, so
+	// unknown IDs don't use up the rate limit.
+	if !api.allowSend(w) {
+		api.outbox.mu.Lock()
+		entry.sending = false
+		api.outbox.mu.Unlock()
+		return
+	}
+
+	resp, err := api.attemptOutbound(r.Context(), entry)
+	if err != nil {
+		http.Error(w, "Retry failed: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	api.storeSent(entry, resp)
+
+	response := StatusResponse{ID: resp.ID, Timestamp: resp.Timestamp}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (api *WhatsAppAPI) cancelOutbox(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	found, err := api.outbox.remove(vars["entryId"])
+	if err != nil {
+		api.requestLog(r).Errorf("Failed to remove outbox entry: %v", err)
+		http.Error(w, "Failed to cancel outbox entry", http.StatusInternalServerError)
+		return
+	} else if !found {
+		http.Error(w, "Outbox entry not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
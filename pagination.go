@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxMessagePageSize caps the limit a client can request in one page.
+const maxMessagePageSize = 500
+
+// MessageQuery selects a page of messages. Before and After are cursors that
+// hold either a message ID or an RFC3339 timestamp.
+type MessageQuery struct {
+	Limit  int
+	Before string
+	After  string
+}
+
+// messageCursor is a position in the (timestamp, id) ordering. A cursor
+// built from a bare timestamp has no id and excludes every message sent at
+// exactly that time.
+type messageCursor struct {
+	timestamp time.Time
+	id        string
+}
+
+func parseMessageQuery(r *http.Request) (MessageQuery, error) {
+	query := r.URL.Query()
+	q := MessageQuery{
+		Before: query.Get("before"),
+		After:  query.Get("after"),
+	}
+	if raw := query.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > maxMessagePageSize {
+			return q, errors.New("limit must be between 1 and " + strconv.Itoa(maxMessagePageSize))
+		}
+		q.Limit = limit
+	}
+	return q, nil
+}
+
+func (api *WhatsAppAPI) resolveCursor(raw string) (*messageCursor, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	if ts, err := time.Parse(time.RFC3339, raw); err == nil {
+		return &messageCursor{timestamp: ts}, nil
+	}
+	if msg, ok := api.findMessage(raw); ok {
+		return &messageCursor{timestamp: msg.Timestamp, id: msg.ID}, nil
+	}
+	return nil, errors.New("cursor must be a message ID or an RFC3339 timestamp")
+}
+
+// compare orders msg relative to the cursor, returning -1 if msg comes
+// first, 1 if it comes after and 0 if it is at the cursor itself.
+func (c messageCursor) compare(msg MessageInfo) int {
+	if msg.Timestamp.Before(c.timestamp) {
+		return -1
+	}
+	if msg.Timestamp.After(c.timestamp) {
+		return 1
+	}
+	if c.id == "" {
+		return 0
+	}
+	return strings.Compare(msg.ID, c.id)
+}
+
+// paginateMessages returns the page of messages selected by q in
+// chronological order, along with the cursor for the next page if more
+// messages remain. Without a limit every matching message is returned.
+//
+// Pages are taken from the newest end unless only After is set, so the next
+// cursor is meant to be passed back as before, or as after when paging
+// forward.
+func (api *WhatsAppAPI) paginateMessages(messages []MessageInfo, q MessageQuery) ([]MessageInfo, string, error) {
+	before, err := api.resolveCursor(q.Before)
+	if err != nil {
+		return nil, "", err
+	}
+	after, err := api.resolveCursor(q.After)
+	if err != nil {
+		return nil, "", err
+	}
+
+	page := make([]MessageInfo, 0, len(messages))
+	for _, msg := range messages {
+		if before != nil && before.compare(msg) >= 0 {
+			continue
+		}
+		if after != nil && after.compare(msg) <= 0 {
+			continue
+		}
+		page = append(page, msg)
+	}
+	// Messages sharing a timestamp are ordered by ID so that cursors are
+	// stable between requests.
+	sort.SliceStable(page, func(i, j int) bool {
+		if !page[i].Timestamp.Equal(page[j].Timestamp) {
+			return page[i].Timestamp.Before(page[j].Timestamp)
+		}
+		return page[i].ID < page[j].ID
+	})
+
+	if q.Limit == 0 || len(page) <= q.Limit {
+		return page, "", nil
+	}
+	if after != nil && before == nil {
+		page = page[:q.Limit]
+		return page, page[len(page)-1].ID, nil
+	}
+	page = page[len(page)-q.Limit:]
+	return page, page[0].ID, nil
+}
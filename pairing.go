@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+const pairingStateKey = "pairing_state"
+
+// PairingState records an unfinished QR or phone pairing so that a restart
+// doesn't silently lose track of it.
+type PairingState struct {
+	Method    string    `json:"method"`
+	Phone     string    `json:"phone,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+	// RestartRequired is set when the flow couldn't be resumed after a
+	// restart, e.g. because the issued QR or pair code is no longer valid.
+	RestartRequired bool   `json:"restart_required"`
+	Detail          string `json:"detail,omitempty"`
+}
+
+// normalizePhoneNumber turns the ways people write a phone number, such as
+// "+1 (555) 123-4567", "0015551234567" or "15551234567@s.whatsapp.net",
+// into the international digits WhatsApp expects.
+func normalizePhoneNumber(raw string) (string, error) {
+	phone := strings.TrimSpace(raw)
+	phone, _, _ = strings.Cut(phone, "@")
+	phone = strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '-', '.', '(', ')', '/':
+			return -1
+		}
+		return r
+	}, phone)
+	if rest, ok := strings.CutPrefix(phone, "+"); ok {
+		phone = rest
+	} else if rest, ok := strings.CutPrefix(phone, "00"); ok {
+		phone = rest
+	}
+
+	if phone == "" {
+		return "", errors.New("Phone number is required")
+	}
+	for _, r := range phone {
+		if r < '0' || r > '9' {
+			return "", errors.New("Phone number may only contain digits, spaces, dashes, dots and parentheses after an optional +")
+		}
+	}
+	if phone[0] == '0' {
+		return "", errors.New("Phone number must include the country code")
+	}
+	// E.164 allows at most 15 digits; the shortest numbers in use have 7.
+	if len(phone) < 7 || len(phone) > 15 {
+		return "", errors.New("Phone number must have between 7 and 15 digits including the country code")
+	}
+	return phone, nil
+}
+
+func (api *WhatsAppAPI) startPairing(method, phone string) {
+	state := &PairingState{
+		Method:    method,
+		Phone:     phone,
+		StartedAt: time.Now(),
+	}
+	api.setPairing(state)
+	if err := api.settings.save(pairingStateKey, state); err != nil {
+		api.log.Errorf("Failed to save pairing state: %v", err)
+	}
+}
+
+func (api *WhatsAppAPI) finishPairing() {
+	api.setPairing(nil)
+	if err := api.settings.save(pairingStateKey, nil); err != nil {
+		api.log.Errorf("Failed to clear pairing state: %v", err)
+	}
+}
+
+func (api *WhatsAppAPI) setPairing(state *PairingState) {
+	api.pairingMu.Lock()
+	api.pairing = state
+	api.pairingMu.Unlock()
+}
+
+// pairingSnapshot returns a copy of the pairing in progress, or nil.
+func (api *WhatsAppAPI) pairingSnapshot() *PairingState {
+	api.pairingMu.Lock()
+	defer api.pairingMu.Unlock()
+	if api.pairing == nil {
+		return nil
+	}
+	state := *api.pairing
+	return &state
+}
+
+// restorePairing loads a pairing that was in progress when the service last
+// stopped. Codes issued before the restart are tied to the old connection,
+// so clients are told to scan a fresh QR code or request a new pair code.
+func (api *WhatsAppAPI) restorePairing() {
+	var state *PairingState
+	if _, err := api.settings.load(pairingStateKey, &state); err != nil {
+		api.log.Errorf("Failed to load pairing state: %v", err)
+		return
+	}
+	if state == nil {
+		return
+	}
+	if api.client.Store.ID != nil {
+		// Pairing completed just before the restart.
+		api.finishPairing()
+		return
+	}
+
+	state.RestartRequired = true
+	switch state.Method {
+	case "phone":
+		state.Detail = "pairing was interrupted by a restart; request a new pair code"
+	default:
+		state.Detail = "pairing was interrupted by a restart; scan the new QR code"
+	}
+	api.setPairing(state)
+	api.log.Warnf("Pairing via %s started at %s was interrupted: %s", state.Method, state.StartedAt.Format(time.RFC3339), state.Detail)
+}
+
+// connectForQR connects an unpaired client and feeds the QR codes it issues
+// to setQR.
+func (api *WhatsAppAPI) connectForQR() error {
+	qrChan, err := api.client.GetQRChannel(context.Background())
+	if err != nil {
+		return err
+	}
+	if err := api.connect(context.Background()); err != nil {
+		return err
+	}
+	go api.consumeQR(qrChan)
+	return nil
+}
+
+// handleLoggedOut is called when the device was unlinked, e.g. from the
+// phone. The dead session is cleaned up and a new QR pairing is started so
+// clients can re-pair without restarting the service.
+func (api *WhatsAppAPI) handleLoggedOut(evt *events.LoggedOut) {
+	api.log.Warnf("Logged out (reason: %s), a new pairing is required", evt.Reason)
+	api.stopReconnect()
+	api.setQR("")
+	api.setConnectionStatus("logged_out")
+	api.startPairing("qr", "")
+
+	// The event is dispatched from the connection being torn down, so the
+	// new one is set up once that has finished.
+	go func() {
+		api.client.Disconnect()
+		// whatsmeow usually deletes the device itself, but not for every
+		// kind of logout.
+		if api.client.Store.ID != nil {
+			if err := api.client.Store.Delete(context.Background()); err != nil {
+				api.log.Errorf("Failed to delete logged out device: %v", err)
+			}
+		}
+		if err := api.connectForQR(); err != nil {
+			api.log.Errorf("Failed to start QR pairing after logout: %v", err)
+		}
+	}()
+}
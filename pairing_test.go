@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestNormalizePhoneNumber(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    string
+		wantErr bool
+	}{
+		{raw: "15551234567", want: "15551234567"},
+		{raw: "+15551234567", want: "15551234567"},
+		{raw: "+1 (555) 123-4567", want: "15551234567"},
+		{raw: "0015551234567", want: "15551234567"},
+		{raw: "00 49 30.1234567", want: "49301234567"},
+		{raw: " 15551234567@s.whatsapp.net ", want: "15551234567"},
+		{raw: "", wantErr: true},
+		{raw: "+", wantErr: true},
+		{raw: "555-CALL-NOW", wantErr: true},
+		{raw: "++15551234567", wantErr: true},
+		{raw: "05551234567", wantErr: true},
+		{raw: "123456", wantErr: true},
+		{raw: "1234567890123456", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := normalizePhoneNumber(tt.raw)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("normalizePhoneNumber(%q) = %q, want an error", tt.raw, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("normalizePhoneNumber(%q) = %q, %v, want %q", tt.raw, got, err, tt.want)
+		}
+	}
+}
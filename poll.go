@@ -0,0 +1,392 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// maxPollOptions is the most options WhatsApp shows in a poll.
+const maxPollOptions = 12
+
+// PollInfo describes a poll. SelectableCount is 1 for single choice polls and
+// 0 when any number of options may be picked.
+type PollInfo struct {
+	Question        string   `json:"question"`
+	Options         []string `json:"options"`
+	SelectableCount uint32   `json:"selectable_count"`
+}
+
+// Poll is a poll definition as stored in the database, keyed by the ID of
+// the message that created it.
+type Poll struct {
+	MessageID string    `json:"message_id"`
+	ChatID    string    `json:"chat_id"`
+	CreatedAt time.Time `json:"created_at"`
+	PollInfo
+}
+
+// pollCreation returns whichever version of the poll creation message m
+// carries; they share the same fields.
+func pollCreation(m *waE2E.Message) *waE2E.PollCreationMessage {
+	switch {
+	case m.GetPollCreationMessage() != nil:
+		return m.GetPollCreationMessage()
+	case m.GetPollCreationMessageV2() != nil:
+		return m.GetPollCreationMessageV2()
+	case m.GetPollCreationMessageV3() != nil:
+		return m.GetPollCreationMessageV3()
+	}
+	return nil
+}
+
+func extractPollContent(m *waE2E.Message) (MessageContent, bool) {
+	poll := pollCreation(m)
+	if poll == nil {
+		return MessageContent{}, false
+	}
+	info := &PollInfo{
+		Question:        poll.GetName(),
+		Options:         make([]string, 0, len(poll.GetOptions())),
+		SelectableCount: poll.GetSelectableOptionsCount(),
+	}
+	for _, option := range poll.GetOptions() {
+		info.Options = append(info.Options, option.GetOptionName())
+	}
+	return MessageContent{
+		Text:     poll.GetName(),
+		Type:     "poll",
+		Poll:     info,
+		QuotedID: poll.GetContextInfo().GetStanzaID(),
+	}, true
+}
+
+// PollVote is the latest vote of one voter. A new vote replaces the voter's
+// previous one, and an empty selection withdraws it.
+type PollVote struct {
+	PollID    string    `json:"poll_id"`
+	ChatID    string    `json:"chat_id"`
+	Voter     string    `json:"voter"`
+	Options   []string  `json:"options"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+type PollOptionResult struct {
+	Name   string   `json:"name"`
+	Votes  int      `json:"votes"`
+	Voters []string `json:"voters"`
+}
+
+type PollResults struct {
+	Poll
+	Results     []PollOptionResult `json:"results"`
+	TotalVoters int                `json:"total_voters"`
+}
+
+// pollStore persists poll definitions so that votes, which only reference
+// options by their hash, can be matched to them later, along with the
+// latest vote of every voter.
+type pollStore struct {
+	db *sql.DB
+}
+
+func newPollStore(db *sql.DB) (*pollStore, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS polls (
+		message_id       TEXT PRIMARY KEY,
+		chat_id          TEXT NOT NULL,
+		question         TEXT NOT NULL,
+		options          TEXT NOT NULL,
+		selectable_count INTEGER NOT NULL,
+		created_at       INTEGER NOT NULL
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS poll_votes (
+		poll_id  TEXT NOT NULL,
+		voter    TEXT NOT NULL,
+		options  TEXT NOT NULL,
+		voted_at INTEGER NOT NULL,
+		PRIMARY KEY (poll_id, voter)
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	return &pollStore{db: db}, nil
+}
+
+func (s *pollStore) put(poll Poll) error {
+	options, err := json.Marshal(poll.Options)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT OR REPLACE INTO polls (message_id, chat_id, question, options, selectable_count, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		poll.MessageID, poll.ChatID, poll.Question, options, poll.SelectableCount, poll.CreatedAt.Unix())
+	return err
+}
+
+func (s *pollStore) get(messageID string) (Poll, bool, error) {
+	var poll Poll
+	var options string
+	var createdAt int64
+	err := s.db.QueryRow(`SELECT message_id, chat_id, question, options, selectable_count, created_at
+		FROM polls WHERE message_id = ?`, messageID).
+		Scan(&poll.MessageID, &poll.ChatID, &poll.Question, &options, &poll.SelectableCount, &createdAt)
+	if err == sql.ErrNoRows {
+		return poll, false, nil
+	} else if err != nil {
+		return poll, false, err
+	}
+	poll.CreatedAt = time.Unix(createdAt, 0)
+	if err := json.Unmarshal([]byte(options), &poll.Options); err != nil {
+		return poll, false, err
+	}
+	return poll, true, nil
+}
+
+func (s *pollStore) putVote(vote PollVote) error {
+	options, err := json.Marshal(vote.Options)
+	if err != nil {
+		return err
+	}
+	// Votes can arrive out of order after an offline period, so an older
+	// vote never replaces a newer one. Withdrawn votes are stored as an
+	// empty selection for the same reason.
+	_, err = s.db.Exec(`INSERT INTO poll_votes (poll_id, voter, options, voted_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (poll_id, voter) DO UPDATE SET options = excluded.options, voted_at = excluded.voted_at
+		WHERE excluded.voted_at >= poll_votes.voted_at`,
+		vote.PollID, vote.Voter, options, vote.Timestamp.Unix())
+	return err
+}
+
+// results tallies the votes for poll, keeping the poll's option order.
+func (s *pollStore) results(poll Poll) (PollResults, error) {
+	results := PollResults{Poll: poll, Results: make([]PollOptionResult, len(poll.Options))}
+	index := make(map[string]int, len(poll.Options))
+	for i, option := range poll.Options {
+		results.Results[i] = PollOptionResult{Name: option, Voters: make([]string, 0)}
+		index[option] = i
+	}
+
+	rows, err := s.db.Query(`SELECT voter, options FROM poll_votes WHERE poll_id = ? ORDER BY voted_at`, poll.MessageID)
+	if err != nil {
+		return results, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var voter, raw string
+		if err := rows.Scan(&voter, &raw); err != nil {
+			return results, err
+		}
+		var options []string
+		if err := json.Unmarshal([]byte(raw), &options); err != nil {
+			return results, err
+		}
+		if len(options) == 0 {
+			continue
+		}
+		results.TotalVoters++
+		for _, option := range options {
+			if i, ok := index[option]; ok {
+				results.Results[i].Votes++
+				results.Results[i].Voters = append(results.Results[i].Voters, voter)
+			}
+		}
+	}
+	return results, rows.Err()
+}
+
+// savePoll stores the definition of a poll that arrived as a message, so
+// that votes on polls created elsewhere can be tallied too.
+func (api *WhatsAppAPI) savePoll(msg MessageInfo) {
+	err := api.polls.put(Poll{
+		MessageID: msg.ID,
+		ChatID:    msg.Source.Chat,
+		CreatedAt: msg.Timestamp,
+		PollInfo:  *msg.Content.Poll,
+	})
+	if err != nil {
+		api.log.Errorf("Failed to save poll %s: %v", msg.ID, err)
+	}
+}
+
+// handlePollVote decrypts a vote and records it against its poll. Votes only
+// carry hashes of the selected option names, which are resolved through the
+// stored poll definition.
+func (api *WhatsAppAPI) handlePollVote(evt *events.Message) {
+	pollID := evt.Message.GetPollUpdateMessage().GetPollCreationMessageKey().GetID()
+	poll, ok, err := api.polls.get(pollID)
+	if err != nil {
+		api.log.Errorf("Failed to load poll %s: %v", pollID, err)
+		return
+	} else if !ok {
+		api.log.Debugf("Ignoring vote on unknown poll %s", pollID)
+		return
+	}
+
+	decrypted, err := api.client.DecryptPollVote(evt)
+	if err != nil {
+		api.log.Warnf("Failed to decrypt vote on poll %s from %s: %v", pollID, evt.Info.Sender, err)
+		return
+	}
+
+	names := make(map[[sha256.Size]byte]string, len(poll.Options))
+	for _, option := range poll.Options {
+		names[sha256.Sum256([]byte(option))] = option
+	}
+	vote := PollVote{
+		PollID:    pollID,
+		ChatID:    evt.Info.Chat.String(),
+		Voter:     evt.Info.Sender.ToNonAD().String(),
+		Options:   make([]string, 0, len(decrypted.GetSelectedOptions())),
+		Timestamp: evt.Info.Timestamp,
+	}
+	for _, hash := range decrypted.GetSelectedOptions() {
+		if len(hash) != sha256.Size {
+			continue
+		}
+		if name, ok := names[[sha256.Size]byte(hash)]; ok {
+			vote.Options = append(vote.Options, name)
+		}
+	}
+
+	if err := api.polls.putVote(vote); err != nil {
+		api.log.Errorf("Failed to save vote on poll %s: %v", pollID, err)
+		return
+	}
+	api.emit("poll_vote", vote)
+}
+
+func (api *WhatsAppAPI) getPollResults(w http.ResponseWriter, r *http.Request) {
+	messageID := mux.Vars(r)["messageId"]
+	poll, ok, err := api.polls.get(messageID)
+	if err != nil {
+		api.requestLog(r).Errorf("Failed to load poll %s: %v", messageID, err)
+		http.Error(w, "Failed to get poll results", http.StatusInternalServerError)
+		return
+	} else if !ok {
+		http.Error(w, "Poll not found", http.StatusNotFound)
+		return
+	}
+	results, err := api.polls.results(poll)
+	if err != nil {
+		api.requestLog(r).Errorf("Failed to tally poll %s: %v", messageID, err)
+		http.Error(w, "Failed to get poll results", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+type SendPollRequest struct {
+	ChatID   string   `json:"chat_id"`
+	Question string   `json:"question"`
+	Options  []string `json:"options"`
+	// MultiSelect lets voters pick any number of options instead of one.
+	MultiSelect bool   `json:"multi_select"`
+	ReplyToID   string `json:"reply_to_id,omitempty"`
+}
+
+// validPollOptions checks that there are between 2 and 12 options and that
+// they are distinct, as votes identify options by their text.
+func validPollOptions(options []string) bool {
+	if len(options) < 2 || len(options) > maxPollOptions {
+		return false
+	}
+	seen := make(map[string]bool, len(options))
+	for _, option := range options {
+		if strings.TrimSpace(option) == "" || seen[option] {
+			return false
+		}
+		seen[option] = true
+	}
+	return true
+}
+
+func (api *WhatsAppAPI) sendPoll(w http.ResponseWriter, r *http.Request) {
+	if !api.requireConnected(w) {
+		return
+	}
+
+	var req SendPollRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	chatJID, err := types.ParseJID(req.ChatID)
+	if err != nil {
+		http.Error(w, "Invalid chat JID", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(req.Question) == "" {
+		http.Error(w, "question is required", http.StatusBadRequest)
+		return
+	}
+	if !validPollOptions(req.Options) {
+		http.Error(w, "options must be 2 to 12 distinct, non-empty strings", http.StatusBadRequest)
+		return
+	}
+	contextInfo, ok := api.replyContext(w, req.ReplyToID)
+	if !ok {
+		return
+	}
+
+	selectable := 1
+	if req.MultiSelect {
+		selectable = 0
+	}
+	// The builder adds the message secret that votes are encrypted with;
+	// whatsmeow keeps it once the message is sent.
+	msg := api.client.BuildPollCreation(req.Question, req.Options, selectable)
+	msg.PollCreationMessage.ContextInfo = contextInfo
+
+	if !api.allowSend(w) {
+		return
+	}
+	resp, err := api.sendOutbound(r.Context(), chatJID, msg)
+	if err != nil {
+		api.requestLog(r).Errorf("Failed to send poll to %s: %v", chatJID, err)
+		http.Error(w, "Failed to send poll", http.StatusInternalServerError)
+		return
+	}
+
+	content := extractMessageContent(msg)
+	api.appendMessage(MessageInfo{
+		ID:        resp.ID,
+		Timestamp: resp.Timestamp,
+		Source: MessageSource{
+			Chat:     chatJID.String(),
+			Sender:   api.client.Store.ID.ToNonAD().String(),
+			IsFromMe: true,
+			IsGroup:  chatJID.Server == types.GroupServer,
+		},
+		Content: content,
+		IsRead:  true,
+		Status:  MessageStatusSent,
+	})
+
+	poll := Poll{
+		MessageID: resp.ID,
+		ChatID:    chatJID.String(),
+		CreatedAt: resp.Timestamp,
+		PollInfo:  *content.Poll,
+	}
+	if err := api.polls.put(poll); err != nil {
+		// The poll is out, so report success; only vote tallies suffer.
+		api.requestLog(r).Errorf("Failed to save poll %s: %v", resp.ID, err)
+	}
+
+	response := SendResponse{ID: resp.ID, Timestamp: resp.Timestamp}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// latencySmoothing is the weight of a new sample in the rolling latency
+	// average.
+	latencySmoothing = 0.2
+	// maxTrackedSends bounds how many sent messages await a delivery receipt.
+	maxTrackedSends = 1000
+	reconnectWindow = time.Hour
+)
+
+type ConnectionQuality struct {
+	Quality           string `json:"quality"`
+	AvgLatencyMillis  int64  `json:"avg_latency_ms"`
+	LatencySamples    int    `json:"latency_samples"`
+	RecentReconnects  int    `json:"recent_reconnects"`
+	KeepAliveFailures int    `json:"keepalive_failures"`
+}
+
+// qualityTracker estimates connection health from the time between sending a
+// message and receiving its delivery receipt, keepalive failures and how
+// often the connection drops.
+type qualityTracker struct {
+	mu          sync.Mutex
+	sentAt      map[string]time.Time
+	avgLatency  time.Duration
+	samples     int
+	disconnects []time.Time
+	// keepAliveFailures is the number of consecutive keepalive timeouts,
+	// reset once a keepalive succeeds again.
+	keepAliveFailures int
+}
+
+func newQualityTracker() *qualityTracker {
+	return &qualityTracker{sentAt: make(map[string]time.Time)}
+}
+
+func (q *qualityTracker) trackSent(id string, at time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.sentAt) >= maxTrackedSends {
+		// Receipts for these will likely never come; start over rather than
+		// growing without bound.
+		q.sentAt = make(map[string]time.Time)
+	}
+	q.sentAt[id] = at
+}
+
+func (q *qualityTracker) trackDelivered(ids []string, at time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, id := range ids {
+		sent, ok := q.sentAt[id]
+		if !ok {
+			continue
+		}
+		delete(q.sentAt, id)
+
+		latency := at.Sub(sent)
+		if latency < 0 {
+			continue
+		}
+		if q.samples == 0 {
+			q.avgLatency = latency
+		} else {
+			q.avgLatency = time.Duration(latencySmoothing*float64(latency) + (1-latencySmoothing)*float64(q.avgLatency))
+		}
+		q.samples++
+	}
+}
+
+func (q *qualityTracker) trackDisconnect(at time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.disconnects = append(q.disconnects, at)
+}
+
+func (q *qualityTracker) trackKeepAlive(failures int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.keepAliveFailures = failures
+}
+
+func (q *qualityTracker) snapshot() ConnectionQuality {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	cutoff := time.Now().Add(-reconnectWindow)
+	recent := q.disconnects[:0]
+	for _, at := range q.disconnects {
+		if at.After(cutoff) {
+			recent = append(recent, at)
+		}
+	}
+	q.disconnects = recent
+
+	info := ConnectionQuality{
+		AvgLatencyMillis:  q.avgLatency.Milliseconds(),
+		LatencySamples:    q.samples,
+		RecentReconnects:  len(recent),
+		KeepAliveFailures: q.keepAliveFailures,
+	}
+
+	switch {
+	case q.avgLatency > 5*time.Second || len(recent) >= 10:
+		info.Quality = "poor"
+	case q.avgLatency > 2*time.Second || len(recent) >= 3 || q.keepAliveFailures > 0:
+		info.Quality = "degraded"
+	default:
+		info.Quality = "good"
+	}
+	return info
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSendRate  = 1.0
+	defaultSendBurst = 3
+)
+
+// rateLimiter is a token bucket that spaces out outbound messages, since
+// sending too fast gets accounts banned. A rate of zero disables it.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  int
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{rate: rate, burst: burst, tokens: float64(burst), last: time.Now()}
+}
+
+func validateSendLimit(rate float64, burst int) error {
+	if rate < 0 || math.IsNaN(rate) || math.IsInf(rate, 0) {
+		return errors.New("send_rate must be a non-negative number of messages per second")
+	}
+	if burst < 1 {
+		return errors.New("send_burst must be at least 1")
+	}
+	return nil
+}
+
+func (l *rateLimiter) limit() (float64, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate, l.burst
+}
+
+func (l *rateLimiter) setLimit(rate float64, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(time.Now())
+	l.rate = rate
+	l.burst = burst
+	l.tokens = math.Min(l.tokens, float64(burst))
+}
+
+// refill adds the tokens accrued since the last call. Callers must hold l.mu.
+func (l *rateLimiter) refill(now time.Time) {
+	l.tokens = math.Min(float64(l.burst), l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+}
+
+// available reports whether a token could be taken now without taking it.
+// Otherwise it reports how long until the next one is.
+func (l *rateLimiter) available() (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rate == 0 {
+		return 0, true
+	}
+	l.refill(time.Now())
+	if l.tokens >= 1 {
+		return 0, true
+	}
+	return time.Duration((1 - l.tokens) / l.rate * float64(time.Second)), false
+}
+
+// take consumes a token if one is available. Otherwise it reports how long
+// until the next one is.
+func (l *rateLimiter) take() (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rate == 0 {
+		return 0, true
+	}
+	l.refill(time.Now())
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0, true
+	}
+	return time.Duration((1 - l.tokens) / l.rate * float64(time.Second)), false
+}
+
+// wait blocks until a token is available or ctx is done.
+func (l *rateLimiter) wait(ctx context.Context) error {
+	for {
+		delay, ok := l.take()
+		if ok {
+			return nil
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// reserveSend reserves a slot for an outbound message, failing with 429 if
+// the send rate has been exceeded.
+func (api *WhatsAppAPI) reserveSend() *httpError {
+	if wait, ok := api.sendLimit.take(); !ok {
+		return &httpError{status: http.StatusTooManyRequests, msg: "Send rate exceeded, retry later", retryAfter: wait}
+	}
+	return nil
+}
+
+// allowSend is reserveSend for HTTP handlers. It returns false if a
+// response has already been written.
+func (api *WhatsAppAPI) allowSend(w http.ResponseWriter) bool {
+	if err := api.reserveSend(); err != nil {
+		err.write(w)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterTakeConcurrent(t *testing.T) {
+	const burst = 3
+	l := newRateLimiter(0.001, burst)
+
+	var granted atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, ok := l.take(); ok {
+				granted.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := granted.Load(); got != burst {
+		t.Errorf("%d concurrent sends were allowed, want the burst of %d", got, burst)
+	}
+	if delay, ok := l.take(); ok || delay <= 0 {
+		t.Errorf("take() = %v, %t after the burst, want a positive delay", delay, ok)
+	}
+}
+
+func TestRateLimiterWaitSpacesSends(t *testing.T) {
+	const (
+		rate  = 50.0
+		sends = 6
+	)
+	l := newRateLimiter(rate, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < sends; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := l.wait(ctx); err != nil {
+				t.Errorf("wait() = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// The first send uses the burst, each further one waits for a token.
+	want := time.Duration(float64(sends-1) / rate * float64(time.Second))
+	if elapsed := time.Since(start); elapsed < want*9/10 {
+		t.Errorf("%d sends took %v, want at least %v", sends, elapsed, want)
+	}
+}
+
+func TestRateLimiterDisabled(t *testing.T) {
+	l := newRateLimiter(0, 1)
+	for i := 0; i < 100; i++ {
+		if _, ok := l.take(); !ok {
+			t.Fatalf("send %d was limited with the rate limit disabled", i)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+type ReadinessCheck struct {
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type SendReadinessResponse struct {
+	Ready  bool                      `json:"ready"`
+	Checks map[string]ReadinessCheck `json:"checks"`
+}
+
+// getSendReadiness combines the auth, connection and clock diagnostics (and
+// optionally a recipient lookup) into a single answer to "can I send now?".
+func (api *WhatsAppAPI) getSendReadiness(w http.ResponseWriter, r *http.Request) {
+	checks := make(map[string]ReadinessCheck)
+
+	authenticated := api.client.Store.ID != nil
+	checks["authenticated"] = ReadinessCheck{OK: authenticated}
+
+	connected := api.client.IsConnected() && api.client.IsLoggedIn()
+	check := ReadinessCheck{OK: connected}
+	if !connected {
+		check.Detail = "connection status: " + api.getConnectionStatus()
+	}
+	checks["connected"] = check
+
+	skew := api.clockSkewSnapshot()
+	check = ReadinessCheck{OK: skew == nil || !skew.Exceeded}
+	if !check.OK {
+		check.Detail = "system clock is skewed against WhatsApp servers"
+	}
+	checks["clock"] = check
+
+	check = ReadinessCheck{OK: true}
+	if wait, ok := api.sendLimit.available(); !ok {
+		check = ReadinessCheck{Detail: "send rate exceeded, next send possible in " + wait.Round(time.Millisecond).String()}
+	}
+	checks["rate_limit"] = check
+
+	if recipient := r.URL.Query().Get("recipient"); recipient != "" {
+		check = ReadinessCheck{}
+		if !connected {
+			check.Detail = "can't look up recipient while disconnected"
+		} else if results, err := api.client.IsOnWhatsApp([]string{recipient}); err != nil {
+			check.Detail = "lookup failed: " + err.Error()
+		} else if len(results) == 0 || !results[0].IsIn {
+			check.Detail = "recipient is not on WhatsApp"
+		} else {
+			check.OK = true
+		}
+		checks["recipient"] = check
+	}
+
+	response := SendReadinessResponse{Ready: true, Checks: checks}
+	for _, c := range checks {
+		response.Ready = response.Ready && c.OK
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// getLiveness reports that the process is up and serving requests.
+func (api *WhatsAppAPI) getLiveness(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// getReadiness reports whether the service can handle requests. Only the
+// database is required: stored messages can still be served while WhatsApp
+// is disconnected, so the connection is reported but doesn't fail the probe.
+func (api *WhatsAppAPI) getReadiness(w http.ResponseWriter, r *http.Request) {
+	checks := make(map[string]ReadinessCheck)
+
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+	check := ReadinessCheck{OK: true}
+	if err := api.settings.db.PingContext(ctx); err != nil {
+		check = ReadinessCheck{Detail: "database unreachable: " + err.Error()}
+	}
+	checks["database"] = check
+
+	checks["whatsapp"] = ReadinessCheck{
+		OK:     api.client.IsConnected() && api.client.IsLoggedIn(),
+		Detail: "connection status: " + api.getConnectionStatus(),
+	}
+
+	response := SendReadinessResponse{Ready: checks["database"].OK, Checks: checks}
+	w.Header().Set("Content-Type", "application/json")
+	if !response.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(response)
+}
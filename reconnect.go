@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// connectTimeout bounds how long a single connection attempt may take.
+const connectTimeout = 30 * time.Second
+
+var errConnectTimeout = errors.New("timed out connecting to WhatsApp")
+
+// connect connects the client, giving up when ctx is done or after
+// connectTimeout. whatsmeow's Connect can't be cancelled, so an abandoned
+// attempt keeps running in the background and may still succeed.
+func (api *WhatsAppAPI) connect(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, connectTimeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- api.client.Connect()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return errConnectTimeout
+	}
+}
+
+// ReconnectPolicy controls how the client reconnects after an unexpected
+// disconnect. Backoffs are Go duration strings such as "2s" or "1m".
+type ReconnectPolicy struct {
+	Enabled        bool   `json:"enabled"`
+	InitialBackoff string `json:"initial_backoff"`
+	MaxBackoff     string `json:"max_backoff"`
+	// MaxAttempts of 0 retries forever.
+	MaxAttempts int `json:"max_attempts"`
+}
+
+type ReconnectState struct {
+	Reconnecting bool       `json:"reconnecting"`
+	Attempt      int        `json:"attempt"`
+	NextRetry    *time.Time `json:"next_retry,omitempty"`
+	LastError    string     `json:"last_error,omitempty"`
+}
+
+type ReconnectPolicyResponse struct {
+	Policy ReconnectPolicy `json:"policy"`
+	State  ReconnectState  `json:"state"`
+}
+
+var defaultReconnectPolicy = ReconnectPolicy{
+	Enabled:        true,
+	InitialBackoff: "2s",
+	MaxBackoff:     "5m",
+	MaxAttempts:    0,
+}
+
+func (p ReconnectPolicy) validate() error {
+	initial, max, err := p.backoffs()
+	if err != nil || initial <= 0 || max < initial {
+		return errors.New("reconnect backoffs must be positive durations with max_backoff >= initial_backoff")
+	}
+	if p.MaxAttempts < 0 {
+		return errors.New("max_attempts must not be negative")
+	}
+	return nil
+}
+
+func (p ReconnectPolicy) backoffs() (initial, max time.Duration, err error) {
+	initial, err = time.ParseDuration(p.InitialBackoff)
+	if err != nil {
+		return
+	}
+	max, err = time.ParseDuration(p.MaxBackoff)
+	return
+}
+
+// reconnector replaces whatsmeow's built-in auto reconnect so that the
+// backoff can be configured and inspected at runtime.
+type reconnector struct {
+	mu     sync.Mutex
+	policy ReconnectPolicy
+	state  ReconnectState
+	// stop aborts the current reconnect loop when the policy changes or the
+	// connection comes back by other means.
+	stop chan struct{}
+}
+
+func newReconnector(policy ReconnectPolicy) *reconnector {
+	return &reconnector{policy: policy}
+}
+
+// startReconnect begins reconnecting with exponential backoff unless a loop is already
+// running or reconnects are disabled.
+func (api *WhatsAppAPI) startReconnect() {
+	rc := api.reconnect
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if !rc.policy.Enabled || rc.state.Reconnecting || api.client.Store.ID == nil {
+		return
+	}
+	initial, max, err := rc.policy.backoffs()
+	if err != nil {
+		api.log.Errorf("Invalid reconnect policy: %v", err)
+		return
+	}
+
+	rc.state = ReconnectState{Reconnecting: true}
+	rc.stop = make(chan struct{})
+	go api.reconnectLoop(initial, max, rc.policy.MaxAttempts, rc.stop)
+}
+
+func (api *WhatsAppAPI) reconnectLoop(backoff, max time.Duration, maxAttempts int, stop chan struct{}) {
+	rc := api.reconnect
+	for attempt := 1; maxAttempts == 0 || attempt <= maxAttempts; attempt++ {
+		next := time.Now().Add(backoff)
+		rc.mu.Lock()
+		rc.state.Attempt = attempt
+		rc.state.NextRetry = &next
+		rc.mu.Unlock()
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(backoff):
+		}
+
+		api.log.Infof("Reconnect attempt %d", attempt)
+		err := api.connect(context.Background())
+		if err == nil || api.client.IsConnected() {
+			api.stopReconnect()
+			return
+		}
+
+		rc.mu.Lock()
+		rc.state.LastError = err.Error()
+		rc.mu.Unlock()
+		api.log.Warnf("Reconnect attempt %d failed: %v", attempt, err)
+
+		backoff *= 2
+		if backoff > max {
+			backoff = max
+		}
+	}
+
+	api.log.Errorf("Giving up reconnecting after %d attempts", maxAttempts)
+	rc.mu.Lock()
+	rc.state.Reconnecting = false
+	rc.state.NextRetry = nil
+	rc.mu.Unlock()
+}
+
+func (api *WhatsAppAPI) stopReconnect() {
+	rc := api.reconnect
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if rc.state.Reconnecting {
+		close(rc.stop)
+	}
+	rc.state = ReconnectState{}
+}
+
+func (api *WhatsAppAPI) getReconnectPolicy(w http.ResponseWriter, r *http.Request) {
+	rc := api.reconnect
+	rc.mu.Lock()
+	response := ReconnectPolicyResponse{Policy: rc.policy, State: rc.state}
+	rc.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (api *WhatsAppAPI) updateReconnectPolicy(w http.ResponseWriter, r *http.Request) {
+	ok := api.updateConfig(w, func(cfg *Config) error {
+		var policy ReconnectPolicy
+		if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+			return err
+		}
+		cfg.ReconnectPolicy = policy
+		return nil
+	})
+	if ok {
+		api.getReconnectPolicy(w, r)
+	}
+}
+
+func (api *WhatsAppAPI) setReconnectPolicy(policy ReconnectPolicy) {
+	rc := api.reconnect
+	rc.mu.Lock()
+	wasReconnecting := rc.state.Reconnecting
+	rc.policy = policy
+	rc.mu.Unlock()
+
+	// A running loop keeps its old backoff, so restart it under the new
+	// policy.
+	if wasReconnecting {
+		api.stopReconnect()
+		api.startReconnect()
+	}
+}
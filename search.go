@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const defaultSearchLimit = 50
+
+type SearchRequest struct {
+	Query  string `json:"query"`
+	ChatID string `json:"chat_id,omitempty"`
+	Limit  int    `json:"limit,omitempty"`
+}
+
+type searchMatch struct {
+	message MessageInfo
+	score   int
+}
+
+// searchScore counts how often the terms occur in text. Every term has to
+// occur at least once for the message to match.
+func searchScore(text string, terms []string) int {
+	text = strings.ToLower(text)
+	score := 0
+	for _, term := range terms {
+		n := strings.Count(text, term)
+		if n == 0 {
+			return 0
+		}
+		score += n
+	}
+	return score
+}
+
+// searchMessages finds messages whose text or caption contains every word
+// of the query, ignoring case. The best matches come first and ties go to
+// the most recent message.
+func (api *WhatsAppAPI) searchMessages(w http.ResponseWriter, r *http.Request) {
+	if api.client.Store.ID == nil {
+		http.Error(w, "Not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	var req SearchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	terms := strings.Fields(strings.ToLower(req.Query))
+	if len(terms) == 0 {
+		http.Error(w, "Query is required", http.StatusBadRequest)
+		return
+	}
+	if req.Limit < 0 || req.Limit > maxMessagePageSize {
+		http.Error(w, "Limit must be between 1 and 500", http.StatusBadRequest)
+		return
+	}
+	if req.Limit == 0 {
+		req.Limit = defaultSearchLimit
+	}
+
+	var matches []searchMatch
+	for _, msg := range api.snapshotMessages() {
+		if req.ChatID != "" && msg.Source.Chat != req.ChatID {
+			continue
+		}
+		if score := searchScore(msg.Content.Text, terms); score > 0 {
+			matches = append(matches, searchMatch{message: msg, score: score})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score > matches[j].score
+		}
+		return matches[i].message.Timestamp.After(matches[j].message.Timestamp)
+	})
+	if len(matches) > req.Limit {
+		matches = matches[:req.Limit]
+	}
+
+	results := make([]MessageInfo, len(matches))
+	for i, match := range matches {
+		results[i] = match.message
+	}
+
+	response := MessagesResponse{Messages: api.enrichMessages(results)}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
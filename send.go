@@ -0,0 +1,531 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mau.fi/whatsmeow/proto/waCommon"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	waLog "go.mau.fi/whatsmeow/util/log"
+	"google.golang.org/protobuf/proto"
+)
+
+type SendTextRequest struct {
+	ChatID string `json:"chat_id"`
+	Text   string `json:"text"`
+	// ReplyToID quotes a specific message. ReplyToLastFrom instead quotes
+	// the most recent message the given sender JID sent in the chat.
+	ReplyToID       string `json:"reply_to_id,omitempty"`
+	ReplyToLastFrom string `json:"reply_to_last_from,omitempty"`
+	// Mentions are the JIDs to @-mention. WhatsApp renders "@<number>" in
+	// the text as the mentioned contact's name; mentions the text doesn't
+	// already contain are appended to it.
+	Mentions []string `json:"mentions,omitempty"`
+	// LinkPreview fetches the first URL in the text to attach its title,
+	// description and image. The message is sent without a preview if
+	// that fails.
+	LinkPreview bool `json:"link_preview,omitempty"`
+}
+
+type EditMessageRequest struct {
+	Text string `json:"text"`
+}
+
+// ReactRequest reacts to a message; an empty Emoji removes the reaction.
+// ChatID and Sender are only needed for messages that aren't stored, and
+// Sender only in groups.
+type ReactRequest struct {
+	ChatID string `json:"chat_id,omitempty"`
+	Sender string `json:"sender,omitempty"`
+	Emoji  string `json:"emoji"`
+}
+
+// SendResponse describes a sent message. Queued is set when the message was
+// put in the outbox to be sent once the client has reconnected; Timestamp is
+// then the time it was queued.
+type SendResponse struct {
+	ID        string    `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+	Queued    bool      `json:"queued,omitempty"`
+}
+
+// Errors that callers need to tell apart from internal failures. They reach
+// clients wrapped in an *httpError with a matching status.
+var (
+	errNotAuthenticated = errors.New("Not authenticated")
+	errNotConnected     = errors.New("Not connected to WhatsApp")
+)
+
+// httpError is a failure along with the HTTP status it is reported as, for
+// logic shared between HTTP handlers and the WebSocket.
+type httpError struct {
+	status     int
+	msg        string
+	retryAfter time.Duration
+	err        error
+}
+
+// newHTTPError wraps a sentinel error so that errors.Is still matches it.
+func newHTTPError(status int, err error) *httpError {
+	return &httpError{status: status, msg: err.Error(), err: err}
+}
+
+func (e *httpError) Error() string {
+	return e.msg
+}
+
+func (e *httpError) Unwrap() error {
+	return e.err
+}
+
+func (e *httpError) write(w http.ResponseWriter) {
+	if e.retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(e.retryAfter.Seconds()))))
+	}
+	http.Error(w, e.msg, e.status)
+}
+
+// writeError reports err over HTTP, as a 500 unless it wraps an *httpError.
+func writeError(w http.ResponseWriter, err error, fallback string) {
+	var httpErr *httpError
+	if errors.As(err, &httpErr) {
+		httpErr.write(w)
+		return
+	}
+	http.Error(w, fallback, http.StatusInternalServerError)
+}
+
+// checkPaired checks that the client is paired, for sends that can be queued
+// while it reconnects.
+func (api *WhatsAppAPI) checkPaired() *httpError {
+	if api.client.Store.ID == nil {
+		return newHTTPError(http.StatusUnauthorized, errNotAuthenticated)
+	}
+	return nil
+}
+
+// requirePaired is checkPaired for HTTP handlers. It returns false if a
+// response has already been written.
+func (api *WhatsAppAPI) requirePaired(w http.ResponseWriter) bool {
+	if err := api.checkPaired(); err != nil {
+		err.write(w)
+		return false
+	}
+	return true
+}
+
+// checkConnected checks that the client is paired and online before a send.
+// A missing pairing is reported as 401 and a dropped connection as 409, since
+// the latter usually resolves itself once the reconnector catches up.
+func (api *WhatsAppAPI) checkConnected() *httpError {
+	if err := api.checkPaired(); err != nil {
+		return err
+	}
+	if !api.client.IsConnected() || !api.client.IsLoggedIn() {
+		return newHTTPError(http.StatusConflict, errNotConnected)
+	}
+	return nil
+}
+
+// requireConnected is checkConnected for HTTP handlers. It returns false if
+// a response has already been written.
+func (api *WhatsAppAPI) requireConnected(w http.ResponseWriter) bool {
+	if err := api.checkConnected(); err != nil {
+		err.write(w)
+		return false
+	}
+	return true
+}
+
+// lastMessageFrom finds the most recent inbound message from sender in chat.
+func (api *WhatsAppAPI) lastMessageFrom(chat, sender types.JID) (MessageInfo, bool) {
+	chatId := chat.String()
+	senderId := sender.ToNonAD().String()
+	messages := api.snapshotMessages()
+	for i := len(messages) - 1; i >= 0; i-- {
+		msg := messages[i]
+		if msg.Source.Chat != chatId || msg.Source.IsFromMe {
+			continue
+		}
+		if jid, err := types.ParseJID(msg.Source.Sender); err == nil && jid.ToNonAD().String() == senderId {
+			return msg, true
+		}
+	}
+	return MessageInfo{}, false
+}
+
+// quoteContext builds the ContextInfo that makes a message a reply to quoted.
+// Media is quoted with its original proto so recipients see the preview.
+func quoteContext(quoted MessageInfo) *waE2E.ContextInfo {
+	quotedMessage := quoted.raw
+	if quotedMessage == nil {
+		quotedMessage = &waE2E.Message{Conversation: proto.String(quoted.Content.Text)}
+	}
+	participant := quoted.Source.Sender
+	if jid, err := types.ParseJID(participant); err == nil {
+		participant = jid.ToNonAD().String()
+	}
+	return &waE2E.ContextInfo{
+		StanzaID:      proto.String(quoted.ID),
+		Participant:   proto.String(participant),
+		QuotedMessage: quotedMessage,
+	}
+}
+
+// replyContext returns the ContextInfo for a media send replying to
+// replyToID, or nil if it isn't a reply. It responds with 404 and returns
+// false when the quoted message isn't stored, rather than sending a broken
+// quote.
+func (api *WhatsAppAPI) replyContext(w http.ResponseWriter, replyToID string) (*waE2E.ContextInfo, bool) {
+	if replyToID == "" {
+		return nil, true
+	}
+	quoted, ok := api.findMessage(replyToID)
+	if !ok {
+		http.Error(w, "Quoted message not found", http.StatusNotFound)
+		return nil, false
+	}
+	return quoteContext(quoted), true
+}
+
+func (api *WhatsAppAPI) sendTextMessage(w http.ResponseWriter, r *http.Request) {
+	if !api.requirePaired(w) {
+		return
+	}
+
+	var req SendTextRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	response, err := api.sendText(r.Context(), api.requestLog(r), req)
+	if err != nil {
+		writeError(w, err, "Failed to send message")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if response.Queued {
+		w.WriteHeader(http.StatusAccepted)
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// mentionText parses the mentioned JIDs and returns text with a placeholder
+// for every mention it's missing.
+func mentionText(text string, mentions []string) (string, []string, error) {
+	jids := make([]string, 0, len(mentions))
+	for _, raw := range mentions {
+		jid, err := types.ParseJID(raw)
+		if err != nil || jid.User == "" {
+			return "", nil, &httpError{status: http.StatusBadRequest, msg: "Invalid mention JID " + raw}
+		}
+		jid = jid.ToNonAD()
+		placeholder := "@" + jid.User
+		if !strings.Contains(text, placeholder) {
+			text += " " + placeholder
+		}
+		jids = append(jids, jid.String())
+	}
+	return text, jids, nil
+}
+
+// sendText sends and stores a text message. Invalid requests and rate
+// limiting are reported as *httpError.
+func (api *WhatsAppAPI) sendText(ctx context.Context, log waLog.Logger, req SendTextRequest) (SendResponse, error) {
+	chatJID, err := types.ParseJID(req.ChatID)
+	if err != nil {
+		return SendResponse{}, &httpError{status: http.StatusBadRequest, msg: "Invalid chat JID"}
+	}
+	if req.Text == "" {
+		return SendResponse{}, &httpError{status: http.StatusBadRequest, msg: "Text is required"}
+	}
+
+	var quoted *MessageInfo
+	if req.ReplyToID != "" {
+		msg, ok := api.findMessage(req.ReplyToID)
+		if !ok {
+			return SendResponse{}, &httpError{status: http.StatusNotFound, msg: "Quoted message not found"}
+		}
+		quoted = &msg
+	} else if req.ReplyToLastFrom != "" {
+		senderJID, err := types.ParseJID(req.ReplyToLastFrom)
+		if err != nil {
+			return SendResponse{}, &httpError{status: http.StatusBadRequest, msg: "Invalid reply_to_last_from JID"}
+		}
+		msg, ok := api.lastMessageFrom(chatJID, senderJID)
+		if !ok {
+			return SendResponse{}, &httpError{status: http.StatusNotFound, msg: "No message from that sender in this chat"}
+		}
+		quoted = &msg
+	}
+
+	text, mentions, err := mentionText(req.Text, req.Mentions)
+	if err != nil {
+		return SendResponse{}, err
+	}
+
+	var preview *LinkPreview
+	if link := firstURL(text); req.LinkPreview && link != "" {
+		fetched, err := fetchLinkPreview(ctx, link)
+		if err != nil {
+			log.Warnf("Failed to generate link preview for %s: %v", link, err)
+		} else {
+			preview = &fetched
+		}
+	}
+
+	msg := &waE2E.Message{Conversation: proto.String(text)}
+	if quoted != nil || len(mentions) > 0 || preview != nil {
+		extended := &waE2E.ExtendedTextMessage{Text: proto.String(text)}
+		if quoted != nil || len(mentions) > 0 {
+			extended.ContextInfo = &waE2E.ContextInfo{}
+			if quoted != nil {
+				extended.ContextInfo = quoteContext(*quoted)
+			}
+			if len(mentions) > 0 {
+				extended.ContextInfo.MentionedJID = mentions
+			}
+		}
+		if preview != nil {
+			extended.MatchedText = proto.String(preview.URL)
+			extended.Title = proto.String(preview.Title)
+			extended.Description = proto.String(preview.Description)
+			extended.JPEGThumbnail = preview.Thumbnail
+		}
+		msg = &waE2E.Message{ExtendedTextMessage: extended}
+	}
+
+	// While the client reconnects the message waits in the outbox, which
+	// sends it once the connection is back and keeps it across restarts.
+	if !api.client.IsConnected() || !api.client.IsLoggedIn() {
+		entry, err := api.queueOutbound(chatJID, msg)
+		if err != nil {
+			log.Errorf("Failed to queue message to %s: %v", chatJID, err)
+			return SendResponse{}, err
+		}
+		log.Infof("Queued message %s to %s until the client reconnects", entry.ID, chatJID)
+		return SendResponse{ID: entry.ID, Timestamp: entry.CreatedAt, Queued: true}, nil
+	}
+
+	if err := api.reserveSend(); err != nil {
+		return SendResponse{}, err
+	}
+	resp, err := api.sendOutbound(ctx, chatJID, msg)
+	if err != nil {
+		log.Errorf("Failed to send message to %s: %v", chatJID, err)
+		return SendResponse{}, err
+	}
+
+	// Keep our own messages in the history so replies, receipts and
+	// reactions to them can be matched up.
+	sent := MessageInfo{
+		ID:        resp.ID,
+		Timestamp: resp.Timestamp,
+		Source: MessageSource{
+			Chat:     chatJID.String(),
+			Sender:   api.client.Store.ID.ToNonAD().String(),
+			IsFromMe: true,
+			IsGroup:  chatJID.Server == types.GroupServer,
+		},
+		Content: MessageContent{Text: text, Type: "text", Mentions: mentions},
+		IsRead:  true,
+		Status:  MessageStatusSent,
+	}
+	if quoted != nil {
+		sent.Content.QuotedID = quoted.ID
+	}
+	api.appendMessage(sent)
+
+	return SendResponse{ID: resp.ID, Timestamp: resp.Timestamp}, nil
+}
+
+// editMessage edits the text of a text message or the caption of a media
+// message this account sent.
+func (api *WhatsAppAPI) editMessage(w http.ResponseWriter, r *http.Request) {
+	if !api.requireConnected(w) {
+		return
+	}
+
+	var req EditMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	vars := mux.Vars(r)
+	original, ok := api.findMessage(vars["messageId"])
+	if !ok {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+	if !original.Source.IsFromMe {
+		http.Error(w, "Only messages sent by this account can be edited", http.StatusForbidden)
+		return
+	}
+	if original.RevokedAt != nil {
+		http.Error(w, "Message was deleted", http.StatusBadRequest)
+		return
+	}
+
+	var content *waE2E.Message
+	switch {
+	case original.Content.Type == "text":
+		if req.Text == "" {
+			http.Error(w, "Text is required", http.StatusBadRequest)
+			return
+		}
+		content = &waE2E.Message{Conversation: proto.String(req.Text)}
+	case original.raw != nil:
+		content = withCaption(original.raw, req.Text)
+	default:
+		http.Error(w, "Message type can't be edited", http.StatusBadRequest)
+		return
+	}
+
+	chatJID, err := types.ParseJID(original.Source.Chat)
+	if err != nil {
+		http.Error(w, "Invalid chat JID", http.StatusBadRequest)
+		return
+	}
+
+	if !api.allowSend(w) {
+		return
+	}
+	resp, err := api.sendOutbound(r.Context(), chatJID, api.client.BuildEdit(chatJID, original.ID, content))
+	if err != nil {
+		api.requestLog(r).Errorf("Failed to edit message %s: %v", original.ID, err)
+		http.Error(w, "Failed to edit message", http.StatusInternalServerError)
+		return
+	}
+
+	api.handleEdit(&waE2E.ProtocolMessage{
+		Key:           &waCommon.MessageKey{ID: proto.String(original.ID)},
+		EditedMessage: content,
+	}, resp.Timestamp)
+
+	response := SendResponse{ID: resp.ID, Timestamp: resp.Timestamp}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// revokeMessage deletes a message this account sent for everyone in the
+// chat. The message is kept locally, marked as revoked.
+func (api *WhatsAppAPI) revokeMessage(w http.ResponseWriter, r *http.Request) {
+	if !api.requireConnected(w) {
+		return
+	}
+
+	vars := mux.Vars(r)
+	original, ok := api.findMessage(vars["messageId"])
+	if !ok {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+	if !original.Source.IsFromMe {
+		http.Error(w, "Only messages sent by this account can be revoked", http.StatusForbidden)
+		return
+	}
+	if original.RevokedAt != nil {
+		http.Error(w, "Message was already deleted", http.StatusConflict)
+		return
+	}
+
+	chatJID, err := types.ParseJID(original.Source.Chat)
+	if err != nil {
+		http.Error(w, "Invalid chat JID", http.StatusBadRequest)
+		return
+	}
+
+	if !api.allowSend(w) {
+		return
+	}
+	resp, err := api.sendOutbound(r.Context(), chatJID, api.client.BuildRevoke(chatJID, types.EmptyJID, original.ID))
+	if err != nil {
+		api.requestLog(r).Errorf("Failed to revoke message %s: %v", original.ID, err)
+		http.Error(w, "Failed to revoke message", http.StatusInternalServerError)
+		return
+	}
+
+	api.handleRevoke(original.ID, resp.Timestamp)
+
+	response := SendResponse{ID: resp.ID, Timestamp: resp.Timestamp}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// reactToMessage adds, replaces or removes this account's reaction to a
+// message. Stored messages are looked up by ID; others can still be reacted
+// to when the request names their chat, and in groups their sender.
+func (api *WhatsAppAPI) reactToMessage(w http.ResponseWriter, r *http.Request) {
+	if !api.requireConnected(w) {
+		return
+	}
+
+	var req ReactRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	messageID := mux.Vars(r)["messageId"]
+	chat, sender := req.ChatID, req.Sender
+	if original, ok := api.findMessage(messageID); ok {
+		if chat != "" && chat != original.Source.Chat {
+			http.Error(w, "Message not found in this chat", http.StatusNotFound)
+			return
+		}
+		chat, sender = original.Source.Chat, original.Source.Sender
+	} else if chat == "" {
+		http.Error(w, "chat_id is required for messages that aren't stored", http.StatusBadRequest)
+		return
+	}
+
+	chatJID, err := types.ParseJID(chat)
+	if err != nil {
+		http.Error(w, "Invalid chat JID", http.StatusBadRequest)
+		return
+	}
+	var senderJID types.JID
+	switch {
+	case sender != "":
+		senderJID, err = types.ParseJID(sender)
+		if err != nil {
+			http.Error(w, "Invalid sender JID", http.StatusBadRequest)
+			return
+		}
+	case chatJID.Server == types.GroupServer:
+		http.Error(w, "sender is required for group messages that aren't stored", http.StatusBadRequest)
+		return
+	default:
+		// In a direct chat, a message we don't know about came from the
+		// other party.
+		senderJID = chatJID
+	}
+
+	if !api.allowSend(w) {
+		return
+	}
+	msg := api.client.BuildReaction(chatJID, senderJID, messageID, req.Emoji)
+	resp, err := api.sendOutbound(r.Context(), chatJID, msg)
+	if err != nil {
+		api.requestLog(r).Errorf("Failed to react to message %s: %v", messageID, err)
+		http.Error(w, "Failed to send reaction", http.StatusInternalServerError)
+		return
+	}
+
+	api.setReaction(messageID, api.client.Store.ID.ToNonAD().String(), req.Emoji, resp.Timestamp)
+
+	response := SendResponse{ID: resp.ID, Timestamp: resp.Timestamp}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
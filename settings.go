@@ -0,0 +1,51 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+)
+
+// settingsStore persists API-level settings as JSON values in the same
+// SQLite database whatsmeow uses for the device store.
+type settingsStore struct {
+	db *sql.DB
+}
+
+func newSettingsStore(db *sql.DB) (*settingsStore, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS api_settings (
+		key   TEXT PRIMARY KEY,
+		value TEXT NOT NULL
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	return &settingsStore{db: db}, nil
+}
+
+// load decodes the setting stored under key into v. It reports false if the
+// setting has never been saved, leaving v untouched.
+func (s *settingsStore) load(key string, v interface{}) (bool, error) {
+	var value string
+	err := s.db.QueryRow(`SELECT value FROM api_settings WHERE key = ?`, key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, json.Unmarshal([]byte(value), v)
+}
+
+func (s *settingsStore) save(key string, v interface{}) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT INTO api_settings (key, value) VALUES (?, ?)
+		ON CONFLICT (key) DO UPDATE SET value = excluded.value`, key, string(value))
+	return err
+}
+
+func (s *settingsStore) delete(key string) error {
+	_, err := s.db.Exec(`DELETE FROM api_settings WHERE key = ?`, key)
+	return err
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+type StatusRequest struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+	// BackgroundColor and TextColor are "#RRGGBB" or "#AARRGGBB" and only
+	// apply to text statuses.
+	BackgroundColor string `json:"background_color"`
+	TextColor       string `json:"text_color"`
+	Font            int32  `json:"font"`
+	// Media is the raw image or video, base64 encoded in JSON.
+	Media    []byte `json:"media"`
+	MimeType string `json:"mime_type"`
+	Caption  string `json:"caption"`
+	// StripMetadata overrides the strip_image_metadata config for images.
+	StripMetadata *bool `json:"strip_metadata,omitempty"`
+}
+
+type StatusResponse struct {
+	ID        string    `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// parseARGB converts "#RRGGBB" or "#AARRGGBB" into the ARGB integer used by
+// text statuses. Colors without an alpha channel are fully opaque.
+func parseARGB(color string) (uint32, error) {
+	hexColor := strings.TrimPrefix(color, "#")
+	if len(hexColor) == 6 {
+		hexColor = "FF" + hexColor
+	}
+	value, err := strconv.ParseUint(hexColor, 16, 32)
+	if err != nil || len(hexColor) != 8 {
+		return 0, strconv.ErrSyntax
+	}
+	return uint32(value), nil
+}
+
+func buildTextStatus(req StatusRequest) (*waE2E.Message, error) {
+	msg := &waE2E.ExtendedTextMessage{
+		Text: proto.String(req.Text),
+		Font: waE2E.ExtendedTextMessage_FontType(req.Font).Enum(),
+	}
+	if req.BackgroundColor != "" {
+		argb, err := parseARGB(req.BackgroundColor)
+		if err != nil {
+			return nil, err
+		}
+		msg.BackgroundArgb = proto.Uint32(argb)
+	}
+	if req.TextColor != "" {
+		argb, err := parseARGB(req.TextColor)
+		if err != nil {
+			return nil, err
+		}
+		msg.TextArgb = proto.Uint32(argb)
+	}
+	return &waE2E.Message{ExtendedTextMessage: msg}, nil
+}
+
+func (api *WhatsAppAPI) buildMediaStatus(ctx context.Context, req StatusRequest) (*waE2E.Message, error) {
+	mediaType := whatsmeow.MediaImage
+	if req.Type == "video" {
+		mediaType = whatsmeow.MediaVideo
+	}
+
+	uploaded, err := api.client.Upload(ctx, req.Media, mediaType)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Type == "video" {
+		return &waE2E.Message{VideoMessage: &waE2E.VideoMessage{
+			Caption:       proto.String(req.Caption),
+			Mimetype:      proto.String(req.MimeType),
+			URL:           proto.String(uploaded.URL),
+			DirectPath:    proto.String(uploaded.DirectPath),
+			MediaKey:      uploaded.MediaKey,
+			FileEncSHA256: uploaded.FileEncSHA256,
+			FileSHA256:    uploaded.FileSHA256,
+			FileLength:    proto.Uint64(uploaded.FileLength),
+		}}, nil
+	}
+	return &waE2E.Message{ImageMessage: &waE2E.ImageMessage{
+		Caption:       proto.String(req.Caption),
+		Mimetype:      proto.String(req.MimeType),
+		URL:           proto.String(uploaded.URL),
+		DirectPath:    proto.String(uploaded.DirectPath),
+		MediaKey:      uploaded.MediaKey,
+		FileEncSHA256: uploaded.FileEncSHA256,
+		FileSHA256:    uploaded.FileSHA256,
+		FileLength:    proto.Uint64(uploaded.FileLength),
+	}}, nil
+}
+
+// postStatus publishes a text, image or video status update. whatsmeow
+// resolves the recipients from the account's status privacy settings when
+// sending to status@broadcast.
+func (api *WhatsAppAPI) postStatus(w http.ResponseWriter, r *http.Request) {
+	if !api.requireConnected(w) {
+		return
+	}
+
+	var req StatusRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Checked before any media upload, which would be wasted otherwise.
+	if !api.allowSend(w) {
+		return
+	}
+
+	ctx := r.Context()
+	var msg *waE2E.Message
+	var err error
+	switch req.Type {
+	case "", "text":
+		if req.Text == "" {
+			http.Error(w, "Text is required", http.StatusBadRequest)
+			return
+		}
+		msg, err = buildTextStatus(req)
+		if err != nil {
+			http.Error(w, "Invalid color, expected #RRGGBB or #AARRGGBB", http.StatusBadRequest)
+			return
+		}
+	case "image", "video":
+		if len(req.Media) == 0 || req.MimeType == "" {
+			http.Error(w, "Media and mime_type are required", http.StatusBadRequest)
+			return
+		}
+		limits := api.getMediaLimits()
+		limit, kind := limits.Image, "Image"
+		if req.Type == "video" {
+			limit, kind = limits.Video, "Video"
+		}
+		if err := limit.check(kind, len(req.Media), req.MimeType); err != nil {
+			writeError(w, err, "Media is not accepted")
+			return
+		}
+		if req.Type == "image" && api.stripImageMetadata(req.StripMetadata) {
+			if req.Media, _, err = reencodeImage(req.Media); err != nil {
+				http.Error(w, "Image could not be decoded; metadata can only be stripped from JPEG and PNG", http.StatusBadRequest)
+				return
+			}
+			req.MimeType = http.DetectContentType(req.Media)
+		}
+		msg, err = api.buildMediaStatus(ctx, req)
+		if err != nil {
+			api.requestLog(r).Errorf("Failed to upload status media: %v", err)
+			http.Error(w, "Failed to upload media", http.StatusInternalServerError)
+			return
+		}
+	default:
+		http.Error(w, "Type must be text, image or video", http.StatusBadRequest)
+		return
+	}
+
+	resp, err := api.sendOutbound(ctx, types.StatusBroadcastJID, msg)
+	if err != nil {
+		api.requestLog(r).Errorf("Failed to post status: %v", err)
+		http.Error(w, "Failed to post status", http.StatusInternalServerError)
+		return
+	}
+
+	response := StatusResponse{ID: resp.ID, Timestamp: resp.Timestamp}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
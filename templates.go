@@ -0,0 +1,295 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// templateVarPattern matches {{name}} placeholders, allowing spaces inside
+// the braces.
+var templateVarPattern = regexp.MustCompile(`{{\s*([A-Za-z0-9_.-]+)\s*}}`)
+
+// Template is a stored message text with {{variable}} placeholders.
+type Template struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Body      string    `json:"body"`
+	Variables []string  `json:"variables"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type TemplateRequest struct {
+	Name string `json:"name"`
+	Body string `json:"body"`
+}
+
+type SendTemplateRequest struct {
+	ChatID     string            `json:"chat_id"`
+	TemplateID string            `json:"template_id"`
+	Variables  map[string]string `json:"variables"`
+	ReplyToID  string            `json:"reply_to_id,omitempty"`
+}
+
+// templateVariables lists the distinct placeholders in body, in order of
+// first use.
+func templateVariables(body string) []string {
+	vars := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, match := range templateVarPattern.FindAllStringSubmatch(body, -1) {
+		if !seen[match[1]] {
+			seen[match[1]] = true
+			vars = append(vars, match[1])
+		}
+	}
+	return vars
+}
+
+// renderTemplate substitutes vars into body. Every placeholder must have a
+// value, so that no message goes out with a raw {{name}} in it.
+func renderTemplate(body string, vars map[string]string) (string, error) {
+	var missing []string
+	for _, name := range templateVariables(body) {
+		if _, ok := vars[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return "", errors.New("missing template variables: " + strings.Join(missing, ", "))
+	}
+	return templateVarPattern.ReplaceAllStringFunc(body, func(placeholder string) string {
+		return vars[templateVarPattern.FindStringSubmatch(placeholder)[1]]
+	}), nil
+}
+
+// templateStore keeps message templates in the database.
+type templateStore struct {
+	db *sql.DB
+}
+
+var errTemplateNotFound = errors.New("template not found")
+
+func newTemplateStore(db *sql.DB) (*templateStore, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS message_templates (
+		id         TEXT PRIMARY KEY,
+		name       TEXT NOT NULL,
+		body       TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		updated_at INTEGER NOT NULL
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	return &templateStore{db: db}, nil
+}
+
+func scanTemplate(row interface{ Scan(...any) error }) (Template, error) {
+	var t Template
+	var createdAt, updatedAt int64
+	if err := row.Scan(&t.ID, &t.Name, &t.Body, &createdAt, &updatedAt); err != nil {
+		return t, err
+	}
+	t.CreatedAt = time.Unix(createdAt, 0)
+	t.UpdatedAt = time.Unix(updatedAt, 0)
+	t.Variables = templateVariables(t.Body)
+	return t, nil
+}
+
+func (s *templateStore) list() ([]Template, error) {
+	rows, err := s.db.Query(`SELECT id, name, body, created_at, updated_at FROM message_templates`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	templates := make([]Template, 0)
+	for rows.Next() {
+		t, err := scanTemplate(rows)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, t)
+	}
+	sort.Slice(templates, func(i, j int) bool {
+		return templates[i].Name < templates[j].Name
+	})
+	return templates, rows.Err()
+}
+
+func (s *templateStore) get(id string) (Template, error) {
+	t, err := scanTemplate(s.db.QueryRow(`SELECT id, name, body, created_at, updated_at
+		FROM message_templates WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return t, errTemplateNotFound
+	}
+	return t, err
+}
+
+func (s *templateStore) create(req TemplateRequest) (Template, error) {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	now := time.Now()
+	t := Template{
+		ID:        hex.EncodeToString(buf),
+		Name:      req.Name,
+		Body:      req.Body,
+		Variables: templateVariables(req.Body),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	_, err := s.db.Exec(`INSERT INTO message_templates (id, name, body, created_at, updated_at) VALUES (?, ?, ?, ?, ?)`,
+		t.ID, t.Name, t.Body, now.Unix(), now.Unix())
+	return t, err
+}
+
+func (s *templateStore) update(id string, req TemplateRequest) (Template, error) {
+	res, err := s.db.Exec(`UPDATE message_templates SET name = ?, body = ?, updated_at = ? WHERE id = ?`,
+		req.Name, req.Body, time.Now().Unix(), id)
+	if err != nil {
+		return Template{}, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return Template{}, errTemplateNotFound
+	}
+	return s.get(id)
+}
+
+func (s *templateStore) delete(id string) error {
+	res, err := s.db.Exec(`DELETE FROM message_templates WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errTemplateNotFound
+	}
+	return nil
+}
+
+func readTemplateRequest(w http.ResponseWriter, r *http.Request) (TemplateRequest, bool) {
+	var req TemplateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return req, false
+	}
+	if strings.TrimSpace(req.Name) == "" || strings.TrimSpace(req.Body) == "" {
+		http.Error(w, "name and body are required", http.StatusBadRequest)
+		return req, false
+	}
+	return req, true
+}
+
+// writeTemplate responds with t, or with the error of the store operation
+// that produced it.
+func (api *WhatsAppAPI) writeTemplate(w http.ResponseWriter, r *http.Request, t Template, err error, status int) {
+	if errors.Is(err, errTemplateNotFound) {
+		http.Error(w, "Template not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		api.requestLog(r).Errorf("Failed to access templates: %v", err)
+		http.Error(w, "Failed to access templates", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(t)
+}
+
+func (api *WhatsAppAPI) listTemplates(w http.ResponseWriter, r *http.Request) {
+	templates, err := api.templates.list()
+	if err != nil {
+		api.requestLog(r).Errorf("Failed to list templates: %v", err)
+		http.Error(w, "Failed to list templates", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(templates)
+}
+
+func (api *WhatsAppAPI) getTemplate(w http.ResponseWriter, r *http.Request) {
+	t, err := api.templates.get(mux.Vars(r)["templateId"])
+	api.writeTemplate(w, r, t, err, http.StatusOK)
+}
+
+func (api *WhatsAppAPI) createTemplate(w http.ResponseWriter, r *http.Request) {
+	req, ok := readTemplateRequest(w, r)
+	if !ok {
+		return
+	}
+	t, err := api.templates.create(req)
+	api.writeTemplate(w, r, t, err, http.StatusCreated)
+}
+
+func (api *WhatsAppAPI) updateTemplate(w http.ResponseWriter, r *http.Request) {
+	req, ok := readTemplateRequest(w, r)
+	if !ok {
+		return
+	}
+	t, err := api.templates.update(mux.Vars(r)["templateId"], req)
+	api.writeTemplate(w, r, t, err, http.StatusOK)
+}
+
+func (api *WhatsAppAPI) deleteTemplate(w http.ResponseWriter, r *http.Request) {
+	err := api.templates.delete(mux.Vars(r)["templateId"])
+	if errors.Is(err, errTemplateNotFound) {
+		http.Error(w, "Template not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		api.requestLog(r).Errorf("Failed to delete template: %v", err)
+		http.Error(w, "Failed to delete template", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// sendTemplate renders a template and sends the result like
+// POST /messages/send would.
+func (api *WhatsAppAPI) sendTemplate(w http.ResponseWriter, r *http.Request) {
+	if !api.requirePaired(w) {
+		return
+	}
+
+	var req SendTemplateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	t, err := api.templates.get(req.TemplateID)
+	if errors.Is(err, errTemplateNotFound) {
+		http.Error(w, "Template not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		api.requestLog(r).Errorf("Failed to load template %s: %v", req.TemplateID, err)
+		http.Error(w, "Failed to load template", http.StatusInternalServerError)
+		return
+	}
+	text, err := renderTemplate(t.Body, req.Variables)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response, err := api.sendText(r.Context(), api.requestLog(r), SendTextRequest{
+		ChatID:    req.ChatID,
+		Text:      text,
+		ReplyToID: req.ReplyToID,
+	})
+	if err != nil {
+		writeError(w, err, "Failed to send message")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if response.Queued {
+		w.WriteHeader(http.StatusAccepted)
+	}
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"image"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// thumbnailTimeout bounds downloading media and building its thumbnail.
+	thumbnailTimeout = time.Minute
+	// maxThumbnailJobs is how many thumbnails are built in the background at
+	// once, each of which downloads the full media.
+	maxThumbnailJobs = 2
+)
+
+var thumbnailSlots = make(chan struct{}, maxThumbnailJobs)
+
+// thumbnailStore keeps JPEG previews of image and video messages, so chat
+// UIs can show them without downloading the media.
+type thumbnailStore struct {
+	db *sql.DB
+}
+
+func newThumbnailStore(db *sql.DB) (*thumbnailStore, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS thumbnails (
+		message_id TEXT PRIMARY KEY,
+		data       BLOB NOT NULL
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	return &thumbnailStore{db: db}, nil
+}
+
+func (s *thumbnailStore) get(messageID string) ([]byte, bool, error) {
+	var data []byte
+	err := s.db.QueryRow(`SELECT data FROM thumbnails WHERE message_id = ?`, messageID).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	return data, err == nil, err
+}
+
+func (s *thumbnailStore) put(messageID string, data []byte) error {
+	_, err := s.db.Exec(`INSERT INTO thumbnails (message_id, data) VALUES (?, ?)
+		ON CONFLICT (message_id) DO UPDATE SET data = excluded.data`, messageID, data)
+	return err
+}
+
+func (s *thumbnailStore) clear() error {
+	_, err := s.db.Exec(`DELETE FROM thumbnails`)
+	return err
+}
+
+// embeddedThumbnail returns the preview WhatsApp carries in image and video
+// messages, if there is one.
+func embeddedThumbnail(msg MessageInfo) []byte {
+	if image := msg.raw.GetImageMessage(); image != nil {
+		return image.GetJPEGThumbnail()
+	}
+	return msg.raw.GetVideoMessage().GetJPEGThumbnail()
+}
+
+// videoFrame extracts the first frame of a video as PNG.
+func videoFrame(ctx context.Context, video []byte) ([]byte, error) {
+	return runFFmpeg(ctx, video, "-an", "-frames:v", "1", "-f", "image2pipe", "-codec:v", "png")
+}
+
+// buildThumbnail builds the thumbnail of an image or video from the media
+// itself. Videos need ffmpeg.
+func buildThumbnail(ctx context.Context, mediaType string, data []byte) ([]byte, error) {
+	if mediaType == "video" {
+		frame, err := videoFrame(ctx, data)
+		if err != nil {
+			return nil, err
+		}
+		data = frame
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return thumbnail(img)
+}
+
+func hasThumbnail(msg MessageInfo) bool {
+	return msg.raw != nil && (msg.Content.Type == "image" || msg.Content.Type == "video")
+}
+
+// saveThumbnail stores the thumbnail of an image or video message. The
+// preview embedded in the message is used if there is one; otherwise it is
+// built from the downloaded media, which callers should do in the
+// background.
+func (api *WhatsAppAPI) saveThumbnail(msg MessageInfo) {
+	if !hasThumbnail(msg) {
+		return
+	}
+	thumb := embeddedThumbnail(msg)
+	if len(thumb) == 0 {
+		thumbnailSlots <- struct{}{}
+		defer func() { <-thumbnailSlots }()
+
+		ctx, cancel := context.WithTimeout(context.Background(), thumbnailTimeout)
+		defer cancel()
+		data, err := api.downloadWithRetry(ctx, msg)
+		if err != nil {
+			api.log.Warnf("Failed to download media of %s for its thumbnail: %v", msg.ID, err)
+			return
+		}
+		if thumb, err = buildThumbnail(ctx, msg.Content.Type, data); err != nil {
+			api.log.Warnf("Failed to build thumbnail of %s: %v", msg.ID, err)
+			return
+		}
+	}
+	if err := api.thumbnails.put(msg.ID, thumb); err != nil {
+		api.log.Errorf("Failed to store thumbnail of %s: %v", msg.ID, err)
+	}
+}
+
+// getThumbnail returns the JPEG thumbnail of an image or video message. One
+// that hasn't been stored yet is built from the media, which needs a
+// connection.
+func (api *WhatsAppAPI) getThumbnail(w http.ResponseWriter, r *http.Request) {
+	msg, ok := api.findMessage(mux.Vars(r)["messageId"])
+	if !ok {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+	if !hasThumbnail(msg) {
+		http.Error(w, "Message is not an image or video", http.StatusBadRequest)
+		return
+	}
+
+	thumb, ok, err := api.thumbnails.get(msg.ID)
+	if err != nil {
+		api.requestLog(r).Warnf("Failed to read thumbnail of %s: %v", msg.ID, err)
+	}
+	if !ok {
+		thumb = embeddedThumbnail(msg)
+		if len(thumb) == 0 {
+			if !api.requireConnected(w) {
+				return
+			}
+			data, ok := api.downloadMedia(w, r, msg)
+			if !ok {
+				return
+			}
+			thumb, err = buildThumbnail(r.Context(), msg.Content.Type, data)
+			if errors.Is(err, errNoFFmpeg) {
+				http.Error(w, "Video thumbnails are unavailable because ffmpeg is not installed", http.StatusUnsupportedMediaType)
+				return
+			} else if err != nil {
+				api.requestLog(r).Errorf("Failed to build thumbnail of %s: %v", msg.ID, err)
+				http.Error(w, "Failed to build thumbnail", http.StatusUnprocessableEntity)
+				return
+			}
+		}
+		if err := api.thumbnails.put(msg.ID, thumb); err != nil {
+			api.requestLog(r).Warnf("Failed to store thumbnail of %s: %v", msg.ID, err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Content-Length", strconv.Itoa(len(thumb)))
+	w.Write(thumb)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	TranscriptionOpenAI     = "openai"
+	TranscriptionWhisperCpp = "whisper_cpp"
+)
+
+const (
+	defaultOpenAITranscriptionURL   = "https://api.openai.com/v1/audio/transcriptions"
+	defaultOpenAITranscriptionModel = "whisper-1"
+	// transcriptionTimeout bounds downloading and transcribing one voice
+	// note; local models on a CPU can take a while for long notes.
+	transcriptionTimeout = 2 * time.Minute
+	// maxTranscriptions is how many voice notes are transcribed at once.
+	maxTranscriptions = 2
+)
+
+// Transcription configures the backend incoming voice notes are transcribed
+// with. An empty Backend turns transcription off. The OpenAI backend reads
+// its API key from OPENAI_API_KEY, so the key isn't returned by GET /config.
+type Transcription struct {
+	Backend string `json:"backend"`
+	// URL overrides the OpenAI endpoint, e.g. for a compatible service. It
+	// is required for whisper.cpp, pointing at the server's /inference.
+	URL      string `json:"url,omitempty"`
+	Model    string `json:"model,omitempty"`
+	Language string `json:"language,omitempty"`
+}
+
+func (t Transcription) validate() error {
+	switch t.Backend {
+	case "":
+	case TranscriptionOpenAI:
+		if os.Getenv("OPENAI_API_KEY") == "" {
+			return errors.New("transcription.backend openai requires OPENAI_API_KEY to be set")
+		}
+	case TranscriptionWhisperCpp:
+		if t.URL == "" {
+			return errors.New("transcription.url is required for whisper_cpp")
+		}
+	default:
+		return errors.New("transcription.backend must be openai, whisper_cpp or empty")
+	}
+	return nil
+}
+
+// TranscriptInfo is the payload of transcript events.
+type TranscriptInfo struct {
+	MessageID string `json:"message_id"`
+	Chat      string `json:"chat"`
+	Sender    string `json:"sender"`
+	Text      string `json:"text"`
+}
+
+var (
+	transcriptionClient = &http.Client{Timeout: transcriptionTimeout}
+	transcriptionSlots  = make(chan struct{}, maxTranscriptions)
+)
+
+func (api *WhatsAppAPI) getTranscription() Transcription {
+	api.transcriptionMu.Lock()
+	defer api.transcriptionMu.Unlock()
+	return api.transcription
+}
+
+func (api *WhatsAppAPI) setTranscription(t Transcription) {
+	api.transcriptionMu.Lock()
+	api.transcription = t
+	api.transcriptionMu.Unlock()
+}
+
+// transcribeAudio sends audio to the configured backend and returns the
+// transcript. Both backends take the Ogg/Opus file as is; a whisper.cpp
+// server has to be started with --convert for that.
+func transcribeAudio(ctx context.Context, t Transcription, audio []byte) (string, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	file, err := form.CreateFormFile("file", "voice.ogg")
+	if err != nil {
+		return "", err
+	}
+	file.Write(audio)
+	form.WriteField("response_format", "json")
+	if t.Language != "" {
+		form.WriteField("language", t.Language)
+	}
+
+	target := t.URL
+	if t.Backend == TranscriptionOpenAI {
+		if target == "" {
+			target = defaultOpenAITranscriptionURL
+		}
+		model := t.Model
+		if model == "" {
+			model = defaultOpenAITranscriptionModel
+		}
+		form.WriteField("model", model)
+	}
+	if err := form.Close(); err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, &body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	if t.Backend == TranscriptionOpenAI {
+		req.Header.Set("Authorization", "Bearer "+os.Getenv("OPENAI_API_KEY"))
+	}
+
+	resp, err := transcriptionClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("unexpected status %d: %s", resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	var result struct {
+		Text string `json:"text"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(result.Text), nil
+}
+
+// transcribe transcribes an incoming voice note in the background, if a
+// backend is configured. The transcript is stored with the message and
+// announced as a transcript event.
+func (api *WhatsAppAPI) transcribe(msg MessageInfo) {
+	t := api.getTranscription()
+	if t.Backend == "" || msg.raw == nil {
+		return
+	}
+	transcriptionSlots <- struct{}{}
+	defer func() { <-transcriptionSlots }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), transcriptionTimeout)
+	defer cancel()
+	audio, err := api.downloadWithRetry(ctx, msg)
+	if err != nil {
+		api.log.Warnf("Failed to download voice note %s for transcription: %v", msg.ID, err)
+		return
+	}
+	text, err := transcribeAudio(ctx, t, audio)
+	if err != nil {
+		api.log.Warnf("Failed to transcribe voice note %s: %v", msg.ID, err)
+		return
+	}
+
+	api.updateMessage(msg.ID, func(msg *MessageInfo) {
+		msg.Content.Transcript = text
+	})
+	api.emit("transcript", TranscriptInfo{
+		MessageID: msg.ID,
+		Chat:      msg.Source.Chat,
+		Sender:    msg.Source.Sender,
+		Text:      text,
+	})
+}
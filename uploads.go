@@ -0,0 +1,266 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// uploadTTL is how long an upload is kept after its last chunk, so that
+// clients can resume after a dropped connection without uploads piling up.
+const uploadTTL = 24 * time.Hour
+
+// Upload is a file pushed in chunks ahead of sending it. Offset is how many
+// bytes have arrived; Size is the total the client announced, if any.
+type Upload struct {
+	ID        string    `json:"id"`
+	Size      int64     `json:"size,omitempty"`
+	Offset    int64     `json:"offset"`
+	Complete  bool      `json:"complete"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// busy is set while a chunk is being written.
+	busy bool
+}
+
+type CreateUploadRequest struct {
+	Size int64 `json:"size"`
+}
+
+// uploads keeps chunked uploads in temporary files. They don't survive a
+// restart.
+type uploads struct {
+	mu      sync.Mutex
+	byID    map[string]*Upload
+	dir     string
+	maxSize int64
+}
+
+var (
+	errUploadNotFound = errors.New("upload not found")
+	errUploadBusy     = errors.New("a chunk is being written")
+)
+
+func newUploads(maxSize int64) (*uploads, error) {
+	dir, err := os.MkdirTemp("", "uploads-*")
+	if err != nil {
+		return nil, err
+	}
+	return &uploads{byID: make(map[string]*Upload), dir: dir, maxSize: maxSize}, nil
+}
+
+func (u *uploads) path(id string) string {
+	return filepath.Join(u.dir, id)
+}
+
+// removeLocked drops an upload and its file. Callers must hold u.mu.
+func (u *uploads) removeLocked(id string) {
+	delete(u.byID, id)
+	os.Remove(u.path(id))
+}
+
+// expire drops uploads that haven't received a chunk within uploadTTL.
+// Callers must hold u.mu.
+func (u *uploads) expire() {
+	for id, upload := range u.byID {
+		if !upload.busy && time.Since(upload.UpdatedAt) > uploadTTL {
+			u.removeLocked(id)
+		}
+	}
+}
+
+func (u *uploads) create(size int64) (Upload, error) {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	now := time.Now()
+	upload := &Upload{ID: hex.EncodeToString(buf), Size: size, CreatedAt: now, UpdatedAt: now}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.expire()
+	if err := os.WriteFile(u.path(upload.ID), nil, 0o600); err != nil {
+		return Upload{}, err
+	}
+	u.byID[upload.ID] = upload
+	return *upload, nil
+}
+
+func (u *uploads) get(id string) (Upload, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	upload, ok := u.byID[id]
+	if !ok {
+		return Upload{}, false
+	}
+	return *upload, true
+}
+
+// read returns the data of a complete upload.
+func (u *uploads) read(id string) ([]byte, error) {
+	u.mu.Lock()
+	upload, ok := u.byID[id]
+	complete := ok && upload.Complete && !upload.busy
+	u.mu.Unlock()
+	if !ok {
+		return nil, errUploadNotFound
+	} else if !complete {
+		return nil, errors.New("upload is incomplete")
+	}
+	return os.ReadFile(u.path(id))
+}
+
+// remove discards an upload unless a chunk is being written to it.
+func (u *uploads) remove(id string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	upload, ok := u.byID[id]
+	if !ok {
+		return errUploadNotFound
+	} else if upload.busy {
+		return errUploadBusy
+	}
+	u.removeLocked(id)
+	return nil
+}
+
+// close removes all uploads.
+func (u *uploads) close() error {
+	return os.RemoveAll(u.dir)
+}
+
+func (api *WhatsAppAPI) createUpload(w http.ResponseWriter, r *http.Request) {
+	var req CreateUploadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Size < 0 {
+		http.Error(w, "Size must not be negative", http.StatusBadRequest)
+		return
+	} else if req.Size > api.uploads.maxSize {
+		http.Error(w, "Upload too large, the limit is "+strconv.FormatInt(api.uploads.maxSize, 10)+" bytes",
+			http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	upload, err := api.uploads.create(req.Size)
+	if err != nil {
+		api.requestLog(r).Errorf("Failed to create upload: %v", err)
+		http.Error(w, "Failed to create upload", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(upload)
+}
+
+func (api *WhatsAppAPI) getUpload(w http.ResponseWriter, r *http.Request) {
+	upload, ok := api.uploads.get(mux.Vars(r)["uploadId"])
+	if !ok {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(upload)
+}
+
+// appendUpload adds the request body to an upload at the offset given in
+// the Upload-Offset header, which must match the bytes received so far. A
+// chunk cut off by a dropped connection keeps what arrived, so the client
+// resumes from the offset GET /uploads/{id} reports. An empty chunk
+// finishes uploads without an announced size.
+func (api *WhatsAppAPI) appendUpload(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["uploadId"]
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		http.Error(w, "Upload-Offset header is required", http.StatusBadRequest)
+		return
+	}
+
+	api.uploads.mu.Lock()
+	upload, ok := api.uploads.byID[id]
+	var conflict string
+	switch {
+	case !ok:
+	case upload.busy:
+		conflict = "A chunk is already being written"
+	case upload.Complete:
+		conflict = "Upload is already complete"
+	case offset != upload.Offset:
+		conflict = "Upload-Offset must be " + strconv.FormatInt(upload.Offset, 10)
+	default:
+		upload.busy = true
+	}
+	api.uploads.mu.Unlock()
+	if !ok {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return
+	} else if conflict != "" {
+		http.Error(w, conflict, http.StatusConflict)
+		return
+	}
+
+	limit := api.uploads.maxSize
+	if upload.Size > 0 {
+		limit = upload.Size
+	}
+	file, err := os.OpenFile(api.uploads.path(id), os.O_WRONLY|os.O_APPEND, 0)
+	var written int64
+	if err == nil {
+		// One byte past the limit tells an oversized chunk apart.
+		written, err = io.Copy(file, io.LimitReader(r.Body, limit-offset+1))
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	tooLarge := offset+written > limit
+	if tooLarge {
+		written = limit - offset
+		os.Truncate(api.uploads.path(id), limit)
+	}
+
+	api.uploads.mu.Lock()
+	upload.busy = false
+	upload.Offset += written
+	upload.UpdatedAt = time.Now()
+	if err == nil && !tooLarge && (upload.Size == 0 && written == 0 || upload.Size > 0 && upload.Offset == upload.Size) {
+		upload.Complete = true
+	}
+	response := *upload
+	api.uploads.mu.Unlock()
+
+	if tooLarge {
+		http.Error(w, "Chunk exceeds the upload size of "+strconv.FormatInt(limit, 10)+" bytes",
+			http.StatusRequestEntityTooLarge)
+		return
+	} else if err != nil {
+		api.requestLog(r).Warnf("Upload %s interrupted at offset %d: %v", id, response.Offset, err)
+		http.Error(w, "Failed to write chunk", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (api *WhatsAppAPI) deleteUpload(w http.ResponseWriter, r *http.Request) {
+	err := api.uploads.remove(mux.Vars(r)["uploadId"])
+	if errors.Is(err, errUploadNotFound) {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "A chunk is being written", http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	waLog "go.mau.fi/whatsmeow/util/log"
+	"google.golang.org/protobuf/proto"
+)
+
+// voiceMimeType is the only format WhatsApp plays back as a voice note.
+const voiceMimeType = "audio/ogg; codecs=opus"
+
+// maxVoiceUpload limits multipart voice uploads held in memory.
+const maxVoiceUpload = 16 << 20
+
+// opusSampleRate is the granule rate of Ogg Opus streams, regardless of the
+// input sample rate.
+const opusSampleRate = 48000
+
+// waveformSamples is the number of bars WhatsApp draws for a voice note. Each
+// is a loudness from 0 to 100.
+const waveformSamples = 64
+
+type SendVoiceRequest struct {
+	ChatID string `json:"chat_id"`
+	// Audio is the Ogg/Opus file, base64 encoded in JSON.
+	Audio []byte `json:"audio"`
+	// Seconds is optional; it is read from the Ogg stream when left out.
+	Seconds   uint32 `json:"seconds"`
+	ReplyToID string `json:"reply_to_id,omitempty"`
+	// UploadID sends a completed chunked upload instead of Audio.
+	UploadID string `json:"upload_id,omitempty"`
+}
+
+// validateOpus checks that data is an Ogg container carrying Opus audio by
+// looking at the capture pattern and the identification header of the first
+// page.
+func validateOpus(data []byte) error {
+	if len(data) < 36 || !bytes.HasPrefix(data, []byte("OggS")) {
+		return errors.New("audio must be an Ogg file (voice notes require Ogg/Opus)")
+	}
+	segments := int(data[26])
+	headerLen := 27 + segments
+	if len(data) < headerLen+8 || !bytes.HasPrefix(data[headerLen:], []byte("OpusHead")) {
+		return errors.New("audio must be encoded with Opus (voice notes require Ogg/Opus)")
+	}
+	return nil
+}
+
+// opusDuration returns the length of an Ogg Opus stream in whole seconds,
+// taken from the granule position of the last page.
+func opusDuration(data []byte) uint32 {
+	last := bytes.LastIndex(data, []byte("OggS"))
+	if last < 0 || len(data) < last+14 {
+		return 0
+	}
+	granule := binary.LittleEndian.Uint64(data[last+6 : last+14])
+	return uint32((granule + opusSampleRate - 1) / opusSampleRate)
+}
+
+// voiceWaveform computes the waveform of a voice note from its audio, decoded
+// with ffmpeg to 8 kHz mono PCM. Each bar is the mean amplitude of its part
+// of the audio, scaled so that the loudest bar is 100.
+func voiceWaveform(ctx context.Context, audio []byte) ([]byte, error) {
+	pcm, err := runFFmpeg(ctx, audio, "-vn", "-ac", "1", "-ar", "8000", "-f", "s16le")
+	if err != nil {
+		return nil, err
+	}
+	samples := len(pcm) / 2
+	if samples < waveformSamples {
+		return nil, errors.New("audio is too short for a waveform")
+	}
+
+	levels := make([]float64, waveformSamples)
+	var loudest float64
+	for i := range levels {
+		start, end := i*samples/waveformSamples, (i+1)*samples/waveformSamples
+		var sum float64
+		for j := start; j < end; j++ {
+			sum += math.Abs(float64(int16(binary.LittleEndian.Uint16(pcm[j*2:]))))
+		}
+		levels[i] = sum / float64(end-start)
+		loudest = math.Max(loudest, levels[i])
+	}
+
+	waveform := make([]byte, waveformSamples)
+	if loudest > 0 {
+		for i, level := range levels {
+			waveform[i] = byte(math.Round(level / loudest * 100))
+		}
+	}
+	return waveform, nil
+}
+
+// voiceNote is audio ready to be sent as a voice note.
+type voiceNote struct {
+	Audio    []byte `json:"audio"`
+	Seconds  uint32 `json:"seconds"`
+	Waveform []byte `json:"waveform,omitempty"`
+}
+
+// prepareVoice turns uploaded audio into a voice note: other formats are
+// converted to Ogg/Opus and the voice_transcoding filters applied, if
+// ffmpeg is available. seconds is optional. The upload and the resulting
+// duration are checked against media_limits.
+func (api *WhatsAppAPI) prepareVoice(ctx context.Context, log waLog.Logger, audio []byte, seconds uint32) (voiceNote, error) {
+	limits := api.getMediaLimits()
+	if err := limits.Audio.check("Audio", len(audio), sniffMimeType(audio)); err != nil {
+		return voiceNote{}, err
+	}
+	options := api.getVoiceTranscoding()
+	if err := validateOpus(audio); err != nil {
+		opus, convertErr := transcodeToOpus(ctx, audio, options)
+		if errors.Is(convertErr, errNoFFmpeg) {
+			return voiceNote{}, &httpError{status: http.StatusUnsupportedMediaType, msg: err.Error() + "; install ffmpeg to send other formats"}
+		} else if convertErr != nil {
+			log.Warnf("Failed to convert voice message to Ogg/Opus: %v", convertErr)
+			return voiceNote{}, &httpError{status: http.StatusBadRequest, msg: "Audio could not be converted to Ogg/Opus"}
+		}
+		audio = opus
+	} else if options.filters() != "" {
+		// Ogg/Opus is only re-encoded to normalize or trim it, and sent as
+		// is if that isn't possible.
+		opus, err := transcodeToOpus(ctx, audio, options)
+		if err != nil {
+			log.Warnf("Failed to process voice message, sending it unchanged: %v", err)
+		} else {
+			audio = opus
+		}
+	}
+	// Trimming changes the duration, so it is read from the result.
+	if seconds == 0 || options.TrimSilence {
+		seconds = opusDuration(audio)
+	}
+	if limits.MaxAudioSeconds > 0 && max(seconds, opusDuration(audio)) > limits.MaxAudioSeconds {
+		return voiceNote{}, &httpError{status: http.StatusBadRequest,
+			msg: "Audio is longer than the limit of " + strconv.FormatUint(uint64(limits.MaxAudioSeconds), 10) + " seconds"}
+	}
+	// The waveform is optional; without it WhatsApp draws a flat line.
+	waveform, err := voiceWaveform(ctx, audio)
+	if err != nil && !errors.Is(err, errNoFFmpeg) {
+		log.Warnf("Failed to compute voice note waveform: %v", err)
+	}
+	return voiceNote{Audio: audio, Seconds: seconds, Waveform: waveform}, nil
+}
+
+// message builds the voice note message for an upload of its audio.
+func (n voiceNote) message(uploaded whatsmeow.UploadResponse, contextInfo *waE2E.ContextInfo) *waE2E.Message {
+	return &waE2E.Message{AudioMessage: &waE2E.AudioMessage{
+		PTT:           proto.Bool(true),
+		Seconds:       proto.Uint32(n.Seconds),
+		Mimetype:      proto.String(voiceMimeType),
+		URL:           proto.String(uploaded.URL),
+		DirectPath:    proto.String(uploaded.DirectPath),
+		MediaKey:      uploaded.MediaKey,
+		FileEncSHA256: uploaded.FileEncSHA256,
+		FileSHA256:    uploaded.FileSHA256,
+		FileLength:    proto.Uint64(uploaded.FileLength),
+		Waveform:      n.Waveform,
+		ContextInfo:   contextInfo,
+	}}
+}
+
+// readVoiceRequest accepts either a multipart form with an "audio" file or a
+// JSON body with base64 audio.
+func readVoiceRequest(r *http.Request) (SendVoiceRequest, error) {
+	var req SendVoiceRequest
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		err := json.NewDecoder(r.Body).Decode(&req)
+		return req, err
+	}
+
+	// A form without a file, only naming an upload, needn't be multipart.
+	if err := r.ParseMultipartForm(maxVoiceUpload); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		return req, err
+	}
+	req.UploadID = r.FormValue("upload_id")
+	if req.UploadID == "" {
+		file, _, err := r.FormFile("audio")
+		if err != nil {
+			return req, err
+		}
+		defer file.Close()
+		if req.Audio, err = io.ReadAll(file); err != nil {
+			return req, err
+		}
+	}
+	req.ChatID = r.FormValue("chat_id")
+	req.ReplyToID = r.FormValue("reply_to_id")
+	if raw := r.FormValue("seconds"); raw != "" {
+		seconds, err := strconv.ParseUint(raw, 10, 32)
+		if err != nil {
+			return req, err
+		}
+		req.Seconds = uint32(seconds)
+	}
+	return req, nil
+}
+
+func (api *WhatsAppAPI) sendVoiceMessage(w http.ResponseWriter, r *http.Request) {
+	if !api.requireConnected(w) {
+		return
+	}
+
+	req, err := readVoiceRequest(r)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	chatJID, err := types.ParseJID(req.ChatID)
+	if err != nil {
+		http.Error(w, "Invalid chat JID", http.StatusBadRequest)
+		return
+	}
+	if req.UploadID != "" {
+		req.Audio, err = api.uploads.read(req.UploadID)
+		if errors.Is(err, errUploadNotFound) {
+			http.Error(w, "Upload not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, "Upload can't be sent: "+err.Error(), http.StatusConflict)
+			return
+		}
+	}
+	note, err := api.prepareVoice(r.Context(), api.requestLog(r), req.Audio, req.Seconds)
+	if err != nil {
+		writeError(w, err, "Failed to process audio")
+		return
+	}
+	contextInfo, ok := api.replyContext(w, req.ReplyToID)
+	if !ok {
+		return
+	}
+
+	if !api.allowSend(w) {
+		return
+	}
+	uploaded, err := api.client.Upload(r.Context(), note.Audio, whatsmeow.MediaAudio)
+	if err != nil {
+		api.requestLog(r).Errorf("Failed to upload voice message: %v", err)
+		http.Error(w, "Failed to upload audio", http.StatusInternalServerError)
+		return
+	}
+	msg := note.message(uploaded, contextInfo)
+
+	resp, err := api.sendOutbound(r.Context(), chatJID, msg)
+	if err != nil {
+		api.requestLog(r).Errorf("Failed to send voice message to %s: %v", chatJID, err)
+		http.Error(w, "Failed to send voice message", http.StatusInternalServerError)
+		return
+	}
+
+	sent := MessageInfo{
+		ID:        resp.ID,
+		Timestamp: resp.Timestamp,
+		Source: MessageSource{
+			Chat:     chatJID.String(),
+			Sender:   api.client.Store.ID.ToNonAD().String(),
+			IsFromMe: true,
+			IsGroup:  chatJID.Server == types.GroupServer,
+		},
+		Content: extractMessageContent(msg),
+		IsRead:  true,
+		Status:  MessageStatusSent,
+		// The media key and path in the proto allow downloading it again.
+		raw: msg,
+	}
+	api.appendMessage(sent)
+	api.enqueueArchive(sent, note.Audio)
+	if req.UploadID != "" {
+		api.uploads.remove(req.UploadID)
+	}
+
+	response := SendResponse{ID: resp.ID, Timestamp: resp.Timestamp}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// webhookEvents are the event names a subscription can filter on.
+var webhookEvents = map[string]bool{
+	"message":    true,
+	"receipt":    true,
+	"presence":   true,
+	"call":       true,
+	"reaction":   true,
+	"poll_vote":  true,
+	"transcript": true,
+	"connection": true,
+}
+
+type Webhook struct {
+	ID     string   `json:"id"`
+	URL    string   `json:"url"`
+	Secret string   `json:"secret,omitempty"`
+	Events []string `json:"events"`
+}
+
+type WebhookRequest struct {
+	URL    string   `json:"url"`
+	Secret string   `json:"secret"`
+	Events []string `json:"events"`
+}
+
+type WebhooksResponse struct {
+	Webhooks []Webhook `json:"webhooks"`
+}
+
+type WebhookPayload struct {
+	Event     string      `json:"event"`
+	Timestamp time.Time   `json:"timestamp"`
+	Data      interface{} `json:"data"`
+}
+
+const (
+	webhooksKey = "webhooks"
+	// webhookQueueSize bounds deliveries waiting for a worker; events beyond
+	// it are dropped rather than blocking the whatsmeow event handler.
+	webhookQueueSize    = 256
+	webhookWorkers      = 4
+	maxWebhookAttempts  = 4
+	webhookRetryBackoff = time.Second
+)
+
+type webhookDelivery struct {
+	webhook Webhook
+	body    []byte
+	attempt int
+}
+
+// webhookDispatcher fans events out to every subscription whose filter
+// matches. Deliveries are handled by a fixed pool of workers and failed ones
+// are retried with exponential backoff.
+type webhookDispatcher struct {
+	mu       sync.RWMutex
+	webhooks []Webhook
+	client   *http.Client
+	queue    chan webhookDelivery
+	log      waLog.Logger
+}
+
+func newWebhookDispatcher(log waLog.Logger, webhooks []Webhook) *webhookDispatcher {
+	if webhooks == nil {
+		webhooks = make([]Webhook, 0)
+	}
+	d := &webhookDispatcher{
+		webhooks: webhooks,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		queue:    make(chan webhookDelivery, webhookQueueSize),
+		log:      log,
+	}
+	for i := 0; i < webhookWorkers; i++ {
+		go d.work()
+	}
+	return d
+}
+
+func (wh Webhook) wants(event string) bool {
+	// No filter means the subscription receives every event.
+	if len(wh.Events) == 0 {
+		return true
+	}
+	for _, e := range wh.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *webhookDispatcher) dispatch(event string, data interface{}) {
+	d.mu.RLock()
+	targets := make([]Webhook, 0, len(d.webhooks))
+	for _, wh := range d.webhooks {
+		if wh.wants(event) {
+			targets = append(targets, wh)
+		}
+	}
+	d.mu.RUnlock()
+
+	if len(targets) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(WebhookPayload{Event: event, Timestamp: time.Now(), Data: data})
+	if err != nil {
+		d.log.Errorf("Failed to encode %s webhook payload: %v", event, err)
+		return
+	}
+
+	for _, wh := range targets {
+		d.enqueue(webhookDelivery{webhook: wh, body: body, attempt: 1})
+	}
+}
+
+func (d *webhookDispatcher) enqueue(delivery webhookDelivery) {
+	select {
+	case d.queue <- delivery:
+	default:
+		d.log.Errorf("Webhook queue full, dropping delivery to %s", delivery.webhook.URL)
+	}
+}
+
+func (d *webhookDispatcher) work() {
+	for delivery := range d.queue {
+		err := d.deliver(delivery.webhook, delivery.body)
+		if err == nil {
+			continue
+		}
+
+		if delivery.attempt >= maxWebhookAttempts {
+			d.log.Errorf("Webhook %s delivery to %s failed permanently after %d attempts: %v",
+				delivery.webhook.ID, delivery.webhook.URL, delivery.attempt, err)
+			continue
+		}
+
+		// Wait outside the worker so one failing receiver can't hold up
+		// deliveries to the others.
+		backoff := webhookRetryBackoff << (delivery.attempt - 1)
+		d.log.Warnf("Webhook %s delivery to %s failed (attempt %d), retrying in %s: %v",
+			delivery.webhook.ID, delivery.webhook.URL, delivery.attempt, backoff, err)
+		delivery.attempt++
+		time.AfterFunc(backoff, func() { d.enqueue(delivery) })
+	}
+}
+
+func (d *webhookDispatcher) deliver(wh Webhook, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, wh.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if wh.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(wh.Secret))
+		mac.Write(body)
+		req.Header.Set("X-Webhook-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// save persists the subscriptions. Callers must hold d.mu.
+func (d *webhookDispatcher) save(settings *settingsStore) error {
+	return settings.save(webhooksKey, d.webhooks)
+}
+
+func newWebhookID() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+func (api *WhatsAppAPI) listWebhooks(w http.ResponseWriter, r *http.Request) {
+	api.webhooks.mu.RLock()
+	response := WebhooksResponse{Webhooks: make([]Webhook, len(api.webhooks.webhooks))}
+	for i, wh := range api.webhooks.webhooks {
+		// Secrets are write-only.
+		wh.Secret = ""
+		response.Webhooks[i] = wh
+	}
+	api.webhooks.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (api *WhatsAppAPI) addWebhook(w http.ResponseWriter, r *http.Request) {
+	var req WebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.URL == "" {
+		http.Error(w, "URL is required", http.StatusBadRequest)
+		return
+	}
+	if u, err := url.Parse(req.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		http.Error(w, "URL must be an absolute http or https URL", http.StatusBadRequest)
+		return
+	}
+	for _, event := range req.Events {
+		if !webhookEvents[event] {
+			http.Error(w, "Unknown event: "+event, http.StatusBadRequest)
+			return
+		}
+	}
+
+	wh := Webhook{
+		ID:     newWebhookID(),
+		URL:    req.URL,
+		Secret: req.Secret,
+		Events: req.Events,
+	}
+	if wh.Events == nil {
+		wh.Events = make([]string, 0)
+	}
+
+	api.webhooks.mu.Lock()
+	previous := api.webhooks.webhooks
+	api.webhooks.webhooks = append(previous, wh)
+	err := api.webhooks.save(api.settings)
+	if err != nil {
+		api.webhooks.webhooks = previous
+	}
+	api.webhooks.mu.Unlock()
+	if err != nil {
+		api.requestLog(r).Errorf("Failed to save webhooks: %v", err)
+		http.Error(w, "Failed to save webhook", http.StatusInternalServerError)
+		return
+	}
+
+	wh.Secret = ""
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(wh)
+}
+
+func (api *WhatsAppAPI) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["webhookId"]
+
+	api.webhooks.mu.Lock()
+	defer api.webhooks.mu.Unlock()
+
+	for i, wh := range api.webhooks.webhooks {
+		if wh.ID == id {
+			previous := api.webhooks.webhooks
+			api.webhooks.webhooks = slices.Delete(slices.Clone(previous), i, i+1)
+			if err := api.webhooks.save(api.settings); err != nil {
+				api.webhooks.webhooks = previous
+				api.requestLog(r).Errorf("Failed to save webhooks: %v", err)
+				http.Error(w, "Failed to save webhooks", http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+	}
+
+	http.Error(w, "Webhook not found", http.StatusNotFound)
+}
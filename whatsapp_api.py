@@ -1,24 +1,189 @@
-from fastapi import FastAPI, HTTPException, Depends
-from fastapi.responses import JSONResponse
-from pydantic import BaseModel
+from fastapi import FastAPI, HTTPException, Depends, Header, File, Form, UploadFile, Request
+from fastapi.responses import JSONResponse, RedirectResponse, Response, StreamingResponse
+from pydantic import BaseModel, Field
 from typing import List, Optional
 import httpx
 import asyncio
+import os
 from datetime import datetime
+from contextvars import ContextVar
 
 app = FastAPI(title="WhatsApp API Wrapper", description="FastAPI wrapper for WhatsApp Go service")
 
-GO_SERVICE_URL = "http://localhost:8080"
+GO_SERVICE_URL = os.getenv("GO_SERVICE_URL", "http://localhost:8080")
+
+# Authorization header of the request being handled. It is forwarded to the
+# Go service so that its API keys (API_KEYS) protect this API as well.
+forwarded_authorization: ContextVar[Optional[str]] = ContextVar("forwarded_authorization", default=None)
+# X-Request-ID of the request being handled, so the Go service logs it under
+# the caller's ID.
+forwarded_request_id: ContextVar[Optional[str]] = ContextVar("forwarded_request_id", default=None)
+
+@app.middleware("http")
+async def forward_authorization(request: Request, call_next):
+    token = forwarded_authorization.set(request.headers.get("Authorization"))
+    request_id_token = forwarded_request_id.set(request.headers.get("X-Request-ID"))
+    try:
+        return await call_next(request)
+    finally:
+        forwarded_request_id.reset(request_id_token)
+        forwarded_authorization.reset(token)
+
+def rate_limited(response: httpx.Response) -> HTTPException:
+    """Pass the Go service's send rate limit on to the caller"""
+    return HTTPException(
+        status_code=429,
+        detail="Send rate exceeded, retry later",
+        headers={"Retry-After": response.headers.get("Retry-After", "1")}
+    )
+
+def go_client(**kwargs) -> httpx.AsyncClient:
+    """HTTP client for the Go service carrying the caller's credentials"""
+    headers = {}
+    authorization = forwarded_authorization.get()
+    if authorization:
+        headers["Authorization"] = authorization
+    request_id = forwarded_request_id.get()
+    if request_id:
+        headers["X-Request-ID"] = request_id
+    return httpx.AsyncClient(headers=headers, **kwargs)
+
+DEFAULT_LOCALE = "en"
+
+# Preview strings shown in chat summaries for messages without text, keyed by
+# locale and message content type.
+PREVIEW_STRINGS = {
+    "en": {
+        "image": "📷 Photo",
+        "video": "🎥 Video",
+        "audio": "🎵 Audio",
+        "voice": "🎤 Voice message",
+        "document": "📄 Document",
+        "sticker": "Sticker",
+        "location": "📍 Location",
+        "contact": "👤 Contact",
+        "other": "Unsupported message",
+    },
+    "de": {
+        "image": "📷 Foto",
+        "video": "🎥 Video",
+        "audio": "🎵 Audio",
+        "voice": "🎤 Sprachnachricht",
+        "document": "📄 Dokument",
+        "sticker": "Sticker",
+        "location": "📍 Standort",
+        "contact": "👤 Kontakt",
+        "other": "Nicht unterstützte Nachricht",
+    },
+    "es": {
+        "image": "📷 Foto",
+        "video": "🎥 Video",
+        "audio": "🎵 Audio",
+        "voice": "🎤 Mensaje de voz",
+        "document": "📄 Documento",
+        "sticker": "Sticker",
+        "location": "📍 Ubicación",
+        "contact": "👤 Contacto",
+        "other": "Mensaje no compatible",
+    },
+    "fr": {
+        "image": "📷 Photo",
+        "video": "🎥 Vidéo",
+        "audio": "🎵 Audio",
+        "voice": "🎤 Message vocal",
+        "document": "📄 Document",
+        "sticker": "Autocollant",
+        "location": "📍 Position",
+        "contact": "👤 Contact",
+        "other": "Message non pris en charge",
+    },
+    "pt": {
+        "image": "📷 Foto",
+        "video": "🎥 Vídeo",
+        "audio": "🎵 Áudio",
+        "voice": "🎤 Mensagem de voz",
+        "document": "📄 Documento",
+        "sticker": "Figurinha",
+        "location": "📍 Localização",
+        "contact": "👤 Contato",
+        "other": "Mensagem não suportada",
+    },
+}
+
+def resolve_locale(locale: Optional[str], accept_language: Optional[str]) -> str:
+    """Pick a supported locale from an explicit value or an Accept-Language header"""
+    candidates = [locale] if locale else []
+    if accept_language:
+        # Accept-Language is already ordered by preference in practice, so
+        # quality values are ignored.
+        candidates += [part.split(";")[0].strip() for part in accept_language.split(",")]
+    for candidate in candidates:
+        language = candidate.lower().replace("_", "-").split("-")[0]
+        if language in PREVIEW_STRINGS:
+            return language
+    return DEFAULT_LOCALE
+
+def preview_text(content: dict, locale: str) -> str:
+    """Text to show for a message in a chat summary"""
+    if content.get("text"):
+        return content["text"]
+    strings = PREVIEW_STRINGS[locale]
+    if content.get("type") == "audio" and (content.get("media") or {}).get("ptt"):
+        return strings["voice"]
+    return strings.get(content.get("type"), strings["other"])
 
 class MessageSource(BaseModel):
     chat: str
     sender: str
+    push_name: Optional[str] = None
+    chat_name: Optional[str] = None
     is_from_me: bool
     is_group: bool
 
+class MediaInfo(BaseModel):
+    mimetype: Optional[str] = None
+    file_length: Optional[int] = None
+    duration: Optional[int] = None
+    filename: Optional[str] = None
+    ptt: bool = False
+    codec: Optional[str] = None
+    waveform: Optional[List[int]] = None
+    media_key: Optional[str] = None
+    direct_path: Optional[str] = None
+    file_sha256: Optional[str] = None
+    file_enc_sha256: Optional[str] = None
+    archive_url: Optional[str] = None
+
+class LocationInfo(BaseModel):
+    latitude: float
+    longitude: float
+    accuracy_meters: Optional[int] = None
+    speed_mps: Optional[float] = None
+    heading: Optional[int] = None
+    name: Optional[str] = None
+    address: Optional[str] = None
+    sequence: Optional[int] = None
+    time_offset: Optional[int] = None
+
+class PollInfo(BaseModel):
+    question: str
+    options: List[str]
+    selectable_count: int
+
 class MessageContent(BaseModel):
     text: Optional[str] = None
     type: str
+    quoted_id: Optional[str] = None
+    media: Optional[MediaInfo] = None
+    mentions: List[str] = []
+    location: Optional[LocationInfo] = None
+    poll: Optional[PollInfo] = None
+    transcript: Optional[str] = None
+
+class Reaction(BaseModel):
+    sender: str
+    emoji: str
+    timestamp: datetime
 
 class Message(BaseModel):
     id: str
@@ -26,13 +191,65 @@ class Message(BaseModel):
     source: MessageSource
     content: MessageContent
     is_read: bool
+    reactions: List[Reaction] = []
+    edited_at: Optional[datetime] = None
+    revoked_at: Optional[datetime] = None
+    status: Optional[str] = None
+
+class StatusChange(BaseModel):
+    status: str
+    timestamp: datetime
+
+class MessageStatus(BaseModel):
+    id: str
+    status: str
+    error: Optional[str] = None
+    history: List[StatusChange] = []
 
 class QRResponse(BaseModel):
     qr: str
 
+class PairingState(BaseModel):
+    method: str
+    phone: Optional[str] = None
+    started_at: datetime
+    restart_required: bool
+    detail: Optional[str] = None
+
 class AuthStatus(BaseModel):
     is_authenticated: bool
     phone: Optional[str] = None
+    connection_status: Optional[str] = None
+    pairing: Optional[PairingState] = None
+    reconnect: Optional[dict] = None
+    last_seen: Optional[datetime] = None
+
+class Account(BaseModel):
+    jid: str
+    lid: Optional[str] = None
+    phone: str
+    device: int
+    push_name: Optional[str] = None
+    is_business: bool
+    business_name: Optional[str] = None
+    platform: Optional[str] = None
+    registration_id: int
+    connected: bool
+
+class Contact(BaseModel):
+    jid: str
+    full_name: Optional[str] = None
+    push_name: Optional[str] = None
+    business_name: Optional[str] = None
+    is_business: bool = False
+
+class ContactsResponse(BaseModel):
+    contacts: List[Contact]
+
+class SearchRequest(BaseModel):
+    query: str
+    chat_id: Optional[str] = None
+    limit: Optional[int] = None
 
 class ReadStatusUpdate(BaseModel):
     message_id: str
@@ -40,6 +257,115 @@ class ReadStatusUpdate(BaseModel):
 
 class MessagesResponse(BaseModel):
     messages: List[Message]
+    next_cursor: Optional[str] = None
+
+class SendTextRequest(BaseModel):
+    chat_id: str
+    text: str
+    reply_to_id: Optional[str] = None
+    reply_to_last_from: Optional[str] = None
+    mentions: Optional[List[str]] = None
+    link_preview: bool = False
+
+class EditMessageRequest(BaseModel):
+    text: str
+
+class ForwardRequest(BaseModel):
+    chat_id: str
+
+class ReactRequest(BaseModel):
+    emoji: str = ""  # empty removes the reaction
+    chat_id: Optional[str] = None
+    sender: Optional[str] = None
+
+class SendResponse(BaseModel):
+    id: str
+    timestamp: datetime
+    # Set when the message waits in the outbox until the client reconnects
+    queued: bool = False
+
+class PollOptionResult(BaseModel):
+    name: str
+    votes: int
+    voters: List[str]
+
+class PollResults(BaseModel):
+    message_id: str
+    chat_id: str
+    created_at: datetime
+    question: str
+    options: List[str]
+    selectable_count: int
+    results: List[PollOptionResult]
+    total_voters: int
+
+class SendPollRequest(BaseModel):
+    chat_id: str
+    question: str
+    options: List[str]
+    multi_select: bool = False
+    reply_to_id: Optional[str] = None
+
+class LiveLocationPosition(BaseModel):
+    latitude: float
+    longitude: float
+    accuracy_meters: int = 0
+    speed_mps: float = 0
+    heading: int = 0
+
+class StartLiveLocationRequest(LiveLocationPosition):
+    chat_id: str
+    caption: Optional[str] = None
+    duration: Optional[str] = None  # Go duration string, default 15m, at most 8h
+
+class LiveLocationResponse(SendResponse):
+    share_id: str
+    sequence: int
+    expires_at: datetime
+
+class LiveLocationShare(BaseModel):
+    id: str
+    chat_id: str
+    caption: Optional[str] = None
+    started_at: datetime
+    expires_at: datetime
+    sequence: int
+
+class NumberCheck(BaseModel):
+    number: str
+    is_registered: bool
+    jid: Optional[str] = None
+    verified_name: Optional[str] = None
+
+class NumberChecksResponse(BaseModel):
+    results: List[NumberCheck]
+
+class MarkChatReadResponse(BaseModel):
+    marked: int
+
+class BroadcastRequest(BaseModel):
+    chat_ids: List[str]
+    text: str
+
+class BroadcastResult(BaseModel):
+    chat_id: str
+    status: str  # pending, sent or failed
+    message_id: Optional[str] = None
+    timestamp: Optional[datetime] = None
+    error: Optional[str] = None
+
+class Broadcast(BaseModel):
+    id: str
+    status: str  # running, completed or cancelled
+    text: str
+    created_at: datetime
+    finished_at: Optional[datetime] = None
+    results: List[BroadcastResult]
+
+class ThreadResponse(BaseModel):
+    messages: List[Message]
+    missing_ids: List[str]
+    truncated: bool
 
 class PairPhoneRequest(BaseModel):
     phone_number: str
@@ -48,8 +374,147 @@ class PairPhoneRequest(BaseModel):
 class PairCodeResponse(BaseModel):
     pair_code: str
 
+class PresenceRequest(BaseModel):
+    chat_id: str
+    state: str = "composing"
+    media: str = "text"
+
+class StatusPost(BaseModel):
+    type: str = "text"
+    text: Optional[str] = None
+    background_color: Optional[str] = None
+    text_color: Optional[str] = None
+    font: int = 0
+    media: Optional[str] = None  # base64 encoded image or video
+    mime_type: Optional[str] = None
+    caption: Optional[str] = None
+    strip_metadata: Optional[bool] = None  # overrides the strip_image_metadata config
+
+class ReconnectPolicy(BaseModel):
+    enabled: bool = True
+    initial_backoff: str = "2s"
+    max_backoff: str = "5m"
+    max_attempts: int = 0
+
+class VoiceTranscodingUpdate(BaseModel):
+    bitrate_kbps: Optional[int] = None
+    mono: Optional[bool] = None
+    normalize: Optional[bool] = None
+    trim_silence: Optional[bool] = None
+
+class BroadcastPacingUpdate(BaseModel):
+    interval: Optional[str] = None
+    jitter: Optional[str] = None
+
+class TranscriptionUpdate(BaseModel):
+    backend: Optional[str] = None  # openai, whisper_cpp or empty to disable
+    url: Optional[str] = None
+    model: Optional[str] = None
+    language: Optional[str] = None
+
+class AutoReplyUpdate(BaseModel):
+    enabled: Optional[bool] = None
+    trigger: Optional[str] = None  # messages or missed_calls
+    delay: Optional[str] = None
+    cooldown: Optional[str] = None
+
+class AutoReplyVoice(BaseModel):
+    seconds: int
+
+class ConfigUpdate(BaseModel):
+    reject_calls: Optional[bool] = None
+    connection_debounce: Optional[str] = None
+    qr_timeout: Optional[str] = None
+    send_rate: Optional[float] = None
+    send_burst: Optional[int] = None
+    reconnect_policy: Optional[ReconnectPolicy] = None
+    voice_transcoding: Optional[VoiceTranscodingUpdate] = None
+    broadcast_pacing: Optional[BroadcastPacingUpdate] = None
+    transcription: Optional[TranscriptionUpdate] = None
+    auto_reply: Optional[AutoReplyUpdate] = None
+
+class CreateUploadRequest(BaseModel):
+    size: int = 0  # total bytes, if known; 0 means the upload ends with an empty chunk
+
+class Upload(BaseModel):
+    id: str
+    size: int = 0
+    offset: int
+    complete: bool
+    created_at: datetime
+    updated_at: datetime
+
+class TemplateRequest(BaseModel):
+    name: str
+    body: str  # text with {{variable}} placeholders
+
+class Template(TemplateRequest):
+    id: str
+    variables: List[str]
+    created_at: datetime
+    updated_at: datetime
+
+class SendTemplateRequest(BaseModel):
+    chat_id: str
+    template_id: str
+    variables: dict = {}
+    reply_to_id: Optional[str] = None
+
+class WebhookCreate(BaseModel):
+    url: str
+    secret: Optional[str] = None
+    events: List[str] = []
+
+class Webhook(BaseModel):
+    id: str
+    url: str
+    events: List[str]
+
+class WebhooksResponse(BaseModel):
+    webhooks: List[Webhook]
+
+class ChatNameUpdate(BaseModel):
+    name: str = ""
+
+class GroupParticipant(BaseModel):
+    jid: str
+    name: Optional[str] = None
+    is_admin: bool
+    is_super_admin: bool
+
+class GroupInfo(BaseModel):
+    jid: str
+    name: str
+    topic: Optional[str] = None
+    owner: Optional[str] = None
+    participants: List[GroupParticipant]
+
+class GroupsResponse(BaseModel):
+    groups: List[GroupInfo]
+
+class Call(BaseModel):
+    id: str
+    from_: str = Field(alias="from")
+    timestamp: datetime
+    is_video: bool
+    outcome: str
+    end_reason: Optional[str] = None
+
+class CallsResponse(BaseModel):
+    calls: List[Call]
+
+class CallSettings(BaseModel):
+    reject_calls: bool
+
+class Presence(BaseModel):
+    chat: str
+    sender: str
+    state: str
+    media: str
+    timestamp: datetime
+
 async def get_http_client():
-    return httpx.AsyncClient(timeout=30.0)
+    return go_client(timeout=30.0)
 
 @app.get("/")
 async def root():
@@ -58,27 +523,148 @@ async def root():
 @app.get("/health")
 async def health_check():
     try:
-        async with httpx.AsyncClient() as client:
+        async with go_client() as client:
             response = await client.get(f"{GO_SERVICE_URL}/auth/status")
-            if response.status_code == 200:
-                return {"status": "healthy", "go_service": "running"}
-            else:
+            if response.status_code != 200:
                 return {"status": "degraded", "go_service": "issues"}
+
+            diagnostics = await client.get(f"{GO_SERVICE_URL}/diagnostics")
+            clock_skew = diagnostics.json().get("clock_skew") if diagnostics.status_code == 200 else None
+            if clock_skew and clock_skew.get("exceeded"):
+                return {"status": "degraded", "go_service": "running", "clock_skew": clock_skew}
+            return {"status": "healthy", "go_service": "running", "clock_skew": clock_skew}
     except Exception:
         return {"status": "unhealthy", "go_service": "down"}
 
+@app.get("/metrics")
+async def get_metrics():
+    """Prometheus metrics of the Go service"""
+    try:
+        async with go_client() as client:
+            response = await client.get(f"{GO_SERVICE_URL}/metrics")
+            if response.status_code == 200:
+                return Response(content=response.content, media_type=response.headers.get("Content-Type"))
+            else:
+                raise HTTPException(status_code=500, detail="Failed to get metrics")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.get("/diagnostics")
+async def get_diagnostics():
+    """Get connection diagnostics such as clock skew against WhatsApp servers"""
+    try:
+        async with go_client() as client:
+            response = await client.get(f"{GO_SERVICE_URL}/diagnostics")
+            if response.status_code == 200:
+                return response.json()
+            else:
+                raise HTTPException(status_code=500, detail="Failed to get diagnostics")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.get("/send-readiness")
+async def get_send_readiness(recipient: Optional[str] = None):
+    """Check in one call whether messages can be sent right now, optionally to a specific recipient"""
+    try:
+        async with go_client() as client:
+            params = {"recipient": recipient} if recipient else {}
+            response = await client.get(f"{GO_SERVICE_URL}/send-readiness", params=params)
+            if response.status_code == 200:
+                return response.json()
+            else:
+                raise HTTPException(status_code=500, detail="Failed to get send readiness")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.get("/config")
+async def get_config():
+    """Get the effective configuration"""
+    try:
+        async with go_client() as client:
+            response = await client.get(f"{GO_SERVICE_URL}/config")
+            if response.status_code == 200:
+                return response.json()
+            else:
+                raise HTTPException(status_code=500, detail="Failed to get config")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.patch("/config")
+async def patch_config(update: ConfigUpdate):
+    """Update several settings at once; nothing is applied unless all of them are valid"""
+    try:
+        async with go_client() as client:
+            response = await client.patch(f"{GO_SERVICE_URL}/config", json=update.dict(exclude_none=True))
+            if response.status_code == 200:
+                return response.json()
+            elif response.status_code == 400:
+                raise HTTPException(status_code=400, detail=response.text.strip())
+            else:
+                raise HTTPException(status_code=500, detail="Failed to update config")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.get("/reconnect-policy")
+async def get_reconnect_policy():
+    """Get the reconnection policy and the live reconnect state"""
+    try:
+        async with go_client() as client:
+            response = await client.get(f"{GO_SERVICE_URL}/reconnect-policy")
+            if response.status_code == 200:
+                return response.json()
+            else:
+                raise HTTPException(status_code=500, detail="Failed to get reconnect policy")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.put("/reconnect-policy")
+async def update_reconnect_policy(policy: ReconnectPolicy):
+    """Update the reconnection policy; it is persisted across restarts"""
+    try:
+        async with go_client() as client:
+            response = await client.put(f"{GO_SERVICE_URL}/reconnect-policy", json=policy.dict())
+            if response.status_code == 200:
+                return response.json()
+            elif response.status_code == 400:
+                raise HTTPException(status_code=400, detail=response.text.strip())
+            else:
+                raise HTTPException(status_code=500, detail="Failed to update reconnect policy")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
 @app.get("/auth/qr", response_model=QRResponse)
 async def get_qr_code():
     """Get QR code for WhatsApp authentication"""
     try:
-        async with httpx.AsyncClient() as client:
+        async with go_client() as client:
             response = await client.get(f"{GO_SERVICE_URL}/qr")
             if response.status_code == 200:
                 return response.json()
             elif response.status_code == 400:
                 raise HTTPException(status_code=400, detail="Already authenticated")
-            elif response.status_code == 408:
-                raise HTTPException(status_code=408, detail="QR generation timeout")
+            elif response.status_code == 504:
+                raise HTTPException(status_code=504, detail="QR generation timeout")
+            else:
+                raise HTTPException(status_code=500, detail="Failed to get QR code")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.get("/auth/qr.png")
+async def get_qr_image():
+    """Get the current QR code as a PNG image for direct embedding"""
+    try:
+        async with go_client() as client:
+            response = await client.get(f"{GO_SERVICE_URL}/qr.png")
+            if response.status_code == 200:
+                return Response(
+                    content=response.content,
+                    media_type="image/png",
+                    headers={"Cache-Control": response.headers.get("Cache-Control", "no-store")}
+                )
+            elif response.status_code == 204:
+                return Response(status_code=204)
+            elif response.status_code == 504:
+                raise HTTPException(status_code=504, detail="QR generation timeout")
             else:
                 raise HTTPException(status_code=500, detail="Failed to get QR code")
     except httpx.RequestError:
@@ -88,7 +674,7 @@ async def get_qr_code():
 async def get_auth_status():
     """Get current authentication status"""
     try:
-        async with httpx.AsyncClient() as client:
+        async with go_client() as client:
             response = await client.get(f"{GO_SERVICE_URL}/auth/status")
             if response.status_code == 200:
                 return response.json()
@@ -98,11 +684,14 @@ async def get_auth_status():
         raise HTTPException(status_code=503, detail="Go service unavailable")
 
 @app.post("/auth/logout")
-async def logout():
-    """Logout from WhatsApp"""
+async def logout(purge: bool = False):
+    """Logout from WhatsApp, keeping the message history unless purge is set"""
     try:
-        async with httpx.AsyncClient() as client:
-            response = await client.post(f"{GO_SERVICE_URL}/auth/logout")
+        async with go_client() as client:
+            response = await client.post(
+                f"{GO_SERVICE_URL}/auth/logout",
+                params={"purge": "true" if purge else "false"}
+            )
             if response.status_code == 200:
                 return {"message": "Logged out successfully"}
             elif response.status_code == 400:
@@ -116,7 +705,8 @@ async def logout():
 async def pair_phone(pair_request: PairPhoneRequest):
     """Generate pairing code for phone number authentication"""
     try:
-        async with httpx.AsyncClient() as client:
+        # The Go service gives up connecting after 30 seconds.
+        async with go_client(timeout=40.0) as client:
             response = await client.post(
                 f"{GO_SERVICE_URL}/auth/pair-phone",
                 json=pair_request.dict()
@@ -124,102 +714,1192 @@ async def pair_phone(pair_request: PairPhoneRequest):
             if response.status_code == 200:
                 return response.json()
             elif response.status_code == 400:
-                error_text = response.text
-                if "Already authenticated" in error_text:
-                    raise HTTPException(status_code=400, detail="Already authenticated")
-                elif "Phone number is required" in error_text:
-                    raise HTTPException(status_code=400, detail="Phone number is required")
-                else:
-                    raise HTTPException(status_code=400, detail="Invalid phone number format")
+                raise HTTPException(status_code=400, detail=response.text.strip())
+            elif response.status_code == 504:
+                raise HTTPException(status_code=504, detail="Timed out connecting to WhatsApp")
             else:
                 raise HTTPException(status_code=500, detail="Failed to generate pairing code")
     except httpx.RequestError:
         raise HTTPException(status_code=503, detail="Go service unavailable")
 
+@app.get("/auth/account", response_model=Account)
+async def get_account():
+    """Get registration details of the linked WhatsApp account"""
+    try:
+        async with go_client() as client:
+            response = await client.get(f"{GO_SERVICE_URL}/account")
+            if response.status_code == 200:
+                return response.json()
+            elif response.status_code == 401:
+                raise HTTPException(status_code=401, detail="Not authenticated")
+            else:
+                raise HTTPException(status_code=500, detail="Failed to get account")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+def page_params(limit: Optional[int], before: Optional[str], after: Optional[str]) -> dict:
+    params = {}
+    if limit is not None:
+        params["limit"] = limit
+    if before:
+        params["before"] = before
+    if after:
+        params["after"] = after
+    return params
+
 @app.get("/messages", response_model=MessagesResponse)
-async def get_messages():
-    """Get all messages"""
+async def get_messages(limit: Optional[int] = None, before: Optional[str] = None, after: Optional[str] = None):
+    """Get all messages, optionally a page at a time using before/after cursors"""
     try:
-        async with httpx.AsyncClient() as client:
-            response = await client.get(f"{GO_SERVICE_URL}/messages")
+        async with go_client() as client:
+            response = await client.get(f"{GO_SERVICE_URL}/messages", params=page_params(limit, before, after))
             if response.status_code == 200:
                 return response.json()
             elif response.status_code == 401:
                 raise HTTPException(status_code=401, detail="Not authenticated")
+            elif response.status_code == 400:
+                raise HTTPException(status_code=400, detail=response.text.strip())
             else:
                 raise HTTPException(status_code=500, detail="Failed to get messages")
     except httpx.RequestError:
         raise HTTPException(status_code=503, detail="Go service unavailable")
 
+@app.delete("/messages")
+async def delete_messages(chat_id: Optional[str] = None, before: Optional[datetime] = None, type: Optional[str] = None):
+    """Delete locally stored messages matching the given filters (not revoked on WhatsApp)"""
+    params = {}
+    if chat_id:
+        params["chat_id"] = chat_id
+    if before:
+        params["before"] = before.isoformat() if before.tzinfo else before.isoformat() + "Z"
+    if type:
+        params["type"] = type
+    if not params:
+        raise HTTPException(status_code=400, detail="At least one filter (chat_id, before, type) is required")
+
+    try:
+        async with go_client() as client:
+            response = await client.delete(f"{GO_SERVICE_URL}/messages", params=params)
+            if response.status_code == 200:
+                return response.json()
+            elif response.status_code == 401:
+                raise HTTPException(status_code=401, detail="Not authenticated")
+            elif response.status_code == 400:
+                raise HTTPException(status_code=400, detail=response.text.strip())
+            else:
+                raise HTTPException(status_code=500, detail="Failed to delete messages")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
 @app.get("/messages/{chat_id}", response_model=MessagesResponse)
-async def get_chat_messages(chat_id: str):
-    """Get messages from a specific chat"""
+async def get_chat_messages(chat_id: str, limit: Optional[int] = None, before: Optional[str] = None, after: Optional[str] = None):
+    """Get messages from a specific chat, optionally a page at a time"""
     try:
-        async with httpx.AsyncClient() as client:
-            response = await client.get(f"{GO_SERVICE_URL}/messages/{chat_id}")
+        async with go_client() as client:
+            response = await client.get(f"{GO_SERVICE_URL}/messages/{chat_id}", params=page_params(limit, before, after))
             if response.status_code == 200:
                 return response.json()
             elif response.status_code == 401:
                 raise HTTPException(status_code=401, detail="Not authenticated")
+            elif response.status_code == 400:
+                raise HTTPException(status_code=400, detail=response.text.strip())
             else:
                 raise HTTPException(status_code=500, detail="Failed to get chat messages")
     except httpx.RequestError:
         raise HTTPException(status_code=503, detail="Go service unavailable")
 
-@app.post("/messages/read-status")
-async def update_read_status(read_status: ReadStatusUpdate):
-    """Mark a message as read or unread"""
+@app.get("/messages/by-id/{message_id}", response_model=Message)
+async def get_message(message_id: str):
+    """Get a single message by its ID"""
     try:
-        async with httpx.AsyncClient() as client:
-            response = await client.post(
-                f"{GO_SERVICE_URL}/messages/read-status",
-                json=read_status.dict()
-            )
+        async with go_client() as client:
+            response = await client.get(f"{GO_SERVICE_URL}/messages/by-id/{message_id}")
             if response.status_code == 200:
-                return {"message": "Read status updated successfully"}
+                return response.json()
             elif response.status_code == 401:
                 raise HTTPException(status_code=401, detail="Not authenticated")
             elif response.status_code == 404:
                 raise HTTPException(status_code=404, detail="Message not found")
-            elif response.status_code == 400:
-                raise HTTPException(status_code=400, detail="Invalid request")
             else:
-                raise HTTPException(status_code=500, detail="Failed to update read status")
+                raise HTTPException(status_code=500, detail="Failed to get message")
     except httpx.RequestError:
         raise HTTPException(status_code=503, detail="Go service unavailable")
 
-@app.get("/chats")
-async def get_chats():
-    """Get list of all chats with latest message info"""
-    try:
-        async with httpx.AsyncClient() as client:
-            response = await client.get(f"{GO_SERVICE_URL}/messages")
-            if response.status_code == 200:
-                data = response.json()
-                messages = data.get("messages", [])
-                
-                # Group messages by chat and get latest message for each
-                chats = {}
-                for message in messages:
-                    chat_id = message["source"]["chat"]
-                    if chat_id not in chats or message["timestamp"] > chats[chat_id]["latest_timestamp"]:
-                        chats[chat_id] = {
-                            "chat_id": chat_id,
-                            "is_group": message["source"]["is_group"],
-                            "latest_message": message["content"]["text"] or f"[{message['content']['type']}]",
-                            "latest_timestamp": message["timestamp"],
-                            "unread_count": 0
-                        }
-                
-                # Count unread messages
-                for message in messages:
-                    chat_id = message["source"]["chat"]
-                    if not message["is_read"] and not message["source"]["is_from_me"]:
-                        chats[chat_id]["unread_count"] += 1
-                
-                return {"chats": list(chats.values())}
+@app.get("/messages/{message_id}/status", response_model=MessageStatus)
+async def get_message_status(message_id: str):
+    """Get the delivery status (sent, delivered, read, or pending/failed while in the outbox) of a message we sent"""
+    try:
+        async with go_client() as client:
+            response = await client.get(f"{GO_SERVICE_URL}/messages/{message_id}/status")
+            if response.status_code == 200:
+                return response.json()
+            elif response.status_code == 401:
+                raise HTTPException(status_code=401, detail="Not authenticated")
+            elif response.status_code in (400, 404):
+                raise HTTPException(status_code=response.status_code, detail=response.text.strip())
+            else:
+                raise HTTPException(status_code=500, detail="Failed to get message status")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.get("/messages/{message_id}/thread", response_model=ThreadResponse)
+async def get_message_thread(message_id: str):
+    """Get the reply thread a message belongs to"""
+    try:
+        async with go_client() as client:
+            response = await client.get(f"{GO_SERVICE_URL}/messages/{message_id}/thread")
+            if response.status_code == 200:
+                return response.json()
+            elif response.status_code == 401:
+                raise HTTPException(status_code=401, detail="Not authenticated")
+            elif response.status_code == 404:
+                raise HTTPException(status_code=404, detail="Message not found")
+            else:
+                raise HTTPException(status_code=500, detail="Failed to get message thread")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.post("/messages/send", response_model=SendResponse)
+async def send_text_message(send_request: SendTextRequest):
+    """Send a text message, optionally quoting a message by ID or the last message from a sender"""
+    try:
+        async with go_client() as client:
+            response = await client.post(
+                f"{GO_SERVICE_URL}/messages/send",
+                json=send_request.dict(exclude_none=True)
+            )
+            if response.status_code == 200:
+                return response.json()
+            elif response.status_code == 202:
+                return JSONResponse(status_code=202, content=response.json())
+            elif response.status_code == 429:
+                raise rate_limited(response)
+            elif response.status_code == 401:
+                raise HTTPException(status_code=401, detail="Not authenticated")
+            elif response.status_code in (400, 404, 409):
+                raise HTTPException(status_code=response.status_code, detail=response.text.strip())
+            else:
+                raise HTTPException(status_code=500, detail="Failed to send message")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.post("/messages/broadcast", response_model=Broadcast, status_code=202)
+async def broadcast_message(broadcast_request: BroadcastRequest):
+    """Queue the same text for several chats; poll the broadcast for results"""
+    try:
+        async with go_client() as client:
+            response = await client.post(
+                f"{GO_SERVICE_URL}/messages/broadcast",
+                json=broadcast_request.dict()
+            )
+            if response.status_code == 202:
+                return response.json()
             elif response.status_code == 401:
                 raise HTTPException(status_code=401, detail="Not authenticated")
+            elif response.status_code in (400, 409):
+                raise HTTPException(status_code=response.status_code, detail=response.text.strip())
+            else:
+                raise HTTPException(status_code=500, detail="Failed to broadcast message")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.get("/broadcasts/{broadcast_id}", response_model=Broadcast)
+async def get_broadcast(broadcast_id: str):
+    """Get the progress and per-chat results of a broadcast"""
+    try:
+        async with go_client() as client:
+            response = await client.get(f"{GO_SERVICE_URL}/broadcasts/{broadcast_id}")
+            if response.status_code == 200:
+                return response.json()
+            elif response.status_code == 404:
+                raise HTTPException(status_code=404, detail="Broadcast not found")
+            else:
+                raise HTTPException(status_code=500, detail="Failed to get broadcast")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.delete("/broadcasts/{broadcast_id}", status_code=204)
+async def cancel_broadcast(broadcast_id: str):
+    """Cancel a running broadcast"""
+    try:
+        async with go_client() as client:
+            response = await client.delete(f"{GO_SERVICE_URL}/broadcasts/{broadcast_id}")
+            if response.status_code == 204:
+                return Response(status_code=204)
+            elif response.status_code in (404, 409):
+                raise HTTPException(status_code=response.status_code, detail=response.text.strip())
+            else:
+                raise HTTPException(status_code=500, detail="Failed to cancel broadcast")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.get("/media/{message_id}")
+async def get_media(message_id: str):
+    """Download the decrypted media of a message"""
+    try:
+        async with go_client(timeout=60.0) as client:
+            response = await client.get(f"{GO_SERVICE_URL}/media/{message_id}")
+            if response.status_code == 200:
+                headers = {}
+                if "Content-Disposition" in response.headers:
+                    headers["Content-Disposition"] = response.headers["Content-Disposition"]
+                return Response(
+                    content=response.content,
+                    media_type=response.headers.get("Content-Type", "application/octet-stream"),
+                    headers=headers
+                )
+            elif response.status_code == 307:
+                # Archived media is served from the media store directly.
+                return RedirectResponse(response.headers["Location"], status_code=307)
+            elif response.status_code in (400, 401, 404, 409, 410):
+                raise HTTPException(status_code=response.status_code, detail=response.text.strip())
+            else:
+                raise HTTPException(status_code=500, detail="Failed to download media")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.get("/media/{message_id}/audio")
+async def get_audio(message_id: str, format: str = "ogg"):
+    """Get the audio of a voice note or audio message as Ogg/Opus or MP3"""
+    try:
+        # Downloading and converting long recordings takes a while.
+        async with go_client(timeout=90.0) as client:
+            response = await client.get(f"{GO_SERVICE_URL}/media/{message_id}/audio", params={"format": format})
+            if response.status_code == 200:
+                return Response(content=response.content, media_type=response.headers.get("Content-Type", "audio/ogg"))
+            elif response.status_code == 307:
+                return RedirectResponse(response.headers["Location"], status_code=307)
+            elif response.status_code in (400, 401, 404, 409, 410, 415):
+                raise HTTPException(status_code=response.status_code, detail=response.text.strip())
+            else:
+                raise HTTPException(status_code=500, detail="Failed to get audio")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.get("/media/{message_id}/thumbnail")
+async def get_thumbnail(message_id: str):
+    """Get a small JPEG preview of an image or video message"""
+    try:
+        async with go_client(timeout=60.0) as client:
+            response = await client.get(f"{GO_SERVICE_URL}/media/{message_id}/thumbnail")
+            if response.status_code == 200:
+                return Response(content=response.content, media_type="image/jpeg")
+            elif response.status_code in (400, 401, 404, 409, 410, 415, 422):
+                raise HTTPException(status_code=response.status_code, detail=response.text.strip())
+            else:
+                raise HTTPException(status_code=500, detail="Failed to get thumbnail")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.post("/messages/{message_id}/edit", response_model=SendResponse)
+async def edit_message(message_id: str, edit_request: EditMessageRequest):
+    """Edit the text or media caption of a message sent by this account"""
+    try:
+        async with go_client() as client:
+            response = await client.post(
+                f"{GO_SERVICE_URL}/messages/{message_id}/edit",
+                json=edit_request.dict()
+            )
+            if response.status_code == 200:
+                return response.json()
+            elif response.status_code == 429:
+                raise rate_limited(response)
+            elif response.status_code == 401:
+                raise HTTPException(status_code=401, detail="Not authenticated")
+            elif response.status_code in (400, 403, 404):
+                raise HTTPException(status_code=response.status_code, detail=response.text.strip())
+            else:
+                raise HTTPException(status_code=500, detail="Failed to edit message")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.post("/messages/{message_id}/forward", response_model=SendResponse)
+async def forward_message(message_id: str, forward_request: ForwardRequest):
+    """Forward a stored text or media message to another chat"""
+    try:
+        async with go_client(timeout=60.0) as client:
+            response = await client.post(
+                f"{GO_SERVICE_URL}/messages/{message_id}/forward",
+                json=forward_request.dict()
+            )
+            if response.status_code == 200:
+                return response.json()
+            elif response.status_code == 429:
+                raise rate_limited(response)
+            elif response.status_code == 401:
+                raise HTTPException(status_code=401, detail="Not authenticated")
+            elif response.status_code in (400, 404, 409, 410):
+                raise HTTPException(status_code=response.status_code, detail=response.text.strip())
+            else:
+                raise HTTPException(status_code=500, detail="Failed to forward message")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.post("/messages/{message_id}/react", response_model=SendResponse)
+async def react_to_message(message_id: str, react_request: ReactRequest):
+    """React to a message, or remove this account's reaction with an empty emoji"""
+    try:
+        async with go_client() as client:
+            response = await client.post(
+                f"{GO_SERVICE_URL}/messages/{message_id}/react",
+                json=react_request.dict(exclude_none=True)
+            )
+            if response.status_code == 200:
+                return response.json()
+            elif response.status_code == 429:
+                raise rate_limited(response)
+            elif response.status_code == 401:
+                raise HTTPException(status_code=401, detail="Not authenticated")
+            elif response.status_code in (400, 404, 409):
+                raise HTTPException(status_code=response.status_code, detail=response.text.strip())
+            else:
+                raise HTTPException(status_code=500, detail="Failed to send reaction")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.delete("/messages/{message_id}", response_model=SendResponse)
+async def revoke_message(message_id: str):
+    """Delete a message sent by this account for everyone in the chat"""
+    try:
+        async with go_client() as client:
+            response = await client.delete(f"{GO_SERVICE_URL}/messages/{message_id}")
+            if response.status_code == 200:
+                return response.json()
+            elif response.status_code == 429:
+                raise rate_limited(response)
+            elif response.status_code == 401:
+                raise HTTPException(status_code=401, detail="Not authenticated")
+            elif response.status_code in (400, 403, 404, 409):
+                raise HTTPException(status_code=response.status_code, detail=response.text.strip())
+            else:
+                raise HTTPException(status_code=500, detail="Failed to revoke message")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.post("/messages/send-voice", response_model=SendResponse)
+async def send_voice_message(
+    chat_id: str = Form(...),
+    audio: Optional[UploadFile] = File(None),
+    upload_id: Optional[str] = Form(None),
+    seconds: Optional[int] = Form(None),
+    reply_to_id: Optional[str] = Form(None)
+):
+    """Send an audio file, or a completed chunked upload, as a voice note (PTT), optionally as a reply"""
+    if audio is None and not upload_id:
+        raise HTTPException(status_code=400, detail="audio or upload_id is required")
+    data = {"chat_id": chat_id}
+    if seconds is not None:
+        data["seconds"] = str(seconds)
+    if reply_to_id:
+        data["reply_to_id"] = reply_to_id
+    files = None
+    if upload_id:
+        data["upload_id"] = upload_id
+    else:
+        files = {"audio": (audio.filename, await audio.read(), audio.content_type)}
+    try:
+        async with go_client(timeout=60.0) as client:
+            response = await client.post(
+                f"{GO_SERVICE_URL}/messages/send-voice",
+                data=data,
+                files=files
+            )
+            if response.status_code == 200:
+                return response.json()
+            elif response.status_code == 429:
+                raise rate_limited(response)
+            elif response.status_code == 401:
+                raise HTTPException(status_code=401, detail="Not authenticated")
+            elif response.status_code in (400, 404, 409, 413, 415):
+                raise HTTPException(status_code=response.status_code, detail=response.text.strip())
+            else:
+                raise HTTPException(status_code=500, detail="Failed to send voice message")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.post("/messages/send-image", response_model=SendResponse)
+async def send_image_message(
+    chat_id: str = Form(...),
+    image: UploadFile = File(...),
+    caption: Optional[str] = Form(None),
+    reply_to_id: Optional[str] = Form(None),
+    strip_metadata: Optional[bool] = Form(None)
+):
+    """Send a JPEG or PNG image with an optional caption, optionally as a reply"""
+    data = {"chat_id": chat_id}
+    if caption:
+        data["caption"] = caption
+    if reply_to_id:
+        data["reply_to_id"] = reply_to_id
+    if strip_metadata is not None:
+        data["strip_metadata"] = str(strip_metadata).lower()
+    try:
+        async with go_client(timeout=60.0) as client:
+            response = await client.post(
+                f"{GO_SERVICE_URL}/messages/send-image",
+                data=data,
+                files={"image": (image.filename, await image.read(), image.content_type)}
+            )
+            if response.status_code == 200:
+                return response.json()
+            elif response.status_code == 429:
+                raise rate_limited(response)
+            elif response.status_code == 401:
+                raise HTTPException(status_code=401, detail="Not authenticated")
+            elif response.status_code in (400, 404, 409, 413, 415):
+                raise HTTPException(status_code=response.status_code, detail=response.text.strip())
+            else:
+                raise HTTPException(status_code=500, detail="Failed to send image")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.post("/messages/send-template", response_model=SendResponse)
+async def send_template(request: SendTemplateRequest):
+    """Render a message template with the given variables and send it"""
+    try:
+        async with go_client() as client:
+            response = await client.post(
+                f"{GO_SERVICE_URL}/messages/send-template",
+                json=request.dict(exclude_none=True)
+            )
+            if response.status_code == 200:
+                return response.json()
+            elif response.status_code == 202:
+                return JSONResponse(status_code=202, content=response.json())
+            elif response.status_code == 429:
+                raise rate_limited(response)
+            elif response.status_code == 401:
+                raise HTTPException(status_code=401, detail="Not authenticated")
+            elif response.status_code in (400, 404, 409):
+                raise HTTPException(status_code=response.status_code, detail=response.text.strip())
+            else:
+                raise HTTPException(status_code=500, detail="Failed to send message")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.post("/messages/send-poll", response_model=SendResponse)
+async def send_poll(request: SendPollRequest):
+    """Create a poll with 2 to 12 options, single or multiple choice"""
+    try:
+        async with go_client() as client:
+            response = await client.post(
+                f"{GO_SERVICE_URL}/messages/send-poll",
+                json=request.dict(exclude_none=True)
+            )
+            if response.status_code == 200:
+                return response.json()
+            elif response.status_code == 429:
+                raise rate_limited(response)
+            elif response.status_code == 401:
+                raise HTTPException(status_code=401, detail="Not authenticated")
+            elif response.status_code in (400, 404, 409):
+                raise HTTPException(status_code=response.status_code, detail=response.text.strip())
+            else:
+                raise HTTPException(status_code=500, detail="Failed to send poll")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.get("/polls/{message_id}/results", response_model=PollResults)
+async def get_poll_results(message_id: str):
+    """Get vote counts and voters for each option of a poll"""
+    try:
+        async with go_client() as client:
+            response = await client.get(f"{GO_SERVICE_URL}/polls/{message_id}/results")
+            if response.status_code == 200:
+                return response.json()
+            elif response.status_code == 404:
+                raise HTTPException(status_code=404, detail="Poll not found")
+            else:
+                raise HTTPException(status_code=500, detail="Failed to get poll results")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.post("/messages/send-live-location", response_model=LiveLocationResponse)
+async def start_live_location(request: StartLiveLocationRequest):
+    """Start sharing a live location with a chat for a limited duration"""
+    try:
+        async with go_client() as client:
+            response = await client.post(
+                f"{GO_SERVICE_URL}/messages/send-live-location",
+                json=request.dict(exclude_none=True)
+            )
+            if response.status_code == 200:
+                return response.json()
+            elif response.status_code == 429:
+                raise rate_limited(response)
+            elif response.status_code == 401:
+                raise HTTPException(status_code=401, detail="Not authenticated")
+            elif response.status_code in (400, 409):
+                raise HTTPException(status_code=response.status_code, detail=response.text.strip())
+            else:
+                raise HTTPException(status_code=500, detail="Failed to send live location")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.get("/live-locations", response_model=List[LiveLocationShare])
+async def get_live_locations():
+    """List live location shares that haven't expired"""
+    try:
+        async with go_client() as client:
+            response = await client.get(f"{GO_SERVICE_URL}/live-locations")
+            if response.status_code == 200:
+                return response.json()
+            else:
+                raise HTTPException(status_code=500, detail="Failed to get live locations")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.put("/live-locations/{share_id}", response_model=LiveLocationResponse)
+async def update_live_location(share_id: str, position: LiveLocationPosition):
+    """Send a new position for an active live location share"""
+    try:
+        async with go_client() as client:
+            response = await client.put(
+                f"{GO_SERVICE_URL}/live-locations/{share_id}",
+                json=position.dict()
+            )
+            if response.status_code == 200:
+                return response.json()
+            elif response.status_code == 429:
+                raise rate_limited(response)
+            elif response.status_code == 401:
+                raise HTTPException(status_code=401, detail="Not authenticated")
+            elif response.status_code in (400, 404, 409, 410):
+                raise HTTPException(status_code=response.status_code, detail=response.text.strip())
+            else:
+                raise HTTPException(status_code=500, detail="Failed to update live location")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.delete("/live-locations/{share_id}", status_code=204)
+async def stop_live_location(share_id: str):
+    """Stop a live location share before it expires"""
+    try:
+        async with go_client() as client:
+            response = await client.delete(f"{GO_SERVICE_URL}/live-locations/{share_id}")
+            if response.status_code == 204:
+                return Response(status_code=204)
+            elif response.status_code == 404:
+                raise HTTPException(status_code=404, detail="Live location share not found")
+            else:
+                raise HTTPException(status_code=500, detail="Failed to stop live location")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.post("/messages/search", response_model=MessagesResponse)
+async def search_messages(search_request: SearchRequest):
+    """Search message text and captions, best matches first"""
+    try:
+        async with go_client() as client:
+            response = await client.post(
+                f"{GO_SERVICE_URL}/messages/search",
+                json=search_request.dict(exclude_none=True)
+            )
+            if response.status_code == 200:
+                return response.json()
+            elif response.status_code == 401:
+                raise HTTPException(status_code=401, detail="Not authenticated")
+            elif response.status_code == 400:
+                raise HTTPException(status_code=400, detail=response.text.strip())
+            else:
+                raise HTTPException(status_code=500, detail="Failed to search messages")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.post("/messages/read-status")
+async def update_read_status(read_status: ReadStatusUpdate):
+    """Mark a message as read or unread"""
+    try:
+        async with go_client() as client:
+            response = await client.post(
+                f"{GO_SERVICE_URL}/messages/read-status",
+                json=read_status.dict()
+            )
+            if response.status_code == 200:
+                return {"message": "Read status updated successfully"}
+            elif response.status_code == 401:
+                raise HTTPException(status_code=401, detail="Not authenticated")
+            elif response.status_code == 404:
+                raise HTTPException(status_code=404, detail="Message not found")
+            elif response.status_code == 400:
+                raise HTTPException(status_code=400, detail="Invalid request")
+            elif response.status_code == 409:
+                raise HTTPException(status_code=409, detail="Not connected to WhatsApp")
+            else:
+                raise HTTPException(status_code=500, detail="Failed to update read status")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.post("/status")
+async def post_status(status: StatusPost):
+    """Post a text, image or video status update"""
+    try:
+        async with go_client(timeout=60.0) as client:
+            response = await client.post(
+                f"{GO_SERVICE_URL}/status",
+                json=status.dict(exclude_none=True)
+            )
+            if response.status_code == 200:
+                return response.json()
+            elif response.status_code == 429:
+                raise rate_limited(response)
+            elif response.status_code == 401:
+                raise HTTPException(status_code=401, detail="Not authenticated")
+            elif response.status_code in (400, 413, 415):
+                raise HTTPException(status_code=response.status_code, detail=response.text.strip())
+            else:
+                raise HTTPException(status_code=500, detail="Failed to post status")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.get("/status", response_model=MessagesResponse)
+async def get_statuses():
+    """Get status updates posted by contacts"""
+    try:
+        async with go_client() as client:
+            response = await client.get(f"{GO_SERVICE_URL}/status")
+            if response.status_code == 200:
+                return response.json()
+            elif response.status_code == 401:
+                raise HTTPException(status_code=401, detail="Not authenticated")
+            else:
+                raise HTTPException(status_code=500, detail="Failed to get statuses")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.post("/presence")
+async def send_presence(presence_request: PresenceRequest):
+    """Send a typing (media "text") or recording (media "audio") indicator to a chat"""
+    try:
+        async with go_client() as client:
+            response = await client.post(
+                f"{GO_SERVICE_URL}/presence",
+                json=presence_request.dict()
+            )
+            if response.status_code == 200:
+                return {"message": "Presence sent successfully"}
+            elif response.status_code == 401:
+                raise HTTPException(status_code=401, detail="Not authenticated")
+            elif response.status_code == 400:
+                raise HTTPException(status_code=400, detail=response.text.strip())
+            else:
+                raise HTTPException(status_code=500, detail="Failed to send presence")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.get("/presence/{chat_id}", response_model=Presence)
+async def get_presence(chat_id: str):
+    """Get the latest typing/recording presence for a chat"""
+    try:
+        async with go_client() as client:
+            response = await client.get(f"{GO_SERVICE_URL}/presence/{chat_id}")
+            if response.status_code == 200:
+                return response.json()
+            elif response.status_code == 401:
+                raise HTTPException(status_code=401, detail="Not authenticated")
+            elif response.status_code == 404:
+                raise HTTPException(status_code=404, detail="No presence for chat")
+            else:
+                raise HTTPException(status_code=500, detail="Failed to get presence")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.put("/chats/{chat_id}/name")
+async def set_chat_name(chat_id: str, update: ChatNameUpdate):
+    """Set a local display name for a chat; an empty name clears it"""
+    try:
+        async with go_client() as client:
+            response = await client.put(f"{GO_SERVICE_URL}/chats/{chat_id}/name", json=update.dict())
+            if response.status_code == 200:
+                return {"message": "Chat name updated successfully"}
+            elif response.status_code == 401:
+                raise HTTPException(status_code=401, detail="Not authenticated")
+            elif response.status_code == 400:
+                raise HTTPException(status_code=400, detail="Invalid request")
+            else:
+                raise HTTPException(status_code=500, detail="Failed to update chat name")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.post("/chats/{chat_id}/mark-read", response_model=MarkChatReadResponse)
+async def mark_chat_read(chat_id: str):
+    """Mark every unread message in a chat as read and send read receipts"""
+    try:
+        async with go_client() as client:
+            response = await client.post(f"{GO_SERVICE_URL}/chats/{chat_id}/mark-read")
+            if response.status_code == 200:
+                return response.json()
+            elif response.status_code == 401:
+                raise HTTPException(status_code=401, detail="Not authenticated")
+            elif response.status_code in (400, 409):
+                raise HTTPException(status_code=response.status_code, detail=response.text.strip())
+            else:
+                raise HTTPException(status_code=500, detail="Failed to mark chat as read")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.get("/groups", response_model=GroupsResponse)
+async def get_groups(enrich: bool = False):
+    """List the groups this account is a member of"""
+    try:
+        async with go_client(timeout=30.0) as client:
+            response = await client.get(
+                f"{GO_SERVICE_URL}/groups",
+                params={"enrich": "true" if enrich else "false"}
+            )
+            if response.status_code == 200:
+                return response.json()
+            elif response.status_code == 401:
+                raise HTTPException(status_code=401, detail="Not authenticated")
+            elif response.status_code == 409:
+                raise HTTPException(status_code=409, detail="Not connected to WhatsApp")
+            else:
+                raise HTTPException(status_code=500, detail="Failed to get groups")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.get("/groups/{group_id}", response_model=GroupInfo)
+async def get_group_info(group_id: str, enrich: bool = False):
+    """Get group info; with enrich=true participants include display names"""
+    try:
+        async with go_client() as client:
+            response = await client.get(
+                f"{GO_SERVICE_URL}/groups/{group_id}",
+                params={"enrich": "true" if enrich else "false"}
+            )
+            if response.status_code == 200:
+                return response.json()
+            elif response.status_code == 401:
+                raise HTTPException(status_code=401, detail="Not authenticated")
+            elif response.status_code == 400:
+                raise HTTPException(status_code=400, detail="Invalid group JID")
+            else:
+                raise HTTPException(status_code=500, detail="Failed to get group info")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.get("/calls", response_model=CallsResponse, response_model_by_alias=True)
+async def get_calls():
+    """Get the log of incoming calls, including missed and rejected ones"""
+    try:
+        async with go_client() as client:
+            response = await client.get(f"{GO_SERVICE_URL}/calls")
+            if response.status_code == 200:
+                return response.json()
+            elif response.status_code == 401:
+                raise HTTPException(status_code=401, detail="Not authenticated")
+            else:
+                raise HTTPException(status_code=500, detail="Failed to get calls")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.post("/calls/settings")
+async def update_call_settings(settings: CallSettings):
+    """Enable or disable automatic rejection of incoming calls"""
+    try:
+        async with go_client() as client:
+            response = await client.post(f"{GO_SERVICE_URL}/calls/settings", json=settings.dict())
+            if response.status_code == 200:
+                return {"message": "Call settings updated successfully"}
+            else:
+                raise HTTPException(status_code=500, detail="Failed to update call settings")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.get("/auto-reply/voice")
+async def get_auto_reply_voice():
+    """Get the voice note sent as auto-reply"""
+    try:
+        async with go_client() as client:
+            response = await client.get(f"{GO_SERVICE_URL}/auto-reply/voice")
+            if response.status_code == 200:
+                return Response(content=response.content, media_type=response.headers.get("Content-Type", "audio/ogg"))
+            elif response.status_code == 404:
+                raise HTTPException(status_code=404, detail="No auto-reply voice note is recorded")
+            else:
+                raise HTTPException(status_code=500, detail="Failed to get auto-reply voice note")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.put("/auto-reply/voice", response_model=AutoReplyVoice)
+async def set_auto_reply_voice(
+    audio: Optional[UploadFile] = File(None),
+    upload_id: Optional[str] = Form(None),
+    seconds: Optional[int] = Form(None)
+):
+    """Record the voice note sent as auto-reply, from an audio file or a completed chunked upload"""
+    if audio is None and not upload_id:
+        raise HTTPException(status_code=400, detail="audio or upload_id is required")
+    data = {}
+    if seconds is not None:
+        data["seconds"] = str(seconds)
+    files = None
+    if upload_id:
+        data["upload_id"] = upload_id
+    else:
+        files = {"audio": (audio.filename, await audio.read(), audio.content_type)}
+    try:
+        async with go_client(timeout=60.0) as client:
+            response = await client.put(f"{GO_SERVICE_URL}/auto-reply/voice", data=data, files=files)
+            if response.status_code == 200:
+                return response.json()
+            elif response.status_code in (400, 404, 409, 413, 415):
+                raise HTTPException(status_code=response.status_code, detail=response.text.strip())
+            else:
+                raise HTTPException(status_code=500, detail="Failed to save auto-reply voice note")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.delete("/auto-reply/voice", status_code=204)
+async def delete_auto_reply_voice():
+    """Remove the auto-reply voice note"""
+    try:
+        async with go_client() as client:
+            response = await client.delete(f"{GO_SERVICE_URL}/auto-reply/voice")
+            if response.status_code == 204:
+                return Response(status_code=204)
+            else:
+                raise HTTPException(status_code=500, detail="Failed to delete auto-reply voice note")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.get("/outbox")
+async def get_outbox(status: Optional[str] = None):
+    """List outbound messages that are pending or failed, with their attempt history"""
+    try:
+        async with go_client() as client:
+            params = {"status": status} if status else {}
+            response = await client.get(f"{GO_SERVICE_URL}/outbox", params=params)
+            if response.status_code == 200:
+                return response.json()
+            elif response.status_code == 400:
+                raise HTTPException(status_code=400, detail=response.text.strip())
+            else:
+                raise HTTPException(status_code=500, detail="Failed to get outbox")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.post("/outbox/{entry_id}/retry")
+async def retry_outbox_entry(entry_id: str):
+    """Immediately retry a failed outbound message"""
+    try:
+        async with go_client() as client:
+            response = await client.post(f"{GO_SERVICE_URL}/outbox/{entry_id}/retry")
+            if response.status_code == 200:
+                return response.json()
+            elif response.status_code == 429:
+                raise rate_limited(response)
+            elif response.status_code == 401:
+                raise HTTPException(status_code=401, detail="Not authenticated")
+            elif response.status_code == 404:
+                raise HTTPException(status_code=404, detail="Outbox entry not found")
+            elif response.status_code == 409:
+                raise HTTPException(status_code=409, detail="Entry is already being sent")
+            else:
+                raise HTTPException(status_code=502, detail=response.text.strip())
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.delete("/outbox/{entry_id}")
+async def cancel_outbox_entry(entry_id: str):
+    """Cancel a pending or failed outbound message"""
+    try:
+        async with go_client() as client:
+            response = await client.delete(f"{GO_SERVICE_URL}/outbox/{entry_id}")
+            if response.status_code == 200:
+                return {"message": "Outbox entry cancelled"}
+            elif response.status_code == 404:
+                raise HTTPException(status_code=404, detail="Outbox entry not found")
+            else:
+                raise HTTPException(status_code=500, detail="Failed to cancel outbox entry")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.get("/events")
+async def stream_events(events: Optional[str] = None):
+    """Stream events (messages, receipts, status changes, ...) as server-sent events"""
+    params = {"events": events} if events else {}
+    # Created up front, as the stream is relayed after this handler returns.
+    client = go_client(timeout=None)
+
+    async def relay():
+        async with client:
+            async with client.stream("GET", f"{GO_SERVICE_URL}/events", params=params) as response:
+                async for chunk in response.aiter_raw():
+                    yield chunk
+
+    return StreamingResponse(relay(), media_type="text/event-stream", headers={"Cache-Control": "no-cache"})
+
+@app.get("/templates", response_model=List[Template])
+async def list_templates():
+    """List message templates"""
+    try:
+        async with go_client() as client:
+            response = await client.get(f"{GO_SERVICE_URL}/templates")
+            if response.status_code == 200:
+                return response.json()
+            else:
+                raise HTTPException(status_code=500, detail="Failed to list templates")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.post("/templates", response_model=Template, status_code=201)
+async def create_template(template: TemplateRequest):
+    """Create a message template with {{variable}} placeholders"""
+    try:
+        async with go_client() as client:
+            response = await client.post(f"{GO_SERVICE_URL}/templates", json=template.dict())
+            if response.status_code == 201:
+                return response.json()
+            elif response.status_code == 400:
+                raise HTTPException(status_code=400, detail=response.text.strip())
+            else:
+                raise HTTPException(status_code=500, detail="Failed to create template")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.get("/templates/{template_id}", response_model=Template)
+async def get_template(template_id: str):
+    """Get a message template"""
+    try:
+        async with go_client() as client:
+            response = await client.get(f"{GO_SERVICE_URL}/templates/{template_id}")
+            if response.status_code == 200:
+                return response.json()
+            elif response.status_code == 404:
+                raise HTTPException(status_code=404, detail="Template not found")
+            else:
+                raise HTTPException(status_code=500, detail="Failed to get template")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.put("/templates/{template_id}", response_model=Template)
+async def update_template(template_id: str, template: TemplateRequest):
+    """Replace the name and body of a message template"""
+    try:
+        async with go_client() as client:
+            response = await client.put(f"{GO_SERVICE_URL}/templates/{template_id}", json=template.dict())
+            if response.status_code == 200:
+                return response.json()
+            elif response.status_code in (400, 404):
+                raise HTTPException(status_code=response.status_code, detail=response.text.strip())
+            else:
+                raise HTTPException(status_code=500, detail="Failed to update template")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.delete("/templates/{template_id}", status_code=204)
+async def delete_template(template_id: str):
+    """Delete a message template"""
+    try:
+        async with go_client() as client:
+            response = await client.delete(f"{GO_SERVICE_URL}/templates/{template_id}")
+            if response.status_code == 204:
+                return Response(status_code=204)
+            elif response.status_code == 404:
+                raise HTTPException(status_code=404, detail="Template not found")
+            else:
+                raise HTTPException(status_code=500, detail="Failed to delete template")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.post("/uploads", response_model=Upload, status_code=201)
+async def create_upload(request: CreateUploadRequest):
+    """Start a chunked upload, optionally announcing its total size"""
+    try:
+        async with go_client() as client:
+            response = await client.post(f"{GO_SERVICE_URL}/uploads", json=request.dict())
+            if response.status_code == 201:
+                return response.json()
+            elif response.status_code in (400, 413):
+                raise HTTPException(status_code=response.status_code, detail=response.text.strip())
+            else:
+                raise HTTPException(status_code=500, detail="Failed to create upload")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.get("/uploads/{upload_id}", response_model=Upload)
+async def get_upload(upload_id: str):
+    """Get the progress of a chunked upload, e.g. the offset to resume from"""
+    try:
+        async with go_client() as client:
+            response = await client.get(f"{GO_SERVICE_URL}/uploads/{upload_id}")
+            if response.status_code == 200:
+                return response.json()
+            elif response.status_code == 404:
+                raise HTTPException(status_code=404, detail="Upload not found")
+            else:
+                raise HTTPException(status_code=500, detail="Failed to get upload")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.patch("/uploads/{upload_id}", response_model=Upload)
+async def append_upload(upload_id: str, request: Request, upload_offset: int = Header(...)):
+    """Append the request body to a chunked upload at Upload-Offset"""
+    try:
+        async with go_client(timeout=60.0) as client:
+            response = await client.patch(
+                f"{GO_SERVICE_URL}/uploads/{upload_id}",
+                content=await request.body(),
+                headers={"Upload-Offset": str(upload_offset)}
+            )
+            if response.status_code == 200:
+                return response.json()
+            elif response.status_code in (400, 404, 409, 413):
+                raise HTTPException(status_code=response.status_code, detail=response.text.strip())
+            else:
+                raise HTTPException(status_code=500, detail="Failed to write chunk")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.delete("/uploads/{upload_id}", status_code=204)
+async def delete_upload(upload_id: str):
+    """Discard a chunked upload"""
+    try:
+        async with go_client() as client:
+            response = await client.delete(f"{GO_SERVICE_URL}/uploads/{upload_id}")
+            if response.status_code == 204:
+                return Response(status_code=204)
+            elif response.status_code == 404:
+                raise HTTPException(status_code=404, detail="Upload not found")
+            elif response.status_code == 409:
+                raise HTTPException(status_code=409, detail=response.text.strip())
+            else:
+                raise HTTPException(status_code=500, detail="Failed to delete upload")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.get("/webhooks", response_model=WebhooksResponse)
+async def list_webhooks():
+    """List webhook subscriptions"""
+    try:
+        async with go_client() as client:
+            response = await client.get(f"{GO_SERVICE_URL}/webhooks")
+            if response.status_code == 200:
+                return response.json()
+            else:
+                raise HTTPException(status_code=500, detail="Failed to list webhooks")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.post("/webhooks", response_model=Webhook, status_code=201)
+async def add_webhook(webhook: WebhookCreate):
+    """Subscribe a URL to events (message, receipt, reaction, poll_vote, transcript, presence, call, connection)"""
+    try:
+        async with go_client() as client:
+            response = await client.post(f"{GO_SERVICE_URL}/webhooks", json=webhook.dict())
+            if response.status_code == 201:
+                return response.json()
+            elif response.status_code == 400:
+                raise HTTPException(status_code=400, detail=response.text.strip())
+            else:
+                raise HTTPException(status_code=500, detail="Failed to add webhook")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.delete("/webhooks/{webhook_id}")
+async def delete_webhook(webhook_id: str):
+    """Remove a webhook subscription"""
+    try:
+        async with go_client() as client:
+            response = await client.delete(f"{GO_SERVICE_URL}/webhooks/{webhook_id}")
+            if response.status_code == 200:
+                return {"message": "Webhook deleted successfully"}
+            elif response.status_code == 404:
+                raise HTTPException(status_code=404, detail="Webhook not found")
+            else:
+                raise HTTPException(status_code=500, detail="Failed to delete webhook")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.get("/contacts", response_model=ContactsResponse)
+async def get_contacts():
+    """List contacts with their saved, push and business names"""
+    try:
+        async with go_client() as client:
+            response = await client.get(f"{GO_SERVICE_URL}/contacts")
+            if response.status_code == 200:
+                return response.json()
+            elif response.status_code == 401:
+                raise HTTPException(status_code=401, detail="Not authenticated")
+            else:
+                raise HTTPException(status_code=500, detail="Failed to get contacts")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.post("/contacts/sync", response_model=ContactsResponse)
+async def sync_contacts():
+    """Reload contacts from the device store"""
+    try:
+        async with go_client() as client:
+            response = await client.post(f"{GO_SERVICE_URL}/contacts/sync")
+            if response.status_code == 200:
+                return response.json()
+            elif response.status_code == 401:
+                raise HTTPException(status_code=401, detail="Not authenticated")
+            else:
+                raise HTTPException(status_code=500, detail="Failed to sync contacts")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.get("/check-number", response_model=NumberChecksResponse)
+async def check_number(msisdn: str):
+    """Check whether comma-separated phone numbers are registered on WhatsApp"""
+    try:
+        async with go_client() as client:
+            response = await client.get(f"{GO_SERVICE_URL}/check-number", params={"msisdn": msisdn})
+            if response.status_code == 200:
+                return response.json()
+            elif response.status_code == 401:
+                raise HTTPException(status_code=401, detail="Not authenticated")
+            elif response.status_code in (400, 409):
+                raise HTTPException(status_code=response.status_code, detail=response.text.strip())
+            else:
+                raise HTTPException(status_code=500, detail="Failed to check numbers")
+    except httpx.RequestError:
+        raise HTTPException(status_code=503, detail="Go service unavailable")
+
+@app.get("/chats")
+async def get_chats(
+    locale: Optional[str] = None,
+    archived: Optional[bool] = None,
+    pinned: Optional[bool] = None,
+    accept_language: Optional[str] = Header(None)
+):
+    """Get list of all chats with latest message info, most recent first
+
+    Previews for messages without text are localized using the locale query
+    parameter or the Accept-Language header, defaulting to English.
+    """
+    locale = resolve_locale(locale, accept_language)
+    params = {}
+    if archived is not None:
+        params["archived"] = str(archived).lower()
+    if pinned is not None:
+        params["pinned"] = str(pinned).lower()
+    try:
+        async with go_client() as client:
+            response = await client.get(f"{GO_SERVICE_URL}/chats", params=params)
+            if response.status_code == 200:
+                chats = []
+                for chat in response.json().get("chats", []):
+                    last_message = chat["last_message"]
+                    chats.append({
+                        "chat_id": chat["chat_id"],
+                        "name": chat.get("name"),
+                        "is_group": chat["is_group"],
+                        "latest_message": preview_text(last_message["content"], locale),
+                        "latest_message_id": last_message["id"],
+                        "latest_timestamp": last_message["timestamp"],
+                        "unread_count": chat["unread_count"],
+                        "archived": chat["archived"],
+                        "pinned": chat["pinned"]
+                    })
+                return {"chats": chats}
+            elif response.status_code == 401:
+                raise HTTPException(status_code=401, detail="Not authenticated")
+            elif response.status_code == 400:
+                raise HTTPException(status_code=400, detail=response.text.strip())
             else:
                 raise HTTPException(status_code=500, detail="Failed to get chats")
     except httpx.RequestError:
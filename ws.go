@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// wsWriteWait is how long a single frame may take to write.
+	wsWriteWait = 10 * time.Second
+	// wsPongWait is how long the peer may stay silent before the connection
+	// is considered dead. Pings go out often enough to keep it alive.
+	wsPongWait   = 60 * time.Second
+	wsPingPeriod = wsPongWait * 9 / 10
+	// wsMaxFrameSize bounds inbound frames, which only carry commands.
+	wsMaxFrameSize = 64 << 10
+)
+
+// Browsers are limited to same-origin connections by the default origin
+// check, like they are for the rest of the API.
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+}
+
+// wsRequest is a command sent by the client. ID is echoed back in the
+// response so that clients can match them up.
+type wsRequest struct {
+	ID     string `json:"id,omitempty"`
+	Action string `json:"action"`
+	SendTextRequest
+}
+
+// wsResponse answers a wsRequest. Events are sent as they are on the event
+// stream and can be told apart by their "event" field.
+type wsResponse struct {
+	Type   string        `json:"type"`
+	ID     string        `json:"id,omitempty"`
+	Result *SendResponse `json:"result,omitempty"`
+	Status int           `json:"status,omitempty"`
+	Error  string        `json:"error,omitempty"`
+}
+
+// serveWebSocket pushes events to the client like streamEvents does and
+// accepts commands such as {"action": "send_text", "chat_id": ..., "text": ...}.
+// Commands go through the same checks and rate limit as the REST endpoints.
+func (api *WhatsAppAPI) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	filter := Webhook{Events: splitList(r.URL.Query().Get("events"))}
+
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has already responded.
+		return
+	}
+	log := api.requestLog(r)
+
+	ch := api.events.Subscribe()
+	responses := make(chan wsResponse, 8)
+	// stop tells the writer that the reader is gone, stopped the reverse.
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+
+	// All writes happen on this goroutine, as the connection supports only
+	// one concurrent writer. Closing the connection makes the reader fail.
+	go func() {
+		defer close(stopped)
+		defer conn.Close()
+		ping := time.NewTicker(wsPingPeriod)
+		defer ping.Stop()
+
+		for {
+			var frame interface{}
+			select {
+			case <-stop:
+				return
+			case <-ping.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)); err != nil {
+					return
+				}
+				continue
+			case resp := <-responses:
+				frame = resp
+			case evt, ok := <-ch:
+				if !ok {
+					// The event bus closes on shutdown.
+					conn.WriteControl(websocket.CloseMessage,
+						websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"),
+						time.Now().Add(wsWriteWait))
+					return
+				}
+				if !filter.wants(evt.Type) {
+					continue
+				}
+				frame = evt
+			}
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := conn.WriteJSON(frame); err != nil {
+				return
+			}
+		}
+	}()
+
+	defer func() {
+		close(stop)
+		<-stopped
+		api.events.Unsubscribe(ch)
+	}()
+
+	conn.SetReadLimit(wsMaxFrameSize)
+	conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	for {
+		var req wsRequest
+		if err := conn.ReadJSON(&req); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Debugf("WebSocket closed: %v", err)
+			}
+			return
+		}
+
+		resp := wsResponse{Type: "response", ID: req.ID}
+		switch req.Action {
+		case "send_text":
+			var result SendResponse
+			var err error
+			if connErr := api.checkPaired(); connErr != nil {
+				err = connErr
+			} else {
+				result, err = api.sendText(r.Context(), log, req.SendTextRequest)
+			}
+			if err != nil {
+				resp.Type = "error"
+				resp.Status = http.StatusInternalServerError
+				resp.Error = "Failed to send message"
+				var httpErr *httpError
+				if errors.As(err, &httpErr) {
+					resp.Status = httpErr.status
+					resp.Error = httpErr.msg
+				}
+			} else {
+				resp.Result = &result
+			}
+		default:
+			resp.Type = "error"
+			resp.Status = http.StatusBadRequest
+			resp.Error = "Unknown action " + req.Action
+		}
+
+		select {
+		case responses <- resp:
+		case <-stopped:
+			return
+		}
+	}
+}